@@ -8,7 +8,7 @@ import (
 )
 
 func main() {
-	at := airtable.New(os.Getenv("AIRTABLE_API_KEY"), "app9gXc0ovBSGKOSE", "halkeye")
+	at := airtable.New(os.Getenv("AIRTABLE_API_KEY"), os.Getenv("AIRTABLE_BASE_ID"), "halkeye")
 	err := at.RegisterWebhook("https://dev.g4v.dev/webhook/airtable")
 	if err != nil {
 		panic(err)
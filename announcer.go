@@ -0,0 +1,625 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	helix "github.com/nicklaw5/helix/v2"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/halkeye/twitch_go_online/internal/discordsender"
+	"github.com/halkeye/twitch_go_online/internal/eventstream"
+	"github.com/halkeye/twitch_go_online/internal/roster"
+	"github.com/halkeye/twitch_go_online/internal/shoutout"
+	"github.com/halkeye/twitch_go_online/internal/storage"
+	"github.com/halkeye/twitch_go_online/internal/tokens"
+	"github.com/halkeye/twitch_go_online/internal/tracker"
+)
+
+// gameChangeMessageTmpl is the default GAME_CHANGE_MESSAGE.
+const gameChangeMessageTmpl = `{{.ChannelName}} switched from {{.PreviousGame}} to {{.Game}}
+Channel URL: {{.ChannelUrl}}`
+
+// raidMessageTmpl is the default RAID_MESSAGE.
+const raidMessageTmpl = `{{.FromName}} raided {{.ChannelName}} with {{.Viewers}} viewers!
+Raid train: {{.Chain}}
+Channel URL: {{.ChannelUrl}}`
+
+// activityRecorder keeps track of when roster members stream, outside of the bot.
+type activityRecorder interface {
+	StreamStarted(member roster.Member, userID string, game string, title string, startedAt time.Time)
+	StreamEnded(member roster.Member, userID string, endedAt time.Time)
+}
+
+// announcer handles verified EventSub notifications no matter which transport delivered them.
+// The poller feeds its transitions through here too, so a stream is only announced once.
+type announcer struct {
+	client *helix.Client
+	ds     *discordsender.DiscordSender
+	hub    *eventstream.Hub
+	store  storage.Store
+	// activity is optional, nil leaves the roster alone
+	activity activityRecorder
+	// tracker is optional, nil only knows what a stream looked like when it went live
+	tracker *tracker.Tracker
+	// offlineMessage is what announcements get edited to once the stream ends, empty leaves them alone
+	offlineMessage string
+	// gameChanges is "post" or "edit", empty ignores channel.update
+	gameChanges        string
+	gameChangeMessage  string
+	gameChangeInterval time.Duration
+	// raidMessage announces raids between roster members, empty only records them
+	raidMessage string
+	// raidChainWindow is how long after being raided a raid still continues the same raid train
+	raidChainWindow time.Duration
+	// shoutouts is optional, raided members who opted in shout out their raider through it
+	shoutouts *shoutout.Queue
+	// authorizations is optional, without it follower and subscriber milestones can't be counted
+	authorizations   *tokens.Vault
+	milestoneMessage string
+	// milestone kind => every how many of them gets announced, zero doesn't announce them
+	milestones map[string]int
+	// milestoneMu keeps the running totals from being read and saved by two events at once
+	milestoneMu sync.Mutex
+	// highlightMode is "post" or "append", empty doesn't look for the VOD and clips once a stream ends
+	highlightMode    string
+	highlightMessage string
+	highlightDelay   time.Duration
+	highlightClips   int
+
+	mu sync.Mutex
+	// broadcaster id => id of the stream we last announced
+	live map[string]string
+	// lowercased twitch login => roster entry, for per streamer announcement settings
+	members map[string]roster.Member
+	// twitch user id => roster entry, logins change but ids don't
+	membersByID map[string]roster.Member
+	// broadcaster id => when they were last announced switching games
+	lastGameChange map[string]time.Time
+}
+
+func newAnnouncer(client *helix.Client, ds *discordsender.DiscordSender, hub *eventstream.Hub, store storage.Store) *announcer {
+	return &announcer{
+		client:  client,
+		ds:      ds,
+		hub:     hub,
+		store:   store,
+		live:    map[string]string{},
+		members: map[string]roster.Member{},
+
+		membersByID: map[string]roster.Member{},
+
+		lastGameChange: map[string]time.Time{},
+	}
+}
+
+func (an *announcer) setMembers(members []roster.Member) {
+	byLogin := map[string]roster.Member{}
+	byID := map[string]roster.Member{}
+	for _, member := range members {
+		byLogin[strings.ToLower(member.TwitchLogin)] = member
+		if len(member.ResolvedID) != 0 {
+			byID[member.ResolvedID] = member
+		}
+	}
+
+	an.mu.Lock()
+	defer an.mu.Unlock()
+	an.members = byLogin
+	an.membersByID = byID
+}
+
+// roster returns every member handed to setMembers.
+func (an *announcer) roster() []roster.Member {
+	an.mu.Lock()
+	defer an.mu.Unlock()
+
+	members := []roster.Member{}
+	for _, member := range an.members {
+		members = append(members, member)
+	}
+	sort.Slice(members, func(i, j int) bool { return members[i].TwitchLogin < members[j].TwitchLogin })
+	return members
+}
+
+// member finds the roster entry for a broadcaster, by user id when we know it and by login otherwise.
+func (an *announcer) member(userID string, login string) roster.Member {
+	an.mu.Lock()
+	defer an.mu.Unlock()
+	if member, ok := an.membersByID[userID]; ok {
+		return member
+	}
+	return an.members[strings.ToLower(login)]
+}
+
+func (an *announcer) handle(messageID string, timestamp string, sub helix.EventSubSubscription, event json.RawMessage) error {
+	an.hub.Publish(eventstream.Normalize(messageID, timestamp, sub, event))
+
+	switch sub.Type {
+	case helix.EventSubTypeStreamOnline:
+		var onlineEvent helix.EventSubStreamOnlineEvent
+		if err := json.Unmarshal(event, &onlineEvent); err != nil {
+			return errors.Wrap(err, "unable to decode online event")
+		}
+		log.Printf("got online event for: %s\n", onlineEvent.BroadcasterUserName)
+
+		return an.online(onlineEvent)
+	case helix.EventSubTypeStreamOffline:
+		var offlineEvent helix.EventSubStreamOfflineEvent
+		if err := json.Unmarshal(event, &offlineEvent); err != nil {
+			return errors.Wrap(err, "unable to decode offline event")
+		}
+		log.Printf("got offline event for: %s\n", offlineEvent.BroadcasterUserName)
+
+		return an.offline(offlineEvent)
+	case helix.EventSubTypeChannelUpdate:
+		var updateEvent helix.EventSubChannelUpdateEvent
+		if err := json.Unmarshal(event, &updateEvent); err != nil {
+			return errors.Wrap(err, "unable to decode channel update event")
+		}
+		log.Printf("got channel update event for: %s\n", updateEvent.BroadcasterUserName)
+
+		return an.channelUpdate(updateEvent)
+	case helix.EventSubTypeChannelRaid:
+		var raidEvent helix.EventSubChannelRaidEvent
+		if err := json.Unmarshal(event, &raidEvent); err != nil {
+			return errors.Wrap(err, "unable to decode raid event")
+		}
+		log.Printf("got raid event for: %s => %s\n", raidEvent.FromBroadcasterUserName, raidEvent.ToBroadcasterUserName)
+
+		return an.raid(raidEvent)
+	case helix.EventSubTypeChannelFollow:
+		var followEvent helix.EventSubChannelFollowEvent
+		if err := json.Unmarshal(event, &followEvent); err != nil {
+			return errors.Wrap(err, "unable to decode follow event")
+		}
+		return an.followed(followEvent)
+	case helix.EventSubTypeChannelSubscription:
+		var subscribeEvent helix.EventSubChannelSubscribeEvent
+		if err := json.Unmarshal(event, &subscribeEvent); err != nil {
+			return errors.Wrap(err, "unable to decode subscribe event")
+		}
+		return an.subscribed(subscribeEvent)
+	case helix.EventSubTypeChannelCheer:
+		var cheerEvent helix.EventSubChannelCheerEvent
+		if err := json.Unmarshal(event, &cheerEvent); err != nil {
+			return errors.Wrap(err, "unable to decode cheer event")
+		}
+		return an.cheered(cheerEvent)
+	default:
+		log.Errorf("error: event type %s has not been implemented -- pull requests welcome!", sub.Type)
+	}
+	return nil
+}
+
+func (an *announcer) online(onlineEvent helix.EventSubStreamOnlineEvent) error {
+	broadcasterID, broadcasterName := onlineEvent.BroadcasterUserID, onlineEvent.BroadcasterUserName
+
+	// claim the stream before doing anything slow, eventsub and the poller can race each other
+	an.mu.Lock()
+	if len(onlineEvent.ID) != 0 && (an.live[broadcasterID] == onlineEvent.ID || an.store.Announced(onlineEvent.ID)) {
+		an.live[broadcasterID] = onlineEvent.ID
+		an.mu.Unlock()
+		log.Infof("stream %s for %s was already announced", onlineEvent.ID, broadcasterName)
+		return nil
+	}
+	previous, hadPrevious := an.live[broadcasterID]
+	an.live[broadcasterID] = onlineEvent.ID
+	an.mu.Unlock()
+
+	stream, sent, err := an.announce(broadcasterID, broadcasterName)
+	if err != nil {
+		an.mu.Lock()
+		if hadPrevious {
+			an.live[broadcasterID] = previous
+		} else {
+			delete(an.live, broadcasterID)
+		}
+		an.mu.Unlock()
+		return err
+	}
+
+	if err := an.store.StartSession(session(*stream)); err != nil {
+		log.Error(err)
+	}
+	if an.tracker != nil {
+		an.tracker.Track(*stream)
+	}
+	if err := an.store.MarkAnnounced(stream.ID, broadcasterID, time.Now()); err != nil {
+		log.Error(err)
+	}
+	if sent != nil {
+		err := an.store.RecordAnnouncement(storage.Announcement{
+			StreamID:    stream.ID,
+			Destination: sent.Webhook,
+			MessageID:   sent.MessageID,
+			SentAt:      time.Now(),
+		})
+		if err != nil {
+			log.Error(err)
+		}
+	}
+	if an.activity != nil {
+		if member := an.member(stream.UserID, stream.UserLogin); len(member.RecordID) != 0 {
+			an.activity.StreamStarted(member, stream.UserID, stream.GameName, stream.Title, stream.StartedAt)
+		}
+	}
+	return nil
+}
+
+// record remembers stream as announced without telling anyone about it.
+func (an *announcer) record(stream helix.Stream) error {
+	an.mu.Lock()
+	an.live[stream.UserID] = stream.ID
+	an.mu.Unlock()
+
+	if err := an.store.StartSession(session(stream)); err != nil {
+		return err
+	}
+	if an.tracker != nil {
+		an.tracker.Track(stream)
+	}
+	return an.store.MarkAnnounced(stream.ID, stream.UserID, time.Now())
+}
+
+// resume picks a stream announced before a restart back up, so its updates and viewer samples aren't lost.
+func (an *announcer) resume(stream helix.Stream) {
+	an.mu.Lock()
+	an.live[stream.UserID] = stream.ID
+	an.mu.Unlock()
+
+	if an.tracker != nil {
+		an.tracker.Track(stream)
+	}
+}
+
+func (an *announcer) offline(offlineEvent helix.EventSubStreamOfflineEvent) error {
+	broadcasterID := offlineEvent.BroadcasterUserID
+
+	an.mu.Lock()
+	delete(an.live, broadcasterID)
+	an.mu.Unlock()
+
+	if an.tracker != nil {
+		an.tracker.Untrack(broadcasterID)
+	}
+
+	member := an.member(broadcasterID, offlineEvent.BroadcasterUserLogin)
+	if an.activity != nil && len(member.RecordID) != 0 {
+		an.activity.StreamEnded(member, broadcasterID, time.Now())
+	}
+
+	ended, ok, err := an.store.EndSession(broadcasterID, time.Now())
+	if err != nil {
+		return err
+	}
+	if ok && len(an.highlightMode) != 0 {
+		// twitch needs a moment to finish the VOD, and people keep clipping the end of the stream
+		time.AfterFunc(an.highlightDelay, func() {
+			if err := an.highlights(ended); err != nil {
+				log.Error(err)
+			}
+		})
+	}
+	if !ok || len(an.offlineMessage) == 0 {
+		return nil
+	}
+
+	announcements, err := an.store.Announcements(ended.StreamID)
+	if err != nil {
+		return err
+	}
+	tmplParams := an.tmplParams(member, ended.BroadcasterLogin, ended.BroadcasterName, ended.Game)
+	tmplParams["Title"] = escapeMarkdown(ended.Title)
+	tmplParams["Duration"] = formatDuration(ended.EndedAt.Sub(ended.StartedAt))
+	tmplParams["PeakViewers"] = fmt.Sprint(ended.PeakViewers)
+	tmplParams["AverageViewers"] = fmt.Sprint(ended.AverageViewers)
+	changes, err := an.store.Changes(ended.StreamID)
+	if err != nil {
+		log.Error(err)
+	}
+	tmplParams["Games"], tmplParams["Changes"] = summarizeChanges(ended, changes)
+	for _, announcement := range announcements {
+		if len(announcement.MessageID) == 0 {
+			continue
+		}
+		sent := discordsender.Sent{Webhook: announcement.Destination, MessageID: announcement.MessageID}
+		if err := an.ds.EditMessage(sent, an.offlineMessage, tmplParams); err != nil {
+			log.Error(errors.Wrapf(err, "unable to edit announcement of %s", ended.StreamID))
+		}
+	}
+	return nil
+}
+
+// channelUpdate records a live stream changing game or title, and announces the game switch if the streamer opted in.
+func (an *announcer) channelUpdate(updateEvent helix.EventSubChannelUpdateEvent) error {
+	broadcasterID := updateEvent.BroadcasterUserID
+
+	an.mu.Lock()
+	streamID, isLive := an.live[broadcasterID]
+	an.mu.Unlock()
+	// offline channels edit their info all the time, there's nothing to say about it
+	if !isLive || len(streamID) == 0 {
+		return nil
+	}
+
+	current, ok, err := an.store.Session(streamID)
+	if err != nil || !ok {
+		return err
+	}
+	if current.Game == updateEvent.CategoryName && current.Title == updateEvent.Title {
+		return nil
+	}
+	err = an.store.RecordChange(storage.StreamChange{
+		StreamID: streamID,
+		At:       time.Now(),
+		Game:     updateEvent.CategoryName,
+		Title:    updateEvent.Title,
+	})
+	if err != nil {
+		return err
+	}
+	if an.tracker != nil {
+		an.tracker.Changed(broadcasterID, updateEvent.CategoryName, updateEvent.Title)
+	}
+
+	member := an.member(broadcasterID, updateEvent.BroadcasterUserLogin)
+	if current.Game == updateEvent.CategoryName || len(an.gameChanges) == 0 || !member.GameChanges {
+		return nil
+	}
+
+	an.mu.Lock()
+	if last, ok := an.lastGameChange[broadcasterID]; ok && time.Since(last) < an.gameChangeInterval {
+		an.mu.Unlock()
+		log.Infof("%s switched to %s too soon after their last switch, not announcing it", updateEvent.BroadcasterUserLogin, updateEvent.CategoryName)
+		return nil
+	}
+	an.lastGameChange[broadcasterID] = time.Now()
+	an.mu.Unlock()
+
+	tmplParams := an.tmplParams(member, updateEvent.BroadcasterUserLogin, updateEvent.BroadcasterUserName, updateEvent.CategoryName)
+	tmplParams["PreviousGame"] = escapeMarkdown(current.Game)
+	tmplParams["Title"] = escapeMarkdown(updateEvent.Title)
+
+	if an.gameChanges == "edit" {
+		announcements, err := an.store.Announcements(streamID)
+		if err != nil {
+			return err
+		}
+		for _, announcement := range announcements {
+			if len(announcement.MessageID) == 0 {
+				continue
+			}
+			sent := discordsender.Sent{Webhook: announcement.Destination, MessageID: announcement.MessageID}
+			if err := an.ds.EditMessage(sent, member.Message, tmplParams); err != nil {
+				log.Error(errors.Wrapf(err, "unable to edit announcement of %s", streamID))
+			}
+		}
+		return nil
+	}
+
+	msg := discordsender.Message{Template: an.gameChangeMessage, Webhook: member.Channel}
+	if _, err := an.ds.SendMessage(msg, tmplParams); err != nil {
+		return errors.Wrap(err, "unable to announce game change")
+	}
+	return nil
+}
+
+// raid records one roster member raiding another, as part of a raid train if they were raided themselves not long ago.
+func (an *announcer) raid(raidEvent helix.EventSubChannelRaidEvent) error {
+	from := an.member(raidEvent.FromBroadcasterUserID, raidEvent.FromBroadcasterUserLogin)
+	to := an.member(raidEvent.ToBroadcasterUserID, raidEvent.ToBroadcasterUserLogin)
+	if len(from.TwitchLogin) == 0 || len(to.TwitchLogin) == 0 {
+		log.Infof("%s raided %s, who isn't on the roster", raidEvent.FromBroadcasterUserLogin, raidEvent.ToBroadcasterUserLogin)
+		return nil
+	}
+
+	now := time.Now()
+	raid := storage.Raid{
+		FromBroadcasterID: raidEvent.FromBroadcasterUserID,
+		FromLogin:         raidEvent.FromBroadcasterUserLogin,
+		FromName:          raidEvent.FromBroadcasterUserName,
+		ToBroadcasterID:   raidEvent.ToBroadcasterUserID,
+		ToLogin:           raidEvent.ToBroadcasterUserLogin,
+		ToName:            raidEvent.ToBroadcasterUserName,
+		Viewers:           raidEvent.Viewers,
+		At:                now,
+	}
+	previous, ok, err := an.store.LastRaidTo(raid.FromBroadcasterID, now.Add(-an.raidChainWindow))
+	if err != nil {
+		return err
+	}
+	if ok {
+		raid.ChainID = previous.ChainID
+	}
+	if raid, err = an.store.RecordRaid(raid); err != nil {
+		return err
+	}
+	if an.shoutouts != nil && to.Shoutouts {
+		an.shoutouts.Add(shoutout.Shoutout{
+			FromBroadcasterID: raid.ToBroadcasterID,
+			FromLogin:         raid.ToLogin,
+			ToBroadcasterID:   raid.FromBroadcasterID,
+			ToLogin:           raid.FromLogin,
+			QueuedAt:          now,
+		})
+	}
+	if len(an.raidMessage) == 0 {
+		return nil
+	}
+
+	chain, err := an.store.RaidChain(raid.ChainID)
+	if err != nil {
+		return err
+	}
+	tmplParams := an.tmplParams(to, raid.ToLogin, raid.ToName, "")
+	// already escaped by tmplParams
+	tmplParams["FromName"] = an.tmplParams(from, raid.FromLogin, raid.FromName, "")["ChannelName"]
+	tmplParams["Viewers"] = fmt.Sprint(raid.Viewers)
+	tmplParams["Chain"] = raidChain(chain)
+	tmplParams["ChainLength"] = fmt.Sprint(len(chain))
+
+	msg := discordsender.Message{Template: an.raidMessage, Webhook: to.Channel}
+	if _, err := an.ds.SendMessage(msg, tmplParams); err != nil {
+		return errors.Wrap(err, "unable to announce raid")
+	}
+	return nil
+}
+
+// raidChain reads like alice → bob → carol.
+func raidChain(chain []storage.Raid) string {
+	if len(chain) == 0 {
+		return ""
+	}
+	names := []string{escapeMarkdown(raidName(chain[0].FromName, chain[0].FromLogin))}
+	for _, raid := range chain {
+		names = append(names, escapeMarkdown(raidName(raid.ToName, raid.ToLogin)))
+	}
+	return strings.Join(names, " → ")
+}
+
+func raidName(name string, login string) string {
+	if len(name) != 0 {
+		return name
+	}
+	return login
+}
+
+func (an *announcer) announce(broadcasterID string, broadcasterName string) (*helix.Stream, *discordsender.Sent, error) {
+	stream, err := fetchStreamInfo(an.client, broadcasterID)
+	if err != nil {
+		log.Error(err)
+		return nil, nil, errors.Wrap(err, fmt.Sprintf("Error fetching stream info for %s (uid: %s)", broadcasterName, broadcasterID))
+	}
+
+	member := an.member(stream.UserID, stream.UserLogin)
+	msg := discordsender.Message{
+		Template: member.Message,
+		RoleID:   member.DiscordRole,
+		Webhook:  member.Channel,
+	}
+	sent, err := an.ds.SendMessage(msg, an.tmplParams(member, stream.UserLogin, stream.UserName, stream.GameName))
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "unable to send webhook")
+	}
+	return stream, sent, nil
+}
+
+// tmplParams are what every message template about a streamer gets to use.
+func (an *announcer) tmplParams(member roster.Member, login string, name string, game string) map[string]string {
+	channelName := name
+	if len(member.DisplayName) != 0 {
+		channelName = member.DisplayName
+	}
+	if len(channelName) == 0 {
+		channelName = login
+	}
+
+	return map[string]string{
+		"Game":        escapeMarkdown(game),
+		"ChannelName": escapeMarkdown(channelName),
+		"ChannelUrl":  fmt.Sprintf("https://www.twitch.tv/%s", login),
+		"Tags":        escapeMarkdown(strings.Join(member.Tags, ", ")),
+	}
+}
+
+func session(stream helix.Stream) storage.Session {
+	return storage.Session{
+		StreamID:         stream.ID,
+		BroadcasterID:    stream.UserID,
+		BroadcasterLogin: stream.UserLogin,
+		BroadcasterName:  stream.UserName,
+		StartedAt:        stream.StartedAt,
+		Game:             stream.GameName,
+		Title:            stream.Title,
+	}
+}
+
+// summarizeChanges lists the games played in order, and a line per game or title change.
+func summarizeChanges(ended storage.Session, changes []storage.StreamChange) (games string, lines string) {
+	if len(changes) == 0 {
+		return escapeMarkdown(ended.Game), ""
+	}
+
+	played := []string{}
+	seen := map[string]bool{}
+	list := []string{}
+	for _, change := range changes {
+		if len(change.Game) != 0 && !seen[change.Game] {
+			seen[change.Game] = true
+			played = append(played, escapeMarkdown(change.Game))
+		}
+		offset := change.At.Sub(ended.StartedAt)
+		if offset < 0 {
+			offset = 0
+		}
+		list = append(list, fmt.Sprintf("%s: %s - %s", formatDuration(offset), escapeMarkdown(change.Game), escapeMarkdown(change.Title)))
+	}
+	return strings.Join(played, ", "), strings.Join(list, "\n")
+}
+
+// formatDuration reads like 2h 15m, streams don't need seconds.
+func formatDuration(d time.Duration) string {
+	d = d.Round(time.Minute)
+	hours, minutes := int(d.Hours()), int(d.Minutes())%60
+	if hours == 0 {
+		return fmt.Sprintf("%dm", minutes)
+	}
+	return fmt.Sprintf("%dh %dm", hours, minutes)
+}
+
+// revoked records twitch giving up on one of our subscriptions.
+func (an *announcer) revoked(sub helix.EventSubSubscription) {
+	if err := an.store.UpdateSubscriptionStatus(sub.ID, sub.Status); err != nil {
+		log.Error(err)
+	}
+	// their tokens stopped working along with the subscription
+	if sub.Status == "authorization_revoked" && needsAuthorization(sub.Type) && an.authorizations != nil {
+		if err := an.authorizations.Delete(subscriptionUser(sub)); err != nil {
+			log.Error(err)
+		}
+	}
+}
+
+// polled turns a transition spotted by the poller into the notification eventsub would have sent.
+func (an *announcer) polled(subType string, stream helix.Stream) {
+	var event interface{}
+	if subType == helix.EventSubTypeStreamOnline {
+		event = helix.EventSubStreamOnlineEvent{
+			ID:                   stream.ID,
+			BroadcasterUserID:    stream.UserID,
+			BroadcasterUserLogin: stream.UserLogin,
+			BroadcasterUserName:  stream.UserName,
+			Type:                 stream.Type,
+			StartedAt:            helix.Time{Time: stream.StartedAt},
+		}
+	} else {
+		event = helix.EventSubStreamOfflineEvent{
+			BroadcasterUserID:    stream.UserID,
+			BroadcasterUserLogin: stream.UserLogin,
+			BroadcasterUserName:  stream.UserName,
+		}
+	}
+
+	payload, err := json.Marshal(event)
+	if err != nil {
+		log.Error(errors.Wrap(err, "unable to encode polled event"))
+		return
+	}
+
+	sub := helix.EventSubSubscription{
+		Type:      subType,
+		Version:   "1",
+		Condition: helix.EventSubCondition{BroadcasterUserID: stream.UserID},
+		Transport: helix.EventSubTransport{Method: "polling"},
+	}
+	messageID := fmt.Sprintf("poll-%s-%s", subType, stream.ID)
+	if err := an.handle(messageID, time.Now().Format(time.RFC3339Nano), sub, payload); err != nil {
+		log.Error(err)
+	}
+}
@@ -0,0 +1,303 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	helix "github.com/nicklaw5/helix/v2"
+
+	"github.com/halkeye/twitch_go_online/internal/discordsender"
+	"github.com/halkeye/twitch_go_online/internal/roster"
+	"github.com/halkeye/twitch_go_online/internal/shoutout"
+	"github.com/halkeye/twitch_go_online/internal/storage"
+)
+
+func TestChannelUpdate(t *testing.T) {
+	var posted []string
+	discord := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body struct {
+			Content string `json:"content"`
+		}
+		_ = json.NewDecoder(r.Body).Decode(&body)
+		posted = append(posted, body.Content)
+		_, _ = w.Write([]byte(`{"id":"1"}`))
+	}))
+	defer discord.Close()
+
+	store, err := storage.OpenSQLite(filepath.Join(t.TempDir(), "storage.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	an := newAnnouncer(nil, discordsender.New(discord.URL, ""), nil, store)
+	an.gameChanges = "post"
+	an.gameChangeMessage = "{{.ChannelName}}: {{.PreviousGame}} to {{.Game}}"
+	an.gameChangeInterval = time.Hour
+	an.setMembers([]roster.Member{
+		{TwitchLogin: "halkeye", ResolvedID: "1", GameChanges: true},
+		{TwitchLogin: "quiet", ResolvedID: "2"},
+	})
+	for _, session := range []storage.Session{
+		{StreamID: "s1", BroadcasterID: "1", StartedAt: time.Now(), Game: "Art", Title: "drawing"},
+		{StreamID: "s2", BroadcasterID: "2", StartedAt: time.Now(), Game: "Art", Title: "drawing"},
+	} {
+		if err := store.StartSession(session); err != nil {
+			t.Fatal(err)
+		}
+		an.live[session.BroadcasterID] = session.StreamID
+	}
+
+	update := func(id string, login string, game string, title string) {
+		err := an.channelUpdate(helix.EventSubChannelUpdateEvent{BroadcasterUserID: id, BroadcasterUserLogin: login, CategoryName: game, Title: title})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	update("1", "halkeye", "Art", "still drawing") // just the title
+	update("1", "halkeye", "Chess", "still drawing")
+	update("1", "halkeye", "Music", "still drawing") // too soon
+	update("2", "quiet", "Chess", "drawing")         // didn't opt in
+	update("3", "offline", "Chess", "drawing")       // not live
+
+	if len(posted) != 1 || posted[0] != "halkeye: Art to Chess" {
+		t.Errorf("posted = %q; want only the switch to Chess", posted)
+	}
+
+	changes, err := store.Changes("s1")
+	if err != nil || len(changes) != 3 {
+		t.Errorf("Changes() = %+v, %v; want all three recorded", changes, err)
+	}
+	if session, _, _ := store.Session("s2"); session.Game != "Chess" {
+		t.Errorf("quiet's session = %+v; want it to be playing Chess", session)
+	}
+}
+
+func TestRaid(t *testing.T) {
+	var posted []string
+	discord := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body struct {
+			Content string `json:"content"`
+		}
+		_ = json.NewDecoder(r.Body).Decode(&body)
+		posted = append(posted, body.Content)
+		_, _ = w.Write([]byte(`{"id":"1"}`))
+	}))
+	defer discord.Close()
+
+	var shoutouts []string
+	twitch := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		shoutouts = append(shoutouts, r.URL.Query().Get("from_broadcaster_id")+">"+r.URL.Query().Get("to_broadcaster_id"))
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer twitch.Close()
+	client, err := helix.NewClient(&helix.Options{ClientID: "id", APIBaseURL: twitch.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	store, err := storage.OpenSQLite(filepath.Join(t.TempDir(), "storage.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	an := newAnnouncer(nil, discordsender.New(discord.URL, ""), nil, store)
+	an.shoutouts = shoutout.New(client, "99", time.Hour)
+	an.raidMessage = "{{.FromName}} raided {{.ChannelName}} ({{.ChainLength}}): {{.Chain}}"
+	an.raidChainWindow = time.Hour
+	an.setMembers([]roster.Member{
+		{TwitchLogin: "alice", ResolvedID: "1"},
+		{TwitchLogin: "bob", ResolvedID: "2"},
+		{TwitchLogin: "carol", ResolvedID: "3", DisplayName: "Carol", Shoutouts: true},
+	})
+
+	raid := func(from string, fromLogin string, to string, toLogin string) {
+		err := an.raid(helix.EventSubChannelRaidEvent{
+			FromBroadcasterUserID: from, FromBroadcasterUserLogin: fromLogin,
+			ToBroadcasterUserID: to, ToBroadcasterUserLogin: toLogin, Viewers: 5,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	raid("1", "alice", "2", "bob")
+	raid("2", "bob", "3", "carol")
+	raid("3", "carol", "9", "stranger")
+
+	want := []string{"alice raided bob (1): alice → bob", "bob raided Carol (2): alice → bob → carol"}
+	if len(posted) != 2 || posted[0] != want[0] || posted[1] != want[1] {
+		t.Errorf("posted = %q; want %q", posted, want)
+	}
+
+	raids, err := store.Raids(time.Now().Add(-time.Hour))
+	if err != nil || len(raids) != 2 || raids[0].ChainID != raids[1].ChainID {
+		t.Errorf("Raids() = %+v, %v; want one chain of two raids", raids, err)
+	}
+
+	an.shoutouts.Send(time.Now())
+	if len(shoutouts) != 1 || shoutouts[0] != "3>2" {
+		t.Errorf("shoutouts = %q; want only carol, who opted in, shouting out bob", shoutouts)
+	}
+}
+
+func TestMilestone(t *testing.T) {
+	var mu sync.Mutex
+	var posted []string
+	discord := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body struct {
+			Content string `json:"content"`
+		}
+		_ = json.NewDecoder(r.Body).Decode(&body)
+		mu.Lock()
+		posted = append(posted, body.Content)
+		mu.Unlock()
+		_, _ = w.Write([]byte(`{"id":"1"}`))
+	}))
+	defer discord.Close()
+
+	store, err := storage.OpenSQLite(filepath.Join(t.TempDir(), "storage.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	an := newAnnouncer(nil, discordsender.New(discord.URL, ""), nil, store)
+	an.milestoneMessage = "{{.ChannelName}} {{.Milestone}} {{.Kind}}"
+	an.milestones = map[string]int{milestoneFollowers: 100, milestoneBits: 1000}
+	an.setMembers([]roster.Member{{TwitchLogin: "halkeye", ResolvedID: "1"}})
+
+	for _, count := range []int{250, 299, 301, 298, 305} {
+		if err := an.milestone("1", "halkeye", "", milestoneFollowers, total(count)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, bits := range []int{600, 600} {
+		if err := an.cheered(helix.EventSubChannelCheerEvent{BroadcasterUserID: "1", BroadcasterUserLogin: "halkeye", Bits: bits}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// nobody asked for subscriber milestones
+	if err := an.milestone("1", "halkeye", "", milestoneSubscribers, total(1000)); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{"halkeye 300 followers", "halkeye 1000 bits cheered"}
+	if len(posted) != 2 || posted[0] != want[0] || posted[1] != want[1] {
+		t.Errorf("posted = %q; want %q", posted, want)
+	}
+
+	// cheers arriving together all count, and the step they pass is announced once
+	var wg sync.WaitGroup
+	for i := 0; i < 16; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := an.cheered(helix.EventSubChannelCheerEvent{BroadcasterUserID: "1", BroadcasterUserLogin: "halkeye", Bits: 50}); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+	if bits, _, err := store.Milestone("1", milestoneBits); err != nil || bits.Total != 2000 {
+		t.Errorf("bits = %+v, %v; want 2000 of them", bits, err)
+	}
+	if len(posted) != 3 || posted[2] != "halkeye 2000 bits cheered" {
+		t.Errorf("posted = %q; want 2000 bits cheered announced once", posted)
+	}
+}
+
+func TestHighlights(t *testing.T) {
+	var requests []string
+	discord := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body struct {
+			Content string `json:"content"`
+		}
+		_ = json.NewDecoder(r.Body).Decode(&body)
+		requests = append(requests, r.Method+" "+r.URL.Path+" "+body.Content)
+		_, _ = w.Write([]byte(`{"id":"1","content":"halkeye went live"}`))
+	}))
+	defer discord.Close()
+
+	started := time.Date(2024, 1, 2, 18, 0, 0, 0, time.UTC)
+	twitch := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/videos":
+			_ = json.NewEncoder(w).Encode(map[string]interface{}{"data": []helix.Video{
+				{ID: "v2", StreamID: "s2", URL: "https://www.twitch.tv/videos/v2"},
+				{ID: "v1", StreamID: "s1", URL: "https://www.twitch.tv/videos/v1"},
+			}})
+		case "/clips":
+			if r.URL.Query().Get("started_at") != started.Format(time.RFC3339) {
+				t.Errorf("clips started_at = %s", r.URL.Query().Get("started_at"))
+			}
+			_ = json.NewEncoder(w).Encode(map[string]interface{}{"data": []helix.Clip{
+				{Title: "meh", ViewCount: 1, URL: "https://clips.twitch.tv/meh"},
+				{Title: "wow", ViewCount: 50, URL: "https://clips.twitch.tv/wow"},
+				{Title: "nice", ViewCount: 10, URL: "https://clips.twitch.tv/nice"},
+			}})
+		}
+	}))
+	defer twitch.Close()
+
+	client, err := helix.NewClient(&helix.Options{ClientID: "id", APIBaseURL: twitch.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	store, err := storage.OpenSQLite(filepath.Join(t.TempDir(), "storage.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	if err := store.RecordAnnouncement(storage.Announcement{StreamID: "s1", Destination: discord.URL + "/hook", MessageID: "99", SentAt: started}); err != nil {
+		t.Fatal(err)
+	}
+
+	an := newAnnouncer(client, discordsender.New(discord.URL, ""), nil, store)
+	an.highlightMessage = "{{.Vod}}\n{{.Clips}}"
+	an.highlightClips = 2
+	ended := storage.Session{StreamID: "s1", BroadcasterID: "1", BroadcasterLogin: "halkeye", StartedAt: started, EndedAt: started.Add(2 * time.Hour)}
+	highlights := "https://www.twitch.tv/videos/v1\n- wow (50 views) https://clips.twitch.tv/wow\n- nice (10 views) https://clips.twitch.tv/nice"
+
+	an.highlightMode = "post"
+	if err := an.highlights(ended); err != nil {
+		t.Fatal(err)
+	}
+	if len(requests) != 1 || requests[0] != "POST /hook "+highlights {
+		t.Errorf("post requests = %q", requests)
+	}
+
+	requests = nil
+	an.highlightMode = "append"
+	if err := an.highlights(ended); err != nil {
+		t.Fatal(err)
+	}
+	if len(requests) != 2 || requests[0] != "GET /hook/messages/99 " || requests[1] != "PATCH /hook/messages/99 halkeye went live\n\n"+highlights {
+		t.Errorf("append requests = %q", requests)
+	}
+}
+
+func TestSubscriptionTypesFor(t *testing.T) {
+	authorized := map[string]bool{"1": true}
+	webhook := helix.EventSubTransport{Method: "webhook"}
+
+	if types := subscriptionTypesFor("1", authorized, webhook); len(types) != len(subscriptionTypes)+len(authorizedSubscriptionTypes) {
+		t.Errorf("authorized member gets %v", types)
+	}
+	if types := subscriptionTypesFor("2", authorized, webhook); len(types) != len(subscriptionTypes) {
+		t.Errorf("member who didn't authorize gets %v", types)
+	}
+	if types := subscriptionTypesFor("1", authorized, helix.EventSubTransport{Method: "websocket"}); len(types) != len(subscriptionTypes) {
+		t.Errorf("websocket session gets %v", types)
+	}
+
+	follow := subscriptionCondition(helix.EventSubTypeChannelFollow, "1")
+	if follow.BroadcasterUserID != "1" || follow.ModeratorUserID != "1" || subscriptionVersion(helix.EventSubTypeChannelFollow) != "2" {
+		t.Errorf("follow subscription = %+v version %s", follow, subscriptionVersion(helix.EventSubTypeChannelFollow))
+	}
+}
@@ -0,0 +1,302 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	helix "github.com/nicklaw5/helix/v2"
+	"github.com/pkg/errors"
+
+	"github.com/halkeye/twitch_go_online/internal/roster"
+	"github.com/halkeye/twitch_go_online/internal/stats"
+	"github.com/halkeye/twitch_go_online/internal/storage"
+)
+
+// defaultPeriod is how far back the api looks without ?days or ?since
+const defaultPeriod = 30 * 24 * time.Hour
+
+// api reports on the recorded stream sessions, as json or with ?format=csv as csv.
+type api struct {
+	token  string
+	store  storage.Store
+	client *helix.Client
+	an     *announcer
+}
+
+func (a *api) authorized(r *http.Request) bool {
+	if len(a.token) == 0 {
+		return false
+	}
+
+	provided := r.URL.Query().Get("token")
+	if auth := r.Header.Get("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+		provided = strings.TrimPrefix(auth, "Bearer ")
+	}
+
+	return subtle.ConstantTimeCompare([]byte(provided), []byte(a.token)) == 1
+}
+
+// period reads ?days=N, or ?since= and ?until= as dates or RFC3339 times.
+func period(r *http.Request, now time.Time) (since time.Time, until time.Time, err error) {
+	query := r.URL.Query()
+	since, until = now.Add(-defaultPeriod), now
+
+	if days := query.Get("days"); len(days) != 0 {
+		n, err := strconv.Atoi(days)
+		if err != nil || n <= 0 {
+			return since, until, errors.Errorf("invalid days %q", days)
+		}
+		since = now.Add(-time.Duration(n) * 24 * time.Hour)
+	}
+	for name, value := range map[string]*time.Time{"since": &since, "until": &until} {
+		raw := query.Get(name)
+		if len(raw) == 0 {
+			continue
+		}
+		parsed, err := time.Parse(time.RFC3339, raw)
+		if err != nil {
+			parsed, err = time.Parse("2006-01-02", raw)
+		}
+		if err != nil {
+			return since, until, errors.Errorf("invalid %s %q", name, raw)
+		}
+		*value = parsed
+	}
+	return since, until, nil
+}
+
+func wantsCSV(r *http.Request) bool {
+	return r.URL.Query().Get("format") == "csv" || strings.Contains(r.Header.Get("Accept"), "text/csv")
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		panic(errors.Wrap(err, "unable to write response"))
+	}
+}
+
+func writeCSV(w http.ResponseWriter, filename string, rows [][]string) {
+	w.Header().Set("Content-Type", "text/csv")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+	if err := csv.NewWriter(w).WriteAll(rows); err != nil {
+		panic(errors.Wrap(err, "unable to write response"))
+	}
+}
+
+// broadcasterID finds the user id behind login, from the roster when we can.
+func (a *api) broadcasterID(login string) (string, error) {
+	login = roster.NormalizeLogin(login)
+	if member := a.an.member("", login); len(member.ResolvedID) != 0 {
+		return member.ResolvedID, nil
+	}
+
+	users, err := lookupUsers(a.client, []string{login})
+	if err != nil || len(users) == 0 {
+		return "", err
+	}
+	return users[0].ID, nil
+}
+
+func hours(d time.Duration) string {
+	return strconv.FormatFloat(d.Hours(), 'f', 2, 64)
+}
+
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339)
+}
+
+// sessionsHandler serves GET /api/streamers/{login}/sessions
+func (a *api) sessionsHandler() http.HandlerFunc {
+	type sessionJSON struct {
+		StreamID  string  `json:"stream_id"`
+		StartedAt string  `json:"started_at"`
+		EndedAt   string  `json:"ended_at,omitempty"`
+		Hours     float64 `json:"hours"`
+		Game      string  `json:"game"`
+		Title     string  `json:"title"`
+		// viewers are zero for streams nobody sampled
+		PeakViewers    int `json:"peak_viewers"`
+		AverageViewers int `json:"average_viewers"`
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !a.authorized(r) {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		now := time.Now()
+		since, until, err := period(r, now)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		login := r.PathValue("login")
+		broadcasterID, err := a.broadcasterID(login)
+		if err != nil {
+			panic(err)
+		}
+		if len(broadcasterID) == 0 {
+			http.Error(w, "no such streamer", http.StatusNotFound)
+			return
+		}
+
+		sessions, err := a.store.Sessions(broadcasterID, since)
+		if err != nil {
+			panic(err)
+		}
+
+		list := []sessionJSON{}
+		rows := [][]string{{"stream_id", "login", "started_at", "ended_at", "hours", "game", "title", "peak_viewers", "average_viewers"}}
+		for _, session := range sessions {
+			if session.StartedAt.After(until) {
+				continue
+			}
+			duration := stats.Duration(session, until, now)
+			list = append(list, sessionJSON{
+				StreamID:  session.StreamID,
+				StartedAt: formatTime(session.StartedAt),
+				EndedAt:   formatTime(session.EndedAt),
+				Hours:     duration.Round(36 * time.Second).Hours(),
+				Game:      session.Game,
+				Title:     session.Title,
+
+				PeakViewers:    session.PeakViewers,
+				AverageViewers: session.AverageViewers,
+			})
+			rows = append(rows, []string{session.StreamID, session.BroadcasterLogin, formatTime(session.StartedAt),
+				formatTime(session.EndedAt), hours(duration), session.Game, session.Title,
+				strconv.Itoa(session.PeakViewers), strconv.Itoa(session.AverageViewers)})
+		}
+
+		if wantsCSV(r) {
+			writeCSV(w, login+"-sessions.csv", rows)
+			return
+		}
+		writeJSON(w, map[string]interface{}{
+			"login":    roster.NormalizeLogin(login),
+			"since":    since,
+			"until":    until,
+			"sessions": list,
+		})
+	})
+}
+
+// statsHandler serves GET /api/stats
+func (a *api) statsHandler() http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !a.authorized(r) {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		now := time.Now()
+		since, until, err := period(r, now)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		sessions, err := a.store.Sessions("", since)
+		if err != nil {
+			panic(err)
+		}
+		changes, err := stats.Changes(a.store, sessions)
+		if err != nil {
+			panic(err)
+		}
+		report := stats.Compute(sessions, changes, since, until, now)
+
+		if !wantsCSV(r) {
+			writeJSON(w, report)
+			return
+		}
+		rows := [][]string{{"login", "sessions", "hours", "average_hours", "longest_streak", "current_streak", "top_game"}}
+		for _, streamer := range report.Streamers {
+			topGame := ""
+			if len(streamer.TopGames) != 0 {
+				topGame = streamer.TopGames[0].Game
+			}
+			rows = append(rows, []string{
+				streamer.Login,
+				strconv.Itoa(streamer.Sessions),
+				strconv.FormatFloat(streamer.Hours, 'f', 2, 64),
+				strconv.FormatFloat(streamer.AverageHours, 'f', 2, 64),
+				strconv.Itoa(streamer.LongestStreak),
+				strconv.Itoa(streamer.CurrentStreak),
+				topGame,
+			})
+		}
+		writeCSV(w, "stats.csv", rows)
+	})
+}
+
+// raidsHandler serves GET /api/raids, the raid trains of the period.
+func (a *api) raidsHandler() http.HandlerFunc {
+	type raidJSON struct {
+		From    string `json:"from"`
+		To      string `json:"to"`
+		Viewers int    `json:"viewers"`
+		At      string `json:"at"`
+	}
+	type chainJSON struct {
+		ChainID   int64      `json:"chain_id"`
+		StartedAt string     `json:"started_at"`
+		EndedAt   string     `json:"ended_at"`
+		Raids     []raidJSON `json:"raids"`
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !a.authorized(r) {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		since, until, err := period(r, time.Now())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		raids, err := a.store.Raids(since)
+		if err != nil {
+			panic(err)
+		}
+
+		chains := []*chainJSON{}
+		byID := map[int64]*chainJSON{}
+		rows := [][]string{{"chain_id", "at", "from", "to", "viewers"}}
+		for _, raid := range raids {
+			if raid.At.After(until) {
+				continue
+			}
+			chain, ok := byID[raid.ChainID]
+			if !ok {
+				chain = &chainJSON{ChainID: raid.ChainID, StartedAt: formatTime(raid.At)}
+				byID[raid.ChainID] = chain
+				chains = append(chains, chain)
+			}
+			chain.EndedAt = formatTime(raid.At)
+			chain.Raids = append(chain.Raids, raidJSON{From: raid.FromLogin, To: raid.ToLogin, Viewers: raid.Viewers, At: formatTime(raid.At)})
+			rows = append(rows, []string{strconv.FormatInt(raid.ChainID, 10), formatTime(raid.At), raid.FromLogin, raid.ToLogin, strconv.Itoa(raid.Viewers)})
+		}
+
+		if wantsCSV(r) {
+			writeCSV(w, "raids.csv", rows)
+			return
+		}
+		writeJSON(w, map[string]interface{}{
+			"since":  since,
+			"until":  until,
+			"chains": chains,
+		})
+	})
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	helix "github.com/nicklaw5/helix/v2"
+
+	"github.com/halkeye/twitch_go_online/internal/roster"
+	"github.com/halkeye/twitch_go_online/internal/storage"
+)
+
+func TestPeriod(t *testing.T) {
+	now := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		query string
+		since time.Time
+		until time.Time
+	}{
+		{"", now.Add(-defaultPeriod), now},
+		{"days=7", now.Add(-7 * 24 * time.Hour), now},
+		{"since=2024-02-01&until=2024-02-15", time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC), time.Date(2024, 2, 15, 0, 0, 0, 0, time.UTC)},
+		{"since=2024-02-01T10:00:00Z", time.Date(2024, 2, 1, 10, 0, 0, 0, time.UTC), now},
+	}
+	for _, tt := range tests {
+		since, until, err := period(httptest.NewRequest(http.MethodGet, "/?"+tt.query, nil), now)
+		if err != nil || !since.Equal(tt.since) || !until.Equal(tt.until) {
+			t.Errorf("period(%q) = %s, %s, %v; want %s, %s", tt.query, since, until, err, tt.since, tt.until)
+		}
+	}
+
+	for _, query := range []string{"days=0", "days=-1", "days=week", "since=yesterday", "until=2024-13-01"} {
+		if _, _, err := period(httptest.NewRequest(http.MethodGet, "/?"+query, nil), now); err == nil {
+			t.Errorf("period(%q) accepted it", query)
+		}
+	}
+}
+
+func TestAPI(t *testing.T) {
+	twitch := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// nobody outside the roster exists
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"data": []helix.User{}})
+	}))
+	defer twitch.Close()
+	client, err := helix.NewClient(&helix.Options{ClientID: "id", APIBaseURL: twitch.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	store, err := storage.OpenSQLite(filepath.Join(t.TempDir(), "storage.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	started := time.Now().Add(-48 * time.Hour).Truncate(time.Second)
+	err = store.StartSession(storage.Session{StreamID: "s1", BroadcasterID: "1", BroadcasterLogin: "halkeye", StartedAt: started, Game: "Art", Title: "drawing, again"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := store.EndSession("1", started.Add(2*time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.RecordRaid(storage.Raid{FromBroadcasterID: "1", FromLogin: "halkeye", ToBroadcasterID: "2", ToLogin: "friend", Viewers: 12, At: started.Add(2 * time.Hour)}); err != nil {
+		t.Fatal(err)
+	}
+
+	an := newAnnouncer(client, nil, nil, store)
+	an.setMembers([]roster.Member{{TwitchLogin: "halkeye", ResolvedID: "1", Enabled: true}})
+	a := &api{token: "s3cret", store: store, client: client, an: an}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/streamers/{login}/sessions", a.sessionsHandler())
+	mux.HandleFunc("GET /api/stats", a.statsHandler())
+	mux.HandleFunc("GET /api/raids", a.raidsHandler())
+	get := func(target string, token string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, target, nil)
+		if len(token) != 0 {
+			r.Header.Set("Authorization", "Bearer "+token)
+		}
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, r)
+		return w
+	}
+
+	for _, target := range []string{"/api/stats", "/api/raids", "/api/streamers/halkeye/sessions"} {
+		if w := get(target, ""); w.Code != http.StatusUnauthorized {
+			t.Errorf("%s without a token = %d; want 401", target, w.Code)
+		}
+		if w := get(target, "wrong"); w.Code != http.StatusUnauthorized {
+			t.Errorf("%s with the wrong token = %d; want 401", target, w.Code)
+		}
+	}
+	if w := get("/api/stats?token=s3cret", ""); w.Code != http.StatusOK {
+		t.Errorf("token in the query = %d; want 200", w.Code)
+	}
+	if w := get("/api/stats?days=0", "s3cret"); w.Code != http.StatusBadRequest {
+		t.Errorf("days=0 = %d; want 400", w.Code)
+	}
+	if w := get("/api/streamers/nobody/sessions", "s3cret"); w.Code != http.StatusNotFound {
+		t.Errorf("unknown login = %d; want 404", w.Code)
+	}
+
+	w := get("/api/streamers/HalkEye/sessions", "s3cret")
+	var sessions struct {
+		Login    string `json:"login"`
+		Sessions []struct {
+			StreamID string  `json:"stream_id"`
+			Hours    float64 `json:"hours"`
+		} `json:"sessions"`
+	}
+	if err := json.NewDecoder(w.Body).Decode(&sessions); err != nil {
+		t.Fatal(err)
+	}
+	if sessions.Login != "halkeye" || len(sessions.Sessions) != 1 || sessions.Sessions[0].StreamID != "s1" || sessions.Sessions[0].Hours != 2 {
+		t.Errorf("sessions = %+v; want halkeye's two hour stream", sessions)
+	}
+
+	csvTests := []struct {
+		target string
+		header string
+		row    string
+	}{
+		{"/api/streamers/halkeye/sessions?format=csv", "stream_id,login,started_at,ended_at,hours,game,title,peak_viewers,average_viewers",
+			"s1,halkeye," + started.UTC().Format(time.RFC3339) + "," + started.Add(2*time.Hour).UTC().Format(time.RFC3339) + ",2.00,Art,\"drawing, again\",0,0"},
+		{"/api/stats?format=csv", "login,sessions,hours,average_hours,longest_streak,current_streak,top_game", "halkeye,1,2.00,2.00,"},
+		{"/api/raids?format=csv", "chain_id,at,from,to,viewers", ",halkeye,friend,12"},
+	}
+	for _, tt := range csvTests {
+		w := get(tt.target, "s3cret")
+		if w.Code != http.StatusOK || w.Header().Get("Content-Type") != "text/csv" || !strings.HasPrefix(w.Header().Get("Content-Disposition"), "attachment") {
+			t.Errorf("%s = %d %v; want a csv attachment", tt.target, w.Code, w.Header())
+			continue
+		}
+		rows, err := csv.NewReader(strings.NewReader(w.Body.String())).ReadAll()
+		if err != nil {
+			t.Fatal(err)
+		}
+		lines := strings.Split(strings.TrimSpace(w.Body.String()), "\n")
+		if len(rows) != 2 || lines[0] != tt.header || !strings.Contains(lines[1], tt.row) {
+			t.Errorf("%s =\n%s\nwant %s and a row with %s", tt.target, w.Body.String(), tt.header, tt.row)
+		}
+	}
+}
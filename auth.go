@@ -0,0 +1,113 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	helix "github.com/nicklaw5/helix/v2"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/halkeye/twitch_go_online/internal/tokens"
+)
+
+// authScopes are what streamers are asked for on /auth/twitch, enough for authorizedSubscriptionTypes
+// and for counting their followers and subscribers.
+var authScopes = []string{"moderator:read:followers", "channel:read:subscriptions", "bits:read"}
+
+// stateLifetime is how long someone has to finish authorizing on twitch
+const stateLifetime = 10 * time.Minute
+
+// authFlow lets roster members authorize us with twitch's authorization code flow.
+type authFlow struct {
+	// client only does the oauth part, it is the one with the redirect uri
+	client *helix.Client
+	cipher *tokens.Cipher
+	vault  *tokens.Vault
+	an     *announcer
+	sm     *subscriptionManager
+}
+
+// startHandler serves GET /auth/twitch, sending the streamer off to twitch.
+func (af *authFlow) startHandler() http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// the state is just when the flow started, sealed so nobody else can make one up
+		state, err := af.cipher.Seal(strconv.FormatInt(time.Now().Unix(), 10))
+		if err != nil {
+			panic(err)
+		}
+		http.Redirect(w, r, af.client.GetAuthorizationURL(&helix.AuthorizationURLParams{
+			ResponseType: "code",
+			Scopes:       authScopes,
+			State:        state,
+		}), http.StatusFound)
+	})
+}
+
+func (af *authFlow) validState(state string) bool {
+	started, err := af.cipher.Open(state)
+	if err != nil {
+		return false
+	}
+	unix, err := strconv.ParseInt(started, 10, 64)
+	if err != nil {
+		return false
+	}
+	age := time.Since(time.Unix(unix, 0))
+	return age >= 0 && age < stateLifetime
+}
+
+// callbackHandler serves GET /auth/twitch/callback, where twitch sends the streamer back with a code.
+func (af *authFlow) callbackHandler() http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		if !af.validState(query.Get("state")) {
+			http.Error(w, "this link has expired, start again from /auth/twitch", http.StatusBadRequest)
+			return
+		}
+		if reason := query.Get("error"); len(reason) != 0 {
+			http.Error(w, "twitch didn't authorize us: "+query.Get("error_description"), http.StatusBadRequest)
+			return
+		}
+
+		resp, err := af.client.RequestUserAccessToken(query.Get("code"))
+		if err != nil {
+			panic(errors.Wrap(err, "unable to request user token"))
+		}
+		if resp.ErrorStatus != 0 {
+			log.Warnf("twitch refused the authorization code (%d) - %s", resp.ErrorStatus, resp.ErrorMessage)
+			http.Error(w, "twitch refused the authorization, start again from /auth/twitch", http.StatusBadRequest)
+			return
+		}
+
+		valid, validated, err := af.client.ValidateToken(resp.Data.AccessToken)
+		if err != nil {
+			panic(errors.Wrap(err, "unable to validate user token"))
+		}
+		if !valid {
+			http.Error(w, "twitch handed us a token that doesn't work, start again from /auth/twitch", http.StatusBadRequest)
+			return
+		}
+
+		userID, login := validated.Data.UserID, validated.Data.Login
+		if member := af.an.member(userID, login); len(member.TwitchLogin) == 0 {
+			log.Warnf("%s tried to authorize us, but isn't on the roster", login)
+			http.Error(w, "only streamers on the roster can connect their channel", http.StatusForbidden)
+			return
+		}
+		if len(resp.Data.Scopes) == 0 {
+			resp.Data.Scopes = validated.Data.Scopes
+		}
+		if err := af.vault.Save(userID, login, resp.Data); err != nil {
+			panic(err)
+		}
+		log.Infof("%s authorized us", login)
+
+		if err := af.sm.Authorize(userID); err != nil {
+			log.Error(err)
+		}
+		_, _ = fmt.Fprintf(w, "Thanks %s, your channel is connected. You can close this page.", login)
+	})
+}
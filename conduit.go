@@ -0,0 +1,60 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/halkeye/twitch_go_online/internal/conduit"
+)
+
+// conduitShard keeps this instance's shard of a conduit pointed at us, and adopts the shards of
+// instances that stopped answering so their share of the events isn't lost.
+type conduitShard struct {
+	conduits  *conduit.Client
+	conduitID string
+	shardID   string
+}
+
+func (cs *conduitShard) assign(transport conduit.Transport) error {
+	log.Infof("Assigning conduit %s shard %s to %s transport", cs.conduitID, cs.shardID, transport.Method)
+	return cs.conduits.AssignShard(cs.conduitID, cs.shardID, transport)
+}
+
+// adoptDisabledShards points every shard that isn't enabled at transport. Only webhook transports
+// can back several shards, a websocket session belongs to exactly one.
+func (cs *conduitShard) adoptDisabledShards(transport conduit.Transport) error {
+	shards, err := cs.conduits.Shards(cs.conduitID)
+	if err != nil {
+		return err
+	}
+
+	for _, shard := range shards {
+		if shard.Status == "enabled" || shard.Status == "webhook_callback_verification_pending" || shard.ID == cs.shardID {
+			continue
+		}
+		log.Warnf("Conduit %s shard %s is %s, taking it over", cs.conduitID, shard.ID, shard.Status)
+		if err := cs.conduits.AssignShard(cs.conduitID, shard.ID, transport); err != nil {
+			return errors.Wrapf(err, "unable to adopt shard %s", shard.ID)
+		}
+	}
+	return nil
+}
+
+func (cs *conduitShard) watch(ctx context.Context, interval time.Duration, transport conduit.Transport) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := cs.adoptDisabledShards(transport); err != nil {
+				log.Error(errors.Wrap(err, "unable to check conduit shards"))
+			}
+		}
+	}
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	helix "github.com/nicklaw5/helix/v2"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/halkeye/twitch_go_online/internal/discordsender"
+	"github.com/halkeye/twitch_go_online/internal/storage"
+)
+
+// highlightMessageTmpl is the default HIGHLIGHT_MESSAGE.
+const highlightMessageTmpl = `**Highlights from {{.ChannelName}}'s stream**{{if .Vod}}
+VOD: {{.Vod}}{{end}}{{if .Clips}}
+Top clips:
+{{.Clips}}{{end}}`
+
+// highlights posts the VOD and top clips of a stream that ended, next to (or onto the end of) its announcements.
+func (an *announcer) highlights(ended storage.Session) error {
+	vod, err := findVod(an.client, ended)
+	if err != nil {
+		log.Error(err)
+	}
+	clips, err := topClips(an.client, ended, an.highlightClips)
+	if err != nil {
+		log.Error(err)
+	}
+	if vod == nil && len(clips) == 0 {
+		log.Infof("%s's stream %s has no VOD or clips, nothing to post", ended.BroadcasterLogin, ended.StreamID)
+		return nil
+	}
+
+	announcements, err := an.store.Announcements(ended.StreamID)
+	if err != nil {
+		return err
+	}
+
+	member := an.member(ended.BroadcasterID, ended.BroadcasterLogin)
+	tmplParams := an.tmplParams(member, ended.BroadcasterLogin, ended.BroadcasterName, ended.Game)
+	tmplParams["Title"] = escapeMarkdown(ended.Title)
+	if vod != nil {
+		tmplParams["Vod"] = vod.URL
+		tmplParams["VodDuration"] = vod.Duration
+	}
+	lines := []string{}
+	for _, clip := range clips {
+		lines = append(lines, fmt.Sprintf("- %s (%d views) %s", escapeMarkdown(clip.Title), clip.ViewCount, clip.URL))
+	}
+	tmplParams["Clips"] = strings.Join(lines, "\n")
+
+	for _, announcement := range announcements {
+		if an.highlightMode == "append" {
+			if len(announcement.MessageID) == 0 {
+				continue
+			}
+			sent := discordsender.Sent{Webhook: announcement.Destination, MessageID: announcement.MessageID}
+			if err := an.ds.AppendMessage(sent, an.highlightMessage, tmplParams); err != nil {
+				log.Error(errors.Wrapf(err, "unable to add highlights to the announcement of %s", ended.StreamID))
+			}
+			continue
+		}
+		// the destination still has any thread_id on it, so this lands in the announcement's thread
+		msg := discordsender.Message{Template: an.highlightMessage, Webhook: announcement.Destination}
+		if _, err := an.ds.SendMessage(msg, tmplParams); err != nil {
+			log.Error(errors.Wrapf(err, "unable to post highlights of %s", ended.StreamID))
+		}
+	}
+	return nil
+}
+
+// findVod returns the archive twitch made of the stream, if it made one.
+func findVod(client *helix.Client, ended storage.Session) (*helix.Video, error) {
+	resp, err := client.GetVideos(&helix.VideosParams{UserID: ended.BroadcasterID, Type: "archive", First: 20})
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to get videos")
+	}
+	if resp.ErrorStatus != 0 {
+		return nil, errors.Errorf("error fetching videos status=%d %s error=%s", resp.ErrorStatus, resp.Error, resp.ErrorMessage)
+	}
+	for _, video := range resp.Data.Videos {
+		if video.StreamID == ended.StreamID {
+			return &video, nil
+		}
+	}
+	return nil, nil
+}
+
+// topClips returns the count most viewed clips made while the stream was live.
+func topClips(client *helix.Client, ended storage.Session, count int) ([]helix.Clip, error) {
+	if count <= 0 {
+		return nil, nil
+	}
+	resp, err := client.GetClips(&helix.ClipsParams{
+		BroadcasterID: ended.BroadcasterID,
+		StartedAt:     helix.Time{Time: ended.StartedAt.UTC()},
+		EndedAt:       helix.Time{Time: ended.EndedAt.UTC()},
+		First:         100,
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to get clips")
+	}
+	if resp.ErrorStatus != 0 {
+		return nil, errors.Errorf("error fetching clips status=%d %s error=%s", resp.ErrorStatus, resp.Error, resp.ErrorMessage)
+	}
+
+	clips := resp.Data.Clips
+	sort.SliceStable(clips, func(i, j int) bool { return clips[i].ViewCount > clips[j].ViewCount })
+	if len(clips) > count {
+		clips = clips[:count]
+	}
+	return clips, nil
+}
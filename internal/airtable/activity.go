@@ -0,0 +1,204 @@
+package airtable
+
+import (
+	"fmt"
+	"net/url"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/halkeye/twitch_go_online/internal/roster"
+)
+
+// recordsPerPatch is the most records airtable accepts in a single update
+const recordsPerPatch = 10
+
+// maxActivityBackoff caps how long failed updates wait before they're tried again
+const maxActivityBackoff = 30 * time.Minute
+
+type patchRecord struct {
+	ID     string                 `json:"id"`
+	Fields map[string]interface{} `json:"fields"`
+}
+
+// ActivityWriter writes stream activity back to the roster. Updates are held for a little while
+// so a burst of events for the same streamer turns into one write, and writes go out ten records at a time.
+type ActivityWriter struct {
+	at    *Airtable
+	delay time.Duration
+
+	mu      sync.Mutex
+	pending map[string]map[string]interface{}
+	counts  map[string]int
+	timer   *time.Timer
+	// failures is how many flushes in a row failed, each one waits twice as long as the last
+	failures int
+}
+
+func (at *Airtable) NewActivityWriter(delay time.Duration) *ActivityWriter {
+	return &ActivityWriter{
+		at:      at,
+		delay:   delay,
+		pending: map[string]map[string]interface{}{},
+		counts:  map[string]int{},
+	}
+}
+
+// StreamStarted records member going live.
+func (aw *ActivityWriter) StreamStarted(member roster.Member, userID string, game string, title string, startedAt time.Time) {
+	aw.mu.Lock()
+	count, ok := aw.counts[member.RecordID]
+	if !ok {
+		count = member.StreamCount
+	}
+	aw.counts[member.RecordID] = count + 1
+	aw.mu.Unlock()
+
+	columns := aw.at.Columns
+	aw.update(member, map[string]interface{}{
+		columns.LastLiveAt:  startedAt.UTC().Format(time.RFC3339),
+		columns.LastGame:    game,
+		columns.LastTitle:   title,
+		columns.StreamCount: count + 1,
+		columns.ResolvedID:  userID,
+	})
+}
+
+// StreamEnded records when member's stream ended, Last Live At keeps when it started.
+func (aw *ActivityWriter) StreamEnded(member roster.Member, userID string, endedAt time.Time) {
+	columns := aw.at.Columns
+	aw.update(member, map[string]interface{}{
+		columns.LastEndedAt: endedAt.UTC().Format(time.RFC3339),
+		columns.ResolvedID:  userID,
+	})
+}
+
+// update merges fields into whatever is already waiting for member's record.
+func (aw *ActivityWriter) update(member roster.Member, fields map[string]interface{}) {
+	if !ours(member) {
+		return
+	}
+	recordID := member.RecordID
+
+	aw.mu.Lock()
+	defer aw.mu.Unlock()
+
+	if _, ok := aw.pending[recordID]; !ok {
+		aw.pending[recordID] = map[string]interface{}{}
+	}
+	for name, value := range fields {
+		// an empty column name means the table doesn't track it
+		if len(name) != 0 {
+			aw.pending[recordID][name] = value
+		}
+	}
+
+	aw.schedule(aw.delay)
+}
+
+// schedule flushes after delay, unless a flush is already coming. aw.mu must be held.
+func (aw *ActivityWriter) schedule(delay time.Duration) {
+	if aw.timer == nil {
+		aw.timer = time.AfterFunc(delay, func() {
+			if err := aw.Flush(); err != nil {
+				log.Error(errors.Wrap(err, "unable to write stream activity to airtable"))
+			}
+		})
+	}
+}
+
+// Flush writes everything waiting to airtable. When the write fails the updates go back in the queue,
+// under anything newer that came in meanwhile, and another flush is scheduled further out every time it
+// keeps failing. Records airtable refuses outright, like deleted rows, are logged and dropped.
+func (aw *ActivityWriter) Flush() error {
+	aw.mu.Lock()
+	pending := aw.pending
+	aw.pending = map[string]map[string]interface{}{}
+	aw.timer = nil
+	aw.mu.Unlock()
+
+	failed, err := aw.at.updateRecords(pending)
+
+	aw.mu.Lock()
+	defer aw.mu.Unlock()
+	if err == nil {
+		aw.failures = 0
+		return nil
+	}
+	for recordID, fields := range failed {
+		for name, value := range aw.pending[recordID] {
+			fields[name] = value
+		}
+		aw.pending[recordID] = fields
+	}
+	aw.failures++
+	backoff := maxActivityBackoff
+	if aw.failures < 16 && aw.delay<<aw.failures < maxActivityBackoff {
+		backoff = aw.delay << aw.failures
+	}
+	aw.schedule(backoff)
+	return err
+}
+
+// ours tells our rows apart from members that came from a different roster source.
+func ours(member roster.Member) bool {
+	return member.Source == SourceName && len(member.RecordID) != 0
+}
+
+// updateRecords writes record id => fields, ten records at a time, and returns the updates that didn't go out.
+// A batch airtable rejects is sent again a record at a time, so one bad record doesn't hold up the rest of it.
+func (at *Airtable) updateRecords(updates map[string]map[string]interface{}) (map[string]map[string]interface{}, error) {
+	records := []patchRecord{}
+	for id, fields := range updates {
+		records = append(records, patchRecord{ID: id, Fields: fields})
+	}
+	sort.Slice(records, func(i, j int) bool { return records[i].ID < records[j].ID })
+
+	failed := map[string]map[string]interface{}{}
+	var err error
+	for start := 0; start < len(records); start += recordsPerPatch {
+		end := start + recordsPerPatch
+		if end > len(records) {
+			end = len(records)
+		}
+
+		batchErr := at.patchRecords(records[start:end])
+		if rejected(batchErr) && end-start > 1 {
+			for _, record := range records[start:end] {
+				if recordErr := at.patchRecords([]patchRecord{record}); recordErr != nil {
+					if rejected(recordErr) {
+						log.Error(errors.Wrapf(recordErr, "airtable rejected the update of %s, dropping it", record.ID))
+						continue
+					}
+					failed[record.ID] = record.Fields
+					err = recordErr
+				}
+			}
+			continue
+		}
+		if rejected(batchErr) {
+			log.Error(errors.Wrapf(batchErr, "airtable rejected the update of %s, dropping it", records[start].ID))
+			continue
+		}
+		if batchErr != nil {
+			for _, record := range records[start:end] {
+				failed[record.ID] = record.Fields
+			}
+			err = batchErr
+		}
+	}
+	return failed, err
+}
+
+func (at *Airtable) patchRecords(records []patchRecord) error {
+	endpoint := fmt.Sprintf("%s/v0/%s/%s", at.apiURL, at.BaseID, url.PathEscape(at.TableName))
+	log.WithField("records", len(records)).Debug("updating airtable records")
+	err := at.do("PATCH", endpoint, map[string]interface{}{
+		"records":  records,
+		"typecast": true,
+	}, nil)
+	return errors.Wrap(err, "unable to update records")
+}
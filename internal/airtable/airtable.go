@@ -2,85 +2,202 @@ package airtable
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
-	"strings"
+	"net/url"
+	"strconv"
+	"sync"
 	"time"
 
 	"github.com/pkg/errors"
 	log "github.com/sirupsen/logrus"
+
+	"github.com/halkeye/twitch_go_online/internal/roster"
 )
 
-type webhookCallback func(usernames []string)
+// SourceName is the Source of every member read from airtable.
+const SourceName = "airtable"
+
+const (
+	defaultAPIURL = "https://api.airtable.com"
+
+	// airtable allows 5 requests per second per base
+	requestInterval = time.Second / 5
+	maxRetries      = 5
+)
 
 type Airtable struct {
 	APIKey    string
 	BaseID    string
 	TableName string
+	Columns   Columns
+
+	// optional filters for the roster, PageSize is capped at 100 by airtable
+	View            string
+	FilterByFormula string
+	PageSize        int
+
+	// MACSecret is the base64 secret airtable signs webhook notifications with, it is only
+	// handed out when a webhook is created
+	MACSecret string
+
+	apiURL     string
+	retryDelay time.Duration
+
+	// syncMu guards what we know of the roster and where we are in the webhook's payloads
+	syncMu    sync.Mutex
+	known     map[string]roster.Member
+	webhookID string
+	cursor    int
+	onChange  roster.ChangeFunc
+
+	throttleMu  sync.Mutex
+	lastRequest time.Time
+}
+
+type record struct {
+	ID     string                 `json:"id"`
+	Fields map[string]interface{} `json:"fields"`
+}
+
+type recordsPage struct {
+	Records []record `json:"records"`
+	Offset  string   `json:"offset"`
 }
 
 func New(APIKey string, baseID string, tableName string) *Airtable {
 	return &Airtable{
-		APIKey:    APIKey,
-		BaseID:    baseID,
-		TableName: tableName,
+		APIKey:     APIKey,
+		BaseID:     baseID,
+		TableName:  tableName,
+		Columns:    DefaultColumns(),
+		apiURL:     defaultAPIURL,
+		retryDelay: 30 * time.Second,
+		known:      map[string]roster.Member{},
 	}
 }
 
-func (at *Airtable) HttpHandler(callback webhookCallback) http.HandlerFunc {
+// Watch hands onChange to HttpHandler, airtable tells us about changes through the webhook.
+func (at *Airtable) Watch(_ context.Context, onChange roster.ChangeFunc) error {
+	at.syncMu.Lock()
+	defer at.syncMu.Unlock()
+	at.onChange = onChange
+	return nil
+}
+
+// HttpHandler receives webhook notifications, and passes whatever changed on to the function given to Watch.
+func (at *Airtable) HttpHandler() http.HandlerFunc {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		// Read the request body.
 		body, err := io.ReadAll(r.Body)
 		if err != nil {
-			panic(errors.Wrap(err, "Error reading incoming post"))
+			log.Error(errors.Wrap(err, "Error reading incoming post"))
+			w.WriteHeader(http.StatusInternalServerError)
+			return
 		}
 		defer r.Body.Close()
 
 		log.Infof("got webookhook from airtable: %s", string(body))
-		// there's a fancy payload which you call https://airtable.com/developers/web/api/model/webhooks-payload but really we just want usernames again
-		usernames, err := at.Usernames()
+		if !at.verifyMAC(body, r.Header.Get("X-Airtable-Content-MAC")) {
+			log.Warn("invalid mac on airtable webhook")
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		// the notification only says something happened, the changes themselves come from the payloads endpoint
+		changes, members, err := at.changesSinceCursor()
 		if err != nil {
-			panic(errors.Wrap(err, "getting usernames after webhook"))
+			// airtable keeps the payloads, the next notification picks them up from the same cursor
+			log.Error(errors.Wrap(err, "getting changes after webhook"))
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		at.syncMu.Lock()
+		onChange := at.onChange
+		at.syncMu.Unlock()
+		if len(changes) != 0 && onChange != nil {
+			onChange(changes, members)
 		}
-		callback(usernames)
 	})
 }
 
 func (at *Airtable) Usernames() ([]string, error) {
-	usernames := []string{}
+	members, err := at.Members()
+	if err != nil {
+		return []string{}, err
+	}
+	return roster.Logins(members), nil
+}
 
-	endpoint := fmt.Sprintf("https://api.airtable.com/v0/%s/%s", at.BaseID, at.TableName)
+// Members returns everyone on the roster. Rows without a twitch account are skipped rather than failing the whole roster.
+func (at *Airtable) Members() ([]roster.Member, error) {
+	members := []roster.Member{}
 
-	var result map[string]interface{}
-	err := at.get(endpoint, &result)
+	records, err := at.records()
 	if err != nil {
-		return usernames, errors.Wrap(err, "unable to fetch rows")
+		return members, errors.Wrap(err, "unable to fetch rows")
 	}
 
-	for _, record := range result["records"].([]interface{}) {
-		fields := record.(map[string]interface{})["fields"].(map[string]interface{})
-		if len(fields) == 0 {
+	for _, record := range records {
+		if len(record.Fields) == 0 {
 			continue
 		}
-		log.WithField("fields", mustJson(fields)).Debug("fields")
-		if twitchAccountField, ok := fields["Twitch Account"]; ok {
-			log.WithField("twitchAccountField", twitchAccountField).Debug("twitchAccountField")
-			if val, ok := twitchAccountField.(string); ok {
-				val = strings.TrimSpace(val)
-				if len(val) > 0 {
-					usernames = append(usernames, val)
-				}
-			} else {
-				log.Warn("record has no twitch account")
-			}
-		} else {
-			return usernames, errors.New("record has no twitch account")
+		log.WithField("fields", mustJson(record.Fields)).Debug("fields")
+
+		member := at.Columns.member(record)
+		if len(member.TwitchLogin) == 0 {
+			log.WithField("record", record.ID).Warnf("record has no %s", at.Columns.TwitchLogin)
+			continue
 		}
+		members = append(members, member)
 	}
 
-	return usernames, nil
+	at.syncMu.Lock()
+	defer at.syncMu.Unlock()
+	at.known = map[string]roster.Member{}
+	for _, member := range members {
+		at.known[member.RecordID] = member
+	}
+
+	return members, nil
+}
+
+// records fetches every row of the table, following airtable's offset until there are no more pages.
+func (at *Airtable) records() ([]record, error) {
+	return at.recordsMatching(at.FilterByFormula)
+}
+
+func (at *Airtable) recordsMatching(formula string) ([]record, error) {
+	records := []record{}
+
+	query := url.Values{}
+	if at.PageSize > 0 {
+		query.Set("pageSize", strconv.Itoa(at.PageSize))
+	}
+	if len(at.View) != 0 {
+		query.Set("view", at.View)
+	}
+	if len(formula) != 0 {
+		query.Set("filterByFormula", formula)
+	}
+
+	for {
+		endpoint := fmt.Sprintf("%s/v0/%s/%s?%s", at.apiURL, at.BaseID, url.PathEscape(at.TableName), query.Encode())
+
+		var page recordsPage
+		if err := at.get(endpoint, &page); err != nil {
+			return records, err
+		}
+		records = append(records, page.Records...)
+
+		if len(page.Offset) == 0 {
+			return records, nil
+		}
+		query.Set("offset", page.Offset)
+	}
 }
 
 func (at *Airtable) RegisterWebhook(webhookURL string) error {
@@ -89,12 +206,28 @@ func (at *Airtable) RegisterWebhook(webhookURL string) error {
 		return err
 	}
 
+	if len(id) != 0 && len(at.MACSecret) == 0 {
+		// without the secret we can't check notifications, so start over with a webhook we know the secret of
+		log.Infof("No mac secret for airtable webhook %s, recreating it", id)
+		if err := at.do("DELETE", fmt.Sprintf("%s/v0/bases/%s/webhooks/%s", at.apiURL, at.BaseID, id), nil, nil); err != nil {
+			return errors.Wrap(err, "unable to delete webhook")
+		}
+		id = ""
+	}
+
 	if len(id) != 0 {
+		cursor, err := at.latestCursor(id)
+		if err != nil {
+			return err
+		}
+		at.syncMu.Lock()
+		at.webhookID, at.cursor = id, cursor
+		at.syncMu.Unlock()
 		return at.refreshWebhook(id)
 	}
 
 	var result map[string]interface{}
-	err = at.post(fmt.Sprintf("https://api.airtable.com/v0/bases/%s/webhooks", at.BaseID), map[string]interface{}{
+	err = at.post(fmt.Sprintf("%s/v0/bases/%s/webhooks", at.apiURL, at.BaseID), map[string]interface{}{
 		"notificationUrl": webhookURL,
 		"specification": map[string]interface{}{
 			"options": map[string]interface{}{
@@ -108,15 +241,18 @@ func (at *Airtable) RegisterWebhook(webhookURL string) error {
 		return errors.Wrap(err, "unable to create webhook")
 	}
 
-	at.refreshWebhook(result["id"].(string))
+	at.syncMu.Lock()
+	at.MACSecret, _ = result["macSecretBase64"].(string)
+	at.webhookID, at.cursor = result["id"].(string), 1
+	at.syncMu.Unlock()
 
-	return nil
+	return at.refreshWebhook(result["id"].(string))
 }
 
 func (at *Airtable) refreshWebhook(id string) error {
 	var result map[string]interface{}
 
-	endpoint := fmt.Sprintf("https://api.airtable.com/v0/bases/%s/webhooks/%s/refresh", at.BaseID, id)
+	endpoint := fmt.Sprintf("%s/v0/bases/%s/webhooks/%s/refresh", at.apiURL, at.BaseID, id)
 
 	err := at.post(endpoint, nil, &result)
 	if err != nil {
@@ -140,7 +276,7 @@ func (at *Airtable) refreshWebhook(id string) error {
 }
 
 func (at *Airtable) findMatchingWebhooks(URL string) (string, error) {
-	endpoint := fmt.Sprintf("https://api.airtable.com/v0/bases/%s/webhooks", at.BaseID)
+	endpoint := fmt.Sprintf("%s/v0/bases/%s/webhooks", at.apiURL, at.BaseID)
 
 	var result map[string]interface{}
 	err := at.get(endpoint, &result)
@@ -162,34 +298,17 @@ func decodeJSON(r io.Reader, v interface{}) error {
 }
 
 func (at *Airtable) get(endpoint string, result interface{}) error {
-	var err error
-
 	log.WithField("endpoint", endpoint).Debug("getting endpoint")
-	req, err := http.NewRequest("GET", endpoint, nil)
-	if err != nil {
-		return errors.Wrap(err, "unable to get from airtable")
-	}
-
-	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", at.APIKey))
-	req.Header.Set("Content-Type", "application/json")
-
-	client := http.Client{}
-	resp, err := client.Do(req)
-	if err != nil {
-		return errors.Wrap(err, "unable map request")
-	}
-	defer resp.Body.Close()
-
-	if result != nil {
-		if err := decodeJSON(resp.Body, &result); err != nil {
-			return errors.Wrap(err, "unable to decode request")
-		}
-	}
-
-	return nil
+	return at.do("GET", endpoint, nil, result)
 }
 
 func (at *Airtable) post(endpoint string, body interface{}, result interface{}) error {
+	log.WithField("endpoint", endpoint).Debug("posting endpoint")
+	return at.do("POST", endpoint, body, result)
+}
+
+// do makes a request to airtable, staying under the rate limit and retrying when we get told to slow down anyways.
+func (at *Airtable) do(method string, endpoint string, body interface{}, result interface{}) error {
 	var payload []byte
 	var err error
 	if body != nil {
@@ -199,29 +318,75 @@ func (at *Airtable) post(endpoint string, body interface{}, result interface{})
 		}
 	}
 
-	log.WithField("endpoint", endpoint).Debug("posting endpoint")
-	req, err := http.NewRequest("POST", endpoint, bytes.NewBuffer(payload))
-	if err != nil {
-		return errors.Wrap(err, "unable to create request")
-	}
+	for attempt := 0; ; attempt++ {
+		at.throttle()
+
+		req, err := http.NewRequest(method, endpoint, bytes.NewBuffer(payload))
+		if err != nil {
+			return errors.Wrap(err, "unable to create request")
+		}
 
-	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", at.APIKey))
-	req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", at.APIKey))
+		req.Header.Set("Content-Type", "application/json")
 
-	client := http.Client{}
-	resp, err := client.Do(req)
-	if err != nil {
-		return errors.Wrap(err, "unable to make request")
-	}
-	defer resp.Body.Close()
+		client := http.Client{}
+		resp, err := client.Do(req)
+		if err != nil {
+			return errors.Wrap(err, "unable to make request")
+		}
+
+		if resp.StatusCode == http.StatusTooManyRequests && attempt < maxRetries {
+			resp.Body.Close()
+			delay := at.retryDelay
+			if seconds, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil {
+				delay = time.Duration(seconds) * time.Second
+			}
+			log.Warnf("airtable rate limited us, retrying in %s", delay)
+			time.Sleep(delay)
+			continue
+		}
 
-	if result != nil {
-		if err := decodeJSON(resp.Body, &result); err != nil {
-			return errors.Wrap(err, "unable to decode request")
+		defer resp.Body.Close()
+		if resp.StatusCode >= 300 {
+			respBody, _ := io.ReadAll(resp.Body)
+			return &statusError{StatusCode: resp.StatusCode, Body: string(respBody)}
+		}
+
+		if result != nil {
+			if err := decodeJSON(resp.Body, &result); err != nil {
+				return errors.Wrap(err, "unable to decode request")
+			}
 		}
+
+		return nil
 	}
+}
 
-	return nil
+// statusError is airtable answering with something other than success.
+type statusError struct {
+	StatusCode int
+	Body       string
+}
+
+func (e *statusError) Error() string {
+	return fmt.Sprintf("airtable returned %d: %s", e.StatusCode, e.Body)
+}
+
+// rejected tells airtable refusing the request itself apart from it failing, sending it again won't help.
+func rejected(err error) bool {
+	var status *statusError
+	return errors.As(err, &status) && status.StatusCode >= 400 && status.StatusCode < 500 && status.StatusCode != http.StatusTooManyRequests
+}
+
+// throttle spaces requests out so we stay under airtable's requests per second limit.
+func (at *Airtable) throttle() {
+	at.throttleMu.Lock()
+	defer at.throttleMu.Unlock()
+
+	if wait := time.Until(at.lastRequest.Add(requestInterval)); wait > 0 {
+		time.Sleep(wait)
+	}
+	at.lastRequest = time.Now()
 }
 
 func mustJson(data interface{}) string {
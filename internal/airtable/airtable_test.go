@@ -0,0 +1,238 @@
+package airtable
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/halkeye/twitch_go_online/internal/roster"
+)
+
+func TestUsernamesPagination(t *testing.T) {
+	var requests []string
+	rateLimited := false
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests = append(requests, r.URL.RawQuery)
+
+		if r.URL.Query().Get("offset") == "page2" && !rateLimited {
+			rateLimited = true
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+
+		page := recordsPage{}
+		switch r.URL.Query().Get("offset") {
+		case "":
+			page.Records = []record{{ID: "rec1", Fields: map[string]interface{}{"Twitch Account": " foo "}}}
+			page.Offset = "page2"
+		case "page2":
+			page.Records = []record{{ID: "rec2", Fields: map[string]interface{}{"Twitch Account": "bar"}}}
+		}
+		_ = json.NewEncoder(w).Encode(page)
+	}))
+	defer srv.Close()
+
+	at := New("key", "base", "Streamers")
+	at.apiURL = srv.URL
+	at.retryDelay = time.Millisecond
+	at.View = "Active"
+	at.PageSize = 1
+
+	usernames, err := at.Usernames()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(usernames, ",") != "foo,bar" {
+		t.Errorf("Usernames() = %v; want [foo bar]", usernames)
+	}
+
+	if len(requests) != 3 {
+		t.Fatalf("made %d requests; want 3 (one of them retried)", len(requests))
+	}
+	if !strings.Contains(requests[0], "view=Active") || !strings.Contains(requests[0], "pageSize=1") {
+		t.Errorf("first request %q is missing view or pageSize", requests[0])
+	}
+	if requests[1] != requests[2] {
+		t.Errorf("retry %q doesn't match rate limited request %q", requests[2], requests[1])
+	}
+}
+
+func TestMember(t *testing.T) {
+	columns := DefaultColumns()
+	columns.Enabled = "Enabled"
+
+	member := columns.member(record{ID: "rec1", Fields: map[string]interface{}{
+		"Twitch Account": " halkeye ",
+		"Enabled":        true,
+		"Discord Role":   "1234",
+		"Tags":           []interface{}{"art", " music "},
+	}})
+	if member.TwitchLogin != "halkeye" || !member.Enabled || member.DiscordRole != "1234" || strings.Join(member.Tags, ",") != "art,music" {
+		t.Errorf("member() = %+v", member)
+	}
+
+	// unticked checkboxes are left out of the record entirely
+	member = columns.member(record{ID: "rec2", Fields: map[string]interface{}{"Twitch Account": "foo", "Tags": "a, b"}})
+	if member.Enabled || strings.Join(member.Tags, ",") != "a,b" {
+		t.Errorf("member() = %+v", member)
+	}
+}
+
+func TestVerifyMAC(t *testing.T) {
+	at := New("key", "base", "Streamers")
+	at.MACSecret = "c2VjcmV0" // "secret"
+
+	body := []byte(`{"base":{"id":"app1"},"webhook":{"id":"ach1"}}`)
+	forged := "hmac-sha256=a1a8a5c1ebc5d9a7b0ba6ef1e4a1d2a8c6e9d2d8dd47f2eb07e2c2b6fa1fdc08"
+	if at.verifyMAC(body, forged) {
+		t.Errorf("verifyMAC() accepted a made up mac")
+	}
+
+	mac := hmac.New(sha256.New, []byte("secret"))
+	mac.Write(body)
+	if !at.verifyMAC(body, "hmac-sha256="+hex.EncodeToString(mac.Sum(nil))) {
+		t.Errorf("verifyMAC() rejected a valid mac")
+	}
+}
+
+func TestHttpHandler(t *testing.T) {
+	at := New("key", "base", "Streamers")
+	at.MACSecret = "c2VjcmV0" // "secret"
+
+	body := `{"base":{"id":"app1"},"webhook":{"id":"ach1"}}`
+	mac := hmac.New(sha256.New, []byte("secret"))
+	mac.Write([]byte(body))
+
+	tests := []struct {
+		name string
+		mac  string
+		want int
+	}{
+		{"forged", "hmac-sha256=00", http.StatusUnauthorized},
+		// without a registered webhook there are no payloads to fetch
+		{"unfetchable", "hmac-sha256=" + hex.EncodeToString(mac.Sum(nil)), http.StatusInternalServerError},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodPost, "/webhook/airtable", strings.NewReader(body))
+		r.Header.Set("X-Airtable-Content-MAC", tt.mac)
+		w := httptest.NewRecorder()
+		at.HttpHandler()(w, r)
+		if w.Code != tt.want {
+			t.Errorf("%s notification = %d; want %d", tt.name, w.Code, tt.want)
+		}
+	}
+}
+
+func TestActivityWriterBatches(t *testing.T) {
+	var batches []int
+	var counts []float64
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "PATCH" {
+			t.Errorf("method = %s; want PATCH", r.Method)
+		}
+		var body struct {
+			Records []patchRecord `json:"records"`
+		}
+		_ = json.NewDecoder(r.Body).Decode(&body)
+		batches = append(batches, len(body.Records))
+		for _, rec := range body.Records {
+			if rec.ID == "rec0" {
+				counts = append(counts, rec.Fields["Stream Count"].(float64))
+			}
+		}
+		_, _ = w.Write([]byte(`{"records":[]}`))
+	}))
+	defer srv.Close()
+
+	at := New("key", "base", "Streamers")
+	at.apiURL = srv.URL
+	aw := at.NewActivityWriter(time.Hour)
+
+	started := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	for i := 0; i < 12; i++ {
+		member := roster.Member{RecordID: "rec" + string(rune('a'+i)), StreamCount: 1, Source: SourceName}
+		aw.StreamStarted(member, "123", "Art", "drawing", started)
+	}
+	// two streams back to back coalesce into one write, still counted twice
+	aw.StreamStarted(roster.Member{RecordID: "rec0", StreamCount: 4, Source: SourceName}, "1", "Art", "one", started)
+	aw.StreamStarted(roster.Member{RecordID: "rec0", StreamCount: 4, Source: SourceName}, "1", "Art", "two", started)
+	// a roster file member whose login happens to look like a record id
+	aw.StreamStarted(roster.Member{RecordID: "recordingstar", TwitchLogin: "recordingstar"}, "2", "Art", "file", started)
+
+	if err := aw.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if len(batches) != 2 || batches[0]+batches[1] != 13 || batches[0] != recordsPerPatch {
+		t.Errorf("batches = %v; want 10 then 3", batches)
+	}
+	if len(counts) != 1 || counts[0] != 6 {
+		t.Errorf("stream counts written = %v; want [6]", counts)
+	}
+}
+
+func TestActivityWriterRequeues(t *testing.T) {
+	fail := true
+	var written []patchRecord
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body struct {
+			Records []patchRecord `json:"records"`
+		}
+		_ = json.NewDecoder(r.Body).Decode(&body)
+		if fail {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		for _, record := range body.Records {
+			// a row that got deleted fails the whole batch it's in
+			if record.ID == "deleted" {
+				w.WriteHeader(http.StatusUnprocessableEntity)
+				_, _ = w.Write([]byte(`{"error":{"type":"ROW_DOES_NOT_EXIST"}}`))
+				return
+			}
+		}
+		written = append(written, body.Records...)
+		_, _ = w.Write([]byte(`{"records":[]}`))
+	}))
+	defer srv.Close()
+
+	at := New("key", "base", "Streamers")
+	at.apiURL = srv.URL
+	aw := at.NewActivityWriter(time.Hour)
+
+	started := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	member := roster.Member{RecordID: "rec1", Source: SourceName}
+	aw.StreamStarted(member, "1", "Art", "drawing", started)
+	aw.timer.Stop()
+	if err := aw.Flush(); err == nil {
+		t.Fatal("Flush() = nil; want airtable's error")
+	}
+	if aw.timer == nil || aw.failures != 1 {
+		t.Fatal("Flush() failed without scheduling another one")
+	}
+	aw.timer.Stop()
+
+	fail = false
+	aw.StreamEnded(member, "1", started.Add(2*time.Hour))
+	aw.StreamEnded(roster.Member{RecordID: "deleted", Source: SourceName}, "2", started)
+	if err := aw.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if len(written) != 1 || written[0].ID != "rec1" {
+		t.Fatalf("written = %+v; want rec1 written once", written)
+	}
+	fields := written[0].Fields
+	if fields["Last Live At"] != "2024-01-02T03:04:05Z" || fields["Last Ended At"] != "2024-01-02T05:04:05Z" || fields["Last Game"] != "Art" {
+		t.Errorf("fields = %v; want the failed start kept alongside the end", fields)
+	}
+	if len(aw.pending) != 0 || aw.failures != 0 {
+		t.Errorf("pending = %v; want the deleted row dropped", aw.pending)
+	}
+}
@@ -0,0 +1,130 @@
+package airtable
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/halkeye/twitch_go_online/internal/roster"
+)
+
+// Columns names the airtable fields a Member is read from. An empty name means the table doesn't have that column.
+type Columns struct {
+	TwitchLogin string
+	DisplayName string
+	// Enabled is a checkbox, airtable leaves unticked checkboxes out entirely so without this column everyone is enabled
+	Enabled     string
+	Message     string
+	DiscordRole string
+	Channel     string
+	Tags        string
+	// Status is where roster validation writes whether the login resolved on twitch
+	Status string
+	// GameChanges is a checkbox opting in to game switch announcements
+	GameChanges string
+	// Shoutouts is a checkbox opting in to shouting out roster members who raid
+	Shoutouts string
+
+	// stream activity written back to the roster
+	LastLiveAt  string
+	LastEndedAt string
+	LastGame    string
+	LastTitle   string
+	StreamCount string
+	ResolvedID  string
+}
+
+func DefaultColumns() Columns {
+	return Columns{
+		TwitchLogin: "Twitch Account",
+		DisplayName: "Display Name",
+		Message:     "Message",
+		DiscordRole: "Discord Role",
+		Channel:     "Discord Channel",
+		Tags:        "Tags",
+		Status:      "Twitch Status",
+		GameChanges: "Announce Game Changes",
+		Shoutouts:   "Shoutout Raiders",
+		LastLiveAt:  "Last Live At",
+		LastEndedAt: "Last Ended At",
+		LastGame:    "Last Game",
+		LastTitle:   "Last Title",
+		StreamCount: "Stream Count",
+		ResolvedID:  "Resolved Twitch ID",
+	}
+}
+
+func (c Columns) member(rec record) roster.Member {
+	member := roster.Member{
+		RecordID:    rec.ID,
+		TwitchLogin: roster.NormalizeLogin(stringField(rec.Fields, c.TwitchLogin)),
+		DisplayName: stringField(rec.Fields, c.DisplayName),
+		Enabled:     true,
+		Message:     stringField(rec.Fields, c.Message),
+		DiscordRole: stringField(rec.Fields, c.DiscordRole),
+		Channel:     stringField(rec.Fields, c.Channel),
+		Tags:        listField(rec.Fields, c.Tags),
+		Status:      stringField(rec.Fields, c.Status),
+		ResolvedID:  stringField(rec.Fields, c.ResolvedID),
+		Source:      SourceName,
+	}
+	if count, ok := rec.Fields[c.StreamCount].(float64); ok {
+		member.StreamCount = int(count)
+	}
+	if len(c.GameChanges) != 0 {
+		member.GameChanges, _ = rec.Fields[c.GameChanges].(bool)
+	}
+	if len(c.Shoutouts) != 0 {
+		member.Shoutouts, _ = rec.Fields[c.Shoutouts].(bool)
+	}
+	if len(c.Enabled) != 0 {
+		enabled, _ := rec.Fields[c.Enabled].(bool)
+		member.Enabled = enabled
+	}
+	return member
+}
+
+func stringField(fields map[string]interface{}, name string) string {
+	if len(name) == 0 {
+		return ""
+	}
+
+	switch val := fields[name].(type) {
+	case nil:
+		return ""
+	case string:
+		return strings.TrimSpace(val)
+	case []interface{}:
+		// lookups and linked fields come back as lists, use the first one
+		if len(val) == 0 {
+			return ""
+		}
+		return strings.TrimSpace(fmt.Sprint(val[0]))
+	default:
+		return strings.TrimSpace(fmt.Sprint(val))
+	}
+}
+
+// listField reads a multiple select, or a comma separated text field.
+func listField(fields map[string]interface{}, name string) []string {
+	list := []string{}
+	if len(name) == 0 {
+		return list
+	}
+
+	var values []string
+	switch val := fields[name].(type) {
+	case []interface{}:
+		for _, v := range val {
+			values = append(values, fmt.Sprint(v))
+		}
+	case string:
+		values = strings.Split(val, ",")
+	}
+
+	for _, v := range values {
+		if v = strings.TrimSpace(v); len(v) > 0 {
+			list = append(list, v)
+		}
+	}
+	return list
+}
@@ -0,0 +1,25 @@
+package airtable
+
+import "github.com/halkeye/twitch_go_online/internal/roster"
+
+// SetStatuses writes validation results back to the roster, leaving rows that already say the same thing alone.
+func (at *Airtable) SetStatuses(results []roster.StatusResult) error {
+	updates := map[string]map[string]interface{}{}
+	for _, result := range results {
+		fields := map[string]interface{}{}
+		if len(at.Columns.Status) != 0 && result.Member.Status != result.Status {
+			fields[at.Columns.Status] = result.Status
+		}
+		if len(at.Columns.ResolvedID) != 0 && len(result.UserID) != 0 && result.Member.ResolvedID != result.UserID {
+			fields[at.Columns.ResolvedID] = result.UserID
+		}
+		if len(at.Columns.TwitchLogin) != 0 && len(result.Login) != 0 && result.Login != result.Member.TwitchLogin {
+			fields[at.Columns.TwitchLogin] = result.Login
+		}
+		if len(fields) != 0 && ours(result.Member) {
+			updates[result.Member.RecordID] = fields
+		}
+	}
+	_, err := at.updateRecords(updates)
+	return err
+}
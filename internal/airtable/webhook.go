@@ -0,0 +1,177 @@
+package airtable
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/halkeye/twitch_go_online/internal/roster"
+)
+
+// recordsPerLookup keeps the RECORD_ID() formula, and so the url, a reasonable size
+const recordsPerLookup = 50
+
+// https://airtable.com/developers/web/api/model/webhooks-payload
+type payloadsResponse struct {
+	Payloads []struct {
+		ChangedTablesByID map[string]struct {
+			CreatedRecordsByID map[string]json.RawMessage `json:"createdRecordsById"`
+			ChangedRecordsByID map[string]json.RawMessage `json:"changedRecordsById"`
+			DestroyedRecordIDs []string                   `json:"destroyedRecordIds"`
+		} `json:"changedTablesById"`
+	} `json:"payloads"`
+	Cursor        int  `json:"cursor"`
+	MightHaveMore bool `json:"mightHaveMore"`
+}
+
+// verifyMAC checks the X-Airtable-Content-MAC header against the body.
+func (at *Airtable) verifyMAC(body []byte, header string) bool {
+	at.syncMu.Lock()
+	secret, err := base64.StdEncoding.DecodeString(at.MACSecret)
+	at.syncMu.Unlock()
+	if err != nil || len(secret) == 0 {
+		return false
+	}
+
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(body)
+	expected := "hmac-sha256=" + hex.EncodeToString(mac.Sum(nil))
+	return hmac.Equal([]byte(expected), []byte(header))
+}
+
+// payloads returns the record ids touched since cursor, and the cursor to carry on from next time.
+func (at *Airtable) payloads(webhookID string, cursor int) (map[string]bool, int, error) {
+	touched := map[string]bool{}
+
+	for {
+		endpoint := fmt.Sprintf("%s/v0/bases/%s/webhooks/%s/payloads?cursor=%d", at.apiURL, at.BaseID, webhookID, cursor)
+
+		var result payloadsResponse
+		if err := at.get(endpoint, &result); err != nil {
+			return nil, cursor, errors.Wrap(err, "unable to list webhook payloads")
+		}
+
+		for _, payload := range result.Payloads {
+			for _, table := range payload.ChangedTablesByID {
+				for id := range table.CreatedRecordsByID {
+					touched[id] = true
+				}
+				for id := range table.ChangedRecordsByID {
+					touched[id] = true
+				}
+				for _, id := range table.DestroyedRecordIDs {
+					touched[id] = true
+				}
+			}
+		}
+
+		cursor = result.Cursor
+		if !result.MightHaveMore {
+			return touched, cursor, nil
+		}
+	}
+}
+
+// latestCursor skips over any payloads already waiting, the roster gets loaded in full on startup anyways.
+func (at *Airtable) latestCursor(webhookID string) (int, error) {
+	_, cursor, err := at.payloads(webhookID, 1)
+	return cursor, err
+}
+
+// changesSinceCursor works out what happened to the roster since the last notification.
+func (at *Airtable) changesSinceCursor() ([]roster.Change, []roster.Member, error) {
+	at.syncMu.Lock()
+	defer at.syncMu.Unlock()
+
+	if len(at.webhookID) == 0 {
+		return nil, nil, errors.New("no webhook registered")
+	}
+
+	touched, cursor, err := at.payloads(at.webhookID, at.cursor)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	ids := []string{}
+	for id := range touched {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	// the payloads cover the whole base, anything that isn't in our table (or our view/filter) just doesn't come back
+	current, err := at.membersByID(ids)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	changes := []roster.Change{}
+	for _, id := range ids {
+		previous, wasKnown := at.known[id]
+		member, isKnown := current[id]
+
+		switch {
+		case wasKnown && !isKnown:
+			changes = append(changes, roster.Change{Kind: roster.MemberRemoved, Previous: previous})
+			delete(at.known, id)
+		case !wasKnown && isKnown:
+			changes = append(changes, roster.Change{Kind: roster.MemberAdded, Member: member})
+			at.known[id] = member
+		case wasKnown && isKnown && !reflect.DeepEqual(previous, member):
+			changes = append(changes, roster.Change{Kind: roster.MemberChanged, Member: member, Previous: previous})
+			at.known[id] = member
+		}
+	}
+	at.cursor = cursor
+
+	members := []roster.Member{}
+	for _, member := range at.known {
+		members = append(members, member)
+	}
+	sort.Slice(members, func(i, j int) bool { return members[i].TwitchLogin < members[j].TwitchLogin })
+
+	log.WithField("changes", len(changes)).Infof("airtable roster moved to cursor %d", cursor)
+	return changes, members, nil
+}
+
+// membersByID looks up records in our table, honouring the configured view and filter.
+func (at *Airtable) membersByID(ids []string) (map[string]roster.Member, error) {
+	members := map[string]roster.Member{}
+
+	for start := 0; start < len(ids); start += recordsPerLookup {
+		end := start + recordsPerLookup
+		if end > len(ids) {
+			end = len(ids)
+		}
+
+		matches := []string{}
+		for _, id := range ids[start:end] {
+			matches = append(matches, fmt.Sprintf("RECORD_ID()='%s'", id))
+		}
+		formula := fmt.Sprintf("OR(%s)", strings.Join(matches, ","))
+		if len(at.FilterByFormula) != 0 {
+			formula = fmt.Sprintf("AND(%s,%s)", formula, at.FilterByFormula)
+		}
+
+		records, err := at.recordsMatching(formula)
+		if err != nil {
+			return nil, errors.Wrap(err, "unable to fetch changed rows")
+		}
+		for _, record := range records {
+			member := at.Columns.member(record)
+			if len(member.TwitchLogin) != 0 {
+				members[record.ID] = member
+			}
+		}
+	}
+
+	return members, nil
+}
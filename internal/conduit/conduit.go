@@ -0,0 +1,325 @@
+package conduit
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"time"
+
+	helix "github.com/nicklaw5/helix/v2"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+// Method is the transport method for subscriptions delivered through a conduit.
+const Method = "conduit"
+
+const defaultBaseURL = "https://api.twitch.tv/helix"
+
+type tokenFunc func() string
+
+// Client talks to the helix conduit endpoints, which the helix library doesn't cover yet.
+// Conduits only work with an app access token.
+type Client struct {
+	ClientID string
+	BaseURL  string
+
+	token      tokenFunc
+	httpClient http.Client
+}
+
+type Conduit struct {
+	ID         string `json:"id"`
+	ShardCount int    `json:"shard_count"`
+}
+
+type Transport struct {
+	Method         string `json:"method"`
+	Callback       string `json:"callback,omitempty"`
+	Secret         string `json:"secret,omitempty"`
+	SessionID      string `json:"session_id,omitempty"`
+	ConnectedAt    string `json:"connected_at,omitempty"`
+	DisconnectedAt string `json:"disconnected_at,omitempty"`
+}
+
+type Shard struct {
+	ID        string    `json:"id"`
+	Status    string    `json:"status"`
+	Transport Transport `json:"transport"`
+}
+
+type conduitsResponse struct {
+	Data []Conduit `json:"data"`
+}
+
+type shardsResponse struct {
+	Data       []Shard `json:"data"`
+	Pagination struct {
+		Cursor string `json:"cursor"`
+	} `json:"pagination"`
+}
+
+// subscriptionsResponse keeps the conduit_id that helix.EventSubTransport leaves out.
+type subscriptionsResponse struct {
+	Data []struct {
+		helix.EventSubSubscription
+		Transport struct {
+			Method    string `json:"method"`
+			ConduitID string `json:"conduit_id"`
+		} `json:"transport"`
+	} `json:"data"`
+	Pagination struct {
+		Cursor string `json:"cursor"`
+	} `json:"pagination"`
+}
+
+type shardErrorsResponse struct {
+	Errors []struct {
+		ID      string `json:"id"`
+		Message string `json:"message"`
+		Code    string `json:"code"`
+	} `json:"errors"`
+}
+
+func New(clientID string, token tokenFunc) *Client {
+	return &Client{
+		ClientID:   clientID,
+		BaseURL:    defaultBaseURL,
+		token:      token,
+		httpClient: http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+func (c *Client) Conduits() ([]Conduit, error) {
+	var result conduitsResponse
+	if err := c.do(http.MethodGet, "/eventsub/conduits", nil, &result); err != nil {
+		return nil, errors.Wrap(err, "unable to list conduits")
+	}
+	return result.Data, nil
+}
+
+func (c *Client) Create(shardCount int) (Conduit, error) {
+	var result conduitsResponse
+	if err := c.do(http.MethodPost, "/eventsub/conduits", map[string]interface{}{"shard_count": shardCount}, &result); err != nil {
+		return Conduit{}, errors.Wrap(err, "unable to create conduit")
+	}
+	if len(result.Data) == 0 {
+		return Conduit{}, errors.New("no conduit returned")
+	}
+	return result.Data[0], nil
+}
+
+func (c *Client) Update(id string, shardCount int) (Conduit, error) {
+	var result conduitsResponse
+	if err := c.do(http.MethodPatch, "/eventsub/conduits", map[string]interface{}{"id": id, "shard_count": shardCount}, &result); err != nil {
+		return Conduit{}, errors.Wrap(err, "unable to update conduit")
+	}
+	if len(result.Data) == 0 {
+		return Conduit{}, errors.New("no conduit returned")
+	}
+	return result.Data[0], nil
+}
+
+func (c *Client) Delete(id string) error {
+	err := c.do(http.MethodDelete, "/eventsub/conduits?"+url.Values{"id": {id}}.Encode(), nil, nil)
+	return errors.Wrap(err, "unable to delete conduit")
+}
+
+// Ensure returns the conduit with id (or the app's first conduit, by id, when id is empty), creating
+// it if needed and growing it to at least shardCount shards.
+//
+// Instances starting together can each create a conduit before any of them sees another's. They all
+// settle on the first one afterwards, and the rest get deleted by whoever created them.
+func (c *Client) Ensure(id string, shardCount int) (Conduit, error) {
+	existing, found, err := c.find(id)
+	if err != nil {
+		return Conduit{}, err
+	}
+	if found {
+		return c.grow(existing, shardCount)
+	}
+	if len(id) != 0 {
+		return Conduit{}, errors.Errorf("conduit %s not found", id)
+	}
+
+	created, err := c.Create(shardCount)
+	if err != nil {
+		return Conduit{}, err
+	}
+	log.Infof("Created conduit %s with %d shards", created.ID, created.ShardCount)
+
+	first, _, err := c.find("")
+	if err != nil || first.ID == created.ID {
+		return created, err
+	}
+	log.Infof("Conduit %s was created alongside ours, using it and deleting %s", first.ID, created.ID)
+	if err := c.Delete(created.ID); err != nil {
+		return Conduit{}, err
+	}
+	return c.grow(first, shardCount)
+}
+
+// find returns the conduit with id, or the first one by id when id is empty.
+func (c *Client) find(id string) (Conduit, bool, error) {
+	conduits, err := c.Conduits()
+	if err != nil {
+		return Conduit{}, false, err
+	}
+	sort.Slice(conduits, func(i, j int) bool { return conduits[i].ID < conduits[j].ID })
+	for _, existing := range conduits {
+		if len(id) == 0 || existing.ID == id {
+			return existing, true, nil
+		}
+	}
+	return Conduit{}, false, nil
+}
+
+func (c *Client) grow(existing Conduit, shardCount int) (Conduit, error) {
+	if existing.ShardCount >= shardCount {
+		return existing, nil
+	}
+	log.Infof("Growing conduit %s from %d to %d shards", existing.ID, existing.ShardCount, shardCount)
+	return c.Update(existing.ID, shardCount)
+}
+
+func (c *Client) Shards(conduitID string) ([]Shard, error) {
+	shards := []Shard{}
+	cursor := ""
+	for {
+		query := url.Values{"conduit_id": {conduitID}}
+		if len(cursor) != 0 {
+			query.Set("after", cursor)
+		}
+
+		var result shardsResponse
+		if err := c.do(http.MethodGet, "/eventsub/conduits/shards?"+query.Encode(), nil, &result); err != nil {
+			return nil, errors.Wrap(err, "unable to list conduit shards")
+		}
+		shards = append(shards, result.Data...)
+
+		if len(result.Pagination.Cursor) == 0 {
+			return shards, nil
+		}
+		cursor = result.Pagination.Cursor
+	}
+}
+
+// AssignShard points a shard at a webhook callback or websocket session.
+func (c *Client) AssignShard(conduitID string, shardID string, transport Transport) error {
+	body := map[string]interface{}{
+		"conduit_id": conduitID,
+		"shards": []map[string]interface{}{
+			{"id": shardID, "transport": transport},
+		},
+	}
+
+	var result shardErrorsResponse
+	if err := c.do(http.MethodPatch, "/eventsub/conduits/shards", body, &result); err != nil {
+		return errors.Wrap(err, "unable to update conduit shard")
+	}
+	if len(result.Errors) != 0 {
+		return errors.Errorf("unable to update shard %s: %s (%s)", result.Errors[0].ID, result.Errors[0].Message, result.Errors[0].Code)
+	}
+	return nil
+}
+
+// CreateSubscription subscribes the conduit to subType. A subscription that already exists is not an error,
+// every instance sharing the conduit registers the same subscriptions.
+func (c *Client) CreateSubscription(conduitID string, subType string, version string, condition helix.EventSubCondition) error {
+	body := map[string]interface{}{
+		"type":      subType,
+		"version":   version,
+		"condition": condition,
+		"transport": map[string]string{"method": Method, "conduit_id": conduitID},
+	}
+
+	err := c.do(http.MethodPost, "/eventsub/subscriptions", body, nil)
+	if statusErr, ok := errors.Cause(err).(statusError); ok && statusErr.status == http.StatusConflict {
+		return nil
+	}
+	if err != nil {
+		return errors.Wrap(err, "unable to create conduit subscription")
+	}
+	return nil
+}
+
+// Subscriptions returns the eventsub subscriptions delivered through conduitID, other conduits of the same app are left out.
+func (c *Client) Subscriptions(conduitID string) ([]helix.EventSubSubscription, error) {
+	subs := []helix.EventSubSubscription{}
+	cursor := ""
+	for {
+		path := "/eventsub/subscriptions"
+		if len(cursor) != 0 {
+			path += "?" + url.Values{"after": {cursor}}.Encode()
+		}
+
+		var result subscriptionsResponse
+		if err := c.do(http.MethodGet, path, nil, &result); err != nil {
+			return nil, errors.Wrap(err, "unable to list subscriptions")
+		}
+		for _, sub := range result.Data {
+			if sub.Transport.Method != Method || sub.Transport.ConduitID != conduitID {
+				continue
+			}
+			sub.EventSubSubscription.Transport = helix.EventSubTransport{Method: Method}
+			subs = append(subs, sub.EventSubSubscription)
+		}
+
+		if len(result.Pagination.Cursor) == 0 {
+			return subs, nil
+		}
+		cursor = result.Pagination.Cursor
+	}
+}
+
+type statusError struct {
+	status int
+	body   string
+}
+
+func (e statusError) Error() string {
+	return fmt.Sprintf("twitch returned %d: %s", e.status, e.body)
+}
+
+func (c *Client) do(method string, path string, body interface{}, result interface{}) error {
+	var payload []byte
+	var err error
+	if body != nil {
+		payload, err = json.Marshal(body)
+		if err != nil {
+			return errors.Wrap(err, "unable to create payload")
+		}
+	}
+
+	log.WithField("path", path).Debugf("%s conduit endpoint", method)
+	req, err := http.NewRequest(method, c.BaseURL+path, bytes.NewReader(payload))
+	if err != nil {
+		return errors.Wrap(err, "unable to create request")
+	}
+
+	req.Header.Set("Client-Id", c.ClientID)
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", c.token()))
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return errors.Wrap(err, "unable to make request")
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		respBody, _ := io.ReadAll(resp.Body)
+		return errors.WithStack(statusError{status: resp.StatusCode, body: string(respBody)})
+	}
+
+	if result != nil {
+		if err := json.NewDecoder(resp.Body).Decode(result); err != nil {
+			return errors.Wrap(err, "unable to decode response")
+		}
+	}
+	return nil
+}
@@ -0,0 +1,183 @@
+package conduit
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fakeConduits is the conduit endpoints of one app, with whatever conduits it was given.
+type fakeConduits struct {
+	mu       sync.Mutex
+	conduits []Conduit
+	created  int
+	// racing is created by another instance at the same time as ours
+	racing  *Conduit
+	deleted []string
+	// shard assignments as they were sent
+	assigned []map[string]interface{}
+	// subscriptions pages, keyed by the after cursor that gets them
+	subscriptions map[string]string
+}
+
+func (f *fakeConduits) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if r.Header.Get("Authorization") != "Bearer app-token" || r.Header.Get("Client-Id") != "id" {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	switch r.Method + " " + r.URL.Path {
+	case "GET /eventsub/conduits":
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"data": f.conduits})
+	case "POST /eventsub/conduits":
+		var body struct {
+			ShardCount int `json:"shard_count"`
+		}
+		_ = json.NewDecoder(r.Body).Decode(&body)
+		f.created++
+		created := Conduit{ID: "new", ShardCount: body.ShardCount}
+		f.conduits = append(f.conduits, created)
+		if f.racing != nil {
+			f.conduits = append(f.conduits, *f.racing)
+		}
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"data": []Conduit{created}})
+	case "PATCH /eventsub/conduits":
+		var body Conduit
+		_ = json.NewDecoder(r.Body).Decode(&body)
+		for i := range f.conduits {
+			if f.conduits[i].ID == body.ID {
+				f.conduits[i].ShardCount = body.ShardCount
+				_ = json.NewEncoder(w).Encode(map[string]interface{}{"data": []Conduit{f.conduits[i]}})
+				return
+			}
+		}
+		w.WriteHeader(http.StatusNotFound)
+	case "DELETE /eventsub/conduits":
+		id := r.URL.Query().Get("id")
+		f.deleted = append(f.deleted, id)
+		for i := range f.conduits {
+			if f.conduits[i].ID == id {
+				f.conduits = append(f.conduits[:i], f.conduits[i+1:]...)
+				w.WriteHeader(http.StatusNoContent)
+				return
+			}
+		}
+		w.WriteHeader(http.StatusNotFound)
+	case "PATCH /eventsub/conduits/shards":
+		var body map[string]interface{}
+		_ = json.NewDecoder(r.Body).Decode(&body)
+		f.assigned = append(f.assigned, body)
+		shard := body["shards"].([]interface{})[0].(map[string]interface{})
+		if shard["id"] == "9" {
+			_, _ = w.Write([]byte(`{"data":[],"errors":[{"id":"9","message":"shard out of range","code":"invalid_parameter"}]}`))
+			return
+		}
+		_, _ = w.Write([]byte(`{"data":[],"errors":[]}`))
+	case "GET /eventsub/subscriptions":
+		_, _ = w.Write([]byte(f.subscriptions[r.URL.Query().Get("after")]))
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func newTestClient(t *testing.T, fake *fakeConduits) *Client {
+	srv := httptest.NewServer(fake)
+	t.Cleanup(srv.Close)
+
+	c := New("id", func() string { return "app-token" })
+	c.BaseURL = srv.URL
+	return c
+}
+
+func TestEnsure(t *testing.T) {
+	fake := &fakeConduits{conduits: []Conduit{{ID: "a", ShardCount: 1}, {ID: "b", ShardCount: 4}}}
+	c := newTestClient(t, fake)
+
+	if got, err := c.Ensure("b", 2); err != nil || got.ID != "b" || got.ShardCount != 4 {
+		t.Errorf("Ensure(b, 2) = %+v, %v; want b left at 4 shards", got, err)
+	}
+	if got, err := c.Ensure("a", 3); err != nil || got.ID != "a" || got.ShardCount != 3 {
+		t.Errorf("Ensure(a, 3) = %+v, %v; want a grown to 3 shards", got, err)
+	}
+	if _, err := c.Ensure("missing", 1); err == nil {
+		t.Errorf("Ensure(missing) made up a conduit that doesn't exist")
+	}
+	if fake.created != 0 {
+		t.Errorf("Ensure() created %d conduits while existing ones were asked for", fake.created)
+	}
+
+	fake.conduits = nil
+	if got, err := c.Ensure("", 2); err != nil || got.ID != "new" || got.ShardCount != 2 || fake.created != 1 {
+		t.Errorf("Ensure() without any conduits = %+v, %v; want one created with 2 shards", got, err)
+	}
+	if got, err := c.Ensure("", 1); err != nil || got.ID != "new" || fake.created != 1 {
+		t.Errorf("Ensure() = %+v, %v; want the conduit created last time", got, err)
+	}
+
+	// another instance created one at the same time, everyone settles on the first by id
+	fake.conduits = nil
+	fake.racing = &Conduit{ID: "another", ShardCount: 1}
+	if got, err := c.Ensure("", 2); err != nil || got.ID != "another" || got.ShardCount != 2 {
+		t.Errorf("Ensure() racing another instance = %+v, %v; want theirs grown to 2 shards", got, err)
+	}
+	if len(fake.deleted) != 1 || fake.deleted[0] != "new" || len(fake.conduits) != 1 {
+		t.Errorf("deleted %v, leaving %+v; want ours deleted", fake.deleted, fake.conduits)
+	}
+}
+
+func TestAssignShard(t *testing.T) {
+	fake := &fakeConduits{}
+	c := newTestClient(t, fake)
+
+	err := c.AssignShard("a", "1", Transport{Method: "webhook", Callback: "https://example.com/webhook/callbacks", Secret: "s3cret"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(fake.assigned) != 1 || fake.assigned[0]["conduit_id"] != "a" {
+		t.Fatalf("assigned = %+v", fake.assigned)
+	}
+	shard := fake.assigned[0]["shards"].([]interface{})[0].(map[string]interface{})
+	transport := shard["transport"].(map[string]interface{})
+	if shard["id"] != "1" || transport["method"] != "webhook" || transport["callback"] != "https://example.com/webhook/callbacks" || transport["secret"] != "s3cret" {
+		t.Errorf("shard = %+v", shard)
+	}
+	if _, ok := transport["session_id"]; ok {
+		t.Errorf("webhook shard was sent a session_id: %+v", transport)
+	}
+
+	err = c.AssignShard("a", "9", Transport{Method: "websocket", SessionID: "session"})
+	if err == nil || !strings.Contains(err.Error(), "shard out of range") {
+		t.Errorf("AssignShard() = %v; want twitch's shard error", err)
+	}
+}
+
+func TestSubscriptions(t *testing.T) {
+	fake := &fakeConduits{subscriptions: map[string]string{
+		"": `{"data":[
+			{"id":"1","type":"stream.online","condition":{"broadcaster_user_id":"10"},"transport":{"method":"conduit","conduit_id":"ours"}},
+			{"id":"2","type":"stream.online","condition":{"broadcaster_user_id":"10"},"transport":{"method":"conduit","conduit_id":"someone-elses"}}
+		],"pagination":{"cursor":"next"}}`,
+		"next": `{"data":[
+			{"id":"3","type":"stream.offline","condition":{"broadcaster_user_id":"10"},"transport":{"method":"webhook","callback":"https://example.com"}},
+			{"id":"4","type":"stream.offline","condition":{"broadcaster_user_id":"10"},"transport":{"method":"conduit","conduit_id":"ours"}}
+		],"pagination":{}}`,
+	}}
+	c := newTestClient(t, fake)
+
+	subs, err := c.Subscriptions("ours")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(subs) != 2 || subs[0].ID != "1" || subs[1].ID != "4" {
+		t.Fatalf("Subscriptions() = %+v; want only 1 and 4 from our conduit", subs)
+	}
+	if subs[1].Type != "stream.offline" || subs[1].Condition.BroadcasterUserID != "10" || subs[1].Transport.Method != Method {
+		t.Errorf("Subscriptions() = %+v", subs[1])
+	}
+}
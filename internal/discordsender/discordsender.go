@@ -4,7 +4,11 @@ import (
 	"bytes"
 	"encoding/json"
 	"html/template"
+	"io"
 	"net/http"
+	"net/url"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/pkg/errors"
@@ -14,7 +18,26 @@ import (
 type DiscordSender struct {
 	discordWebhook string
 	tmpl           *template.Template
-	lastBody       string
+
+	mu sync.Mutex
+	// per streamer templates, parsed once
+	customTmpls map[string]*template.Template
+}
+
+// Message customizes a single announcement, empty fields fall back to the defaults.
+type Message struct {
+	// Template replaces the go live message
+	Template string
+	// RoleID is a discord role to ping with the announcement
+	RoleID string
+	// Webhook sends the announcement to a different channel
+	Webhook string
+}
+
+// Sent is a message discord accepted, enough to edit it later.
+type Sent struct {
+	Webhook   string
+	MessageID string
 }
 
 const (
@@ -32,47 +55,164 @@ func New(discordWebhook string, goliveMessage string) *DiscordSender {
 	return &DiscordSender{
 		discordWebhook: discordWebhook,
 		tmpl:           template.Must(template.New("message").Parse(goliveMessage)),
+		customTmpls:    map[string]*template.Template{},
 	}
 }
 
 func (ds *DiscordSender) Send(tmplParams map[string]string) error {
-	if len(ds.discordWebhook) == 0 {
+	_, err := ds.SendMessage(Message{}, tmplParams)
+	return err
+}
+
+// SendMessage posts the message, and returns where it went. Nothing is returned when there was nothing to send.
+func (ds *DiscordSender) SendMessage(msg Message, tmplParams map[string]string) (*Sent, error) {
+	webhook := ds.discordWebhook
+	if len(msg.Webhook) != 0 {
+		webhook = msg.Webhook
+	}
+	if len(webhook) == 0 {
 		log.Info("No webhook setup, so bailing")
-		return nil
+		return nil, nil
 	}
 
-	var templateOutput bytes.Buffer
-	err := ds.tmpl.Execute(&templateOutput, tmplParams)
+	tmplString, err := ds.render(msg.Template, tmplParams)
+	if err != nil {
+		return nil, err
+	}
+
+	body := map[string]interface{}{"content": tmplString}
+	if roleID := strings.Trim(msg.RoleID, "<@&>"); len(roleID) != 0 {
+		body["content"] = "<@&" + roleID + "> " + tmplString
+		body["allowed_mentions"] = map[string]interface{}{"roles": []string{roleID}}
+	}
 
+	// wait=true gets discord to hand the message back, we need its id to edit it
+	var message struct {
+		ID string `json:"id"`
+	}
+	if err := do(http.MethodPost, webhookURL(webhook, "", true), body, &message); err != nil {
+		return nil, errors.Wrap(err, "posting to discord failed")
+	}
+	return &Sent{Webhook: webhook, MessageID: message.ID}, nil
+}
+
+// EditMessage replaces the content of a message sent earlier with the custom template.
+func (ds *DiscordSender) EditMessage(sent Sent, custom string, tmplParams map[string]string) error {
+	content, err := ds.render(custom, tmplParams)
 	if err != nil {
-		return errors.Wrap(err, "Error populating template")
+		return err
+	}
+
+	// leave out allowed_mentions so an edit never pings anyone
+	body := map[string]interface{}{"content": content, "allowed_mentions": map[string]interface{}{"parse": []string{}}}
+	if err := do(http.MethodPatch, webhookURL(sent.Webhook, sent.MessageID, false), body, nil); err != nil {
+		return errors.Wrap(err, "editing discord message failed")
+	}
+	return nil
+}
+
+// AppendMessage adds the custom template to the end of a message sent earlier, keeping whatever it says now.
+func (ds *DiscordSender) AppendMessage(sent Sent, custom string, tmplParams map[string]string) error {
+	addition, err := ds.render(custom, tmplParams)
+	if err != nil {
+		return err
+	}
+
+	var message struct {
+		Content string `json:"content"`
+	}
+	if err := do(http.MethodGet, webhookURL(sent.Webhook, sent.MessageID, false), nil, &message); err != nil {
+		return errors.Wrap(err, "fetching discord message failed")
 	}
 
-	tmplString := string(templateOutput.String())
+	body := map[string]interface{}{"content": message.Content + "\n\n" + addition, "allowed_mentions": map[string]interface{}{"parse": []string{}}}
+	if err := do(http.MethodPatch, webhookURL(sent.Webhook, sent.MessageID, false), body, nil); err != nil {
+		return errors.Wrap(err, "editing discord message failed")
+	}
+	return nil
+}
 
-	if ds.lastBody == tmplString {
-		log.Info("Duplicate post body, skipping for now")
-		return nil
+func (ds *DiscordSender) render(custom string, tmplParams map[string]string) (string, error) {
+	tmpl, err := ds.template(custom)
+	if err != nil {
+		return "", errors.Wrap(err, "Error parsing custom template")
 	}
 
-	ds.lastBody = tmplString
+	var templateOutput bytes.Buffer
+	if err := tmpl.Execute(&templateOutput, tmplParams); err != nil {
+		return "", errors.Wrap(err, "Error populating template")
+	}
+	return templateOutput.String(), nil
+}
 
-	jsonBody, err := json.Marshal(map[string]interface{}{"content": tmplString})
+// webhookURL points at the webhook, or one of its messages, keeping any query (thread_id) it already had.
+func webhookURL(webhook string, messageID string, wait bool) string {
+	u, err := url.Parse(webhook)
 	if err != nil {
-		return errors.Wrap(err, "unable to create json to send to discord")
+		return webhook
+	}
+	if len(messageID) != 0 {
+		u.Path = strings.TrimSuffix(u.Path, "/") + "/messages/" + messageID
+	}
+	if wait {
+		query := u.Query()
+		query.Set("wait", "true")
+		u.RawQuery = query.Encode()
+	}
+	return u.String()
+}
+
+func do(method string, endpoint string, body interface{}, result interface{}) error {
+	var reqBody io.Reader
+	if body != nil {
+		jsonBody, err := json.Marshal(body)
+		if err != nil {
+			return errors.Wrap(err, "unable to create json to send to discord")
+		}
+		reqBody = bytes.NewReader(jsonBody)
 	}
-	bodyReader := bytes.NewReader(jsonBody)
-	req, err := http.NewRequest(http.MethodPost, ds.discordWebhook, bodyReader)
+	req, err := http.NewRequest(method, endpoint, reqBody)
 	if err != nil {
 		return errors.Wrap(err, "unable to create discord http client")
 	}
-	req.Header.Set("Content-Type", "application/json")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
 
 	client := http.Client{Timeout: 30 * time.Second}
-
-	_, err = client.Do(req)
+	resp, err := client.Do(req)
 	if err != nil {
-		return errors.Wrap(err, "posting to discord failed")
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		respBody, _ := io.ReadAll(resp.Body)
+		return errors.Errorf("discord returned %s: %s", resp.Status, respBody)
+	}
+	if result != nil {
+		if err := json.NewDecoder(resp.Body).Decode(result); err != nil {
+			return errors.Wrap(err, "unable to decode discord response")
+		}
 	}
 	return nil
 }
+
+func (ds *DiscordSender) template(custom string) (*template.Template, error) {
+	if len(custom) == 0 {
+		return ds.tmpl, nil
+	}
+
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+
+	if tmpl, ok := ds.customTmpls[custom]; ok {
+		return tmpl, nil
+	}
+	tmpl, err := template.New("custom").Parse(custom)
+	if err != nil {
+		return nil, err
+	}
+	ds.customTmpls[custom] = tmpl
+	return tmpl, nil
+}
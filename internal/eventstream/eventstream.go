@@ -0,0 +1,318 @@
+package eventstream
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	helix "github.com/nicklaw5/helix/v2"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	keepaliveInterval = 30 * time.Second
+	subscriberBuffer  = 64
+)
+
+// Event is the normalized, transport independent form of an EventSub notification
+// that gets pushed to dashboard clients.
+type Event struct {
+	ID               string          `json:"id"`
+	MessageID        string          `json:"message_id"`
+	Type             string          `json:"type"`
+	Version          string          `json:"version"`
+	SubscriptionID   string          `json:"subscription_id"`
+	BroadcasterID    string          `json:"broadcaster_id"`
+	BroadcasterLogin string          `json:"broadcaster_login"`
+	BroadcasterName  string          `json:"broadcaster_name"`
+	Timestamp        time.Time       `json:"timestamp"`
+	Payload          json.RawMessage `json:"payload"`
+}
+
+// broadcasterFields covers the different ways twitch names the channel an event is about.
+type broadcasterFields struct {
+	BroadcasterUserID        string `json:"broadcaster_user_id"`
+	BroadcasterUserLogin     string `json:"broadcaster_user_login"`
+	BroadcasterUserName      string `json:"broadcaster_user_name"`
+	ToBroadcasterUserID      string `json:"to_broadcaster_user_id"`
+	ToBroadcasterUserLogin   string `json:"to_broadcaster_user_login"`
+	ToBroadcasterUserName    string `json:"to_broadcaster_user_name"`
+	FromBroadcasterUserID    string `json:"from_broadcaster_user_id"`
+	FromBroadcasterUserLogin string `json:"from_broadcaster_user_login"`
+	FromBroadcasterUserName  string `json:"from_broadcaster_user_name"`
+}
+
+// Normalize turns an EventSub notification into an Event. The ID is assigned when published.
+func Normalize(messageID string, timestamp string, sub helix.EventSubSubscription, payload json.RawMessage) Event {
+	e := Event{
+		MessageID:      messageID,
+		Type:           sub.Type,
+		Version:        sub.Version,
+		SubscriptionID: sub.ID,
+		Payload:        payload,
+	}
+
+	if parsed, err := time.Parse(time.RFC3339Nano, timestamp); err == nil {
+		e.Timestamp = parsed.UTC()
+	} else {
+		e.Timestamp = time.Now().UTC()
+	}
+
+	var fields broadcasterFields
+	if len(payload) > 0 {
+		_ = json.Unmarshal(payload, &fields)
+	}
+	switch {
+	case fields.BroadcasterUserID != "":
+		e.BroadcasterID, e.BroadcasterLogin, e.BroadcasterName = fields.BroadcasterUserID, fields.BroadcasterUserLogin, fields.BroadcasterUserName
+	case fields.FromBroadcasterUserID != "":
+		e.BroadcasterID, e.BroadcasterLogin, e.BroadcasterName = fields.FromBroadcasterUserID, fields.FromBroadcasterUserLogin, fields.FromBroadcasterUserName
+	case fields.ToBroadcasterUserID != "":
+		e.BroadcasterID, e.BroadcasterLogin, e.BroadcasterName = fields.ToBroadcasterUserID, fields.ToBroadcasterUserLogin, fields.ToBroadcasterUserName
+	default:
+		e.BroadcasterID = sub.Condition.BroadcasterUserID
+	}
+
+	return e
+}
+
+// Hub fans published events out to SSE and WebSocket subscribers and keeps
+// the last few around so reconnecting clients can catch up with Last-Event-ID.
+type Hub struct {
+	token      string
+	replaySize int
+
+	mu sync.Mutex
+	// seq starts at the time the hub was created so ids keep going up across restarts
+	seq         uint64
+	replay      []Event
+	subscribers map[chan Event]struct{}
+
+	upgrader websocket.Upgrader
+}
+
+func New(token string, replaySize int) *Hub {
+	if replaySize <= 0 {
+		replaySize = 100
+	}
+
+	return &Hub{
+		token:       token,
+		replaySize:  replaySize,
+		seq:         uint64(time.Now().UnixNano()),
+		subscribers: map[chan Event]struct{}{},
+		upgrader: websocket.Upgrader{
+			// access is controlled by the token, not the origin
+			CheckOrigin: func(r *http.Request) bool { return true },
+		},
+	}
+}
+
+// Publish assigns the next sequence id to the event, stores it in the replay buffer and
+// hands it to every subscriber. Subscribers that can't keep up are dropped, they can
+// reconnect and replay what they missed.
+func (h *Hub) Publish(e Event) Event {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.seq++
+	e.ID = strconv.FormatUint(h.seq, 10)
+
+	h.replay = append(h.replay, e)
+	if len(h.replay) > h.replaySize {
+		h.replay = h.replay[len(h.replay)-h.replaySize:]
+	}
+
+	for ch := range h.subscribers {
+		select {
+		case ch <- e:
+		default:
+			log.Warn("eventstream subscriber is too slow, dropping it")
+			delete(h.subscribers, ch)
+			close(ch)
+		}
+	}
+
+	return e
+}
+
+// subscribe registers a new subscriber and returns the buffered events after lastEventID.
+// When lastEventID isn't buffered anymore, or is from before a restart, it returns everything that is.
+func (h *Hub) subscribe(lastEventID string) ([]Event, chan Event) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	var missed []Event
+	if len(lastEventID) != 0 {
+		missed = h.replay
+		for i, e := range h.replay {
+			if e.ID == lastEventID {
+				missed = h.replay[i+1:]
+				break
+			}
+		}
+		missed = append([]Event(nil), missed...)
+	}
+
+	ch := make(chan Event, subscriberBuffer)
+	h.subscribers[ch] = struct{}{}
+	return missed, ch
+}
+
+func (h *Hub) unsubscribe(ch chan Event) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if _, ok := h.subscribers[ch]; ok {
+		delete(h.subscribers, ch)
+		close(ch)
+	}
+}
+
+func (h *Hub) authorized(r *http.Request) bool {
+	if len(h.token) == 0 {
+		return false
+	}
+
+	provided := r.URL.Query().Get("token")
+	if auth := r.Header.Get("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+		provided = strings.TrimPrefix(auth, "Bearer ")
+	}
+
+	return subtle.ConstantTimeCompare([]byte(provided), []byte(h.token)) == 1
+}
+
+func lastEventID(r *http.Request) string {
+	if id := r.Header.Get("Last-Event-ID"); id != "" {
+		return id
+	}
+	return r.URL.Query().Get("last_event_id")
+}
+
+// SSEHandler streams events as text/event-stream.
+func (h *Hub) SSEHandler() http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !h.authorized(r) {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		flusher, ok := w.(http.Flusher)
+		if !ok {
+			http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+			return
+		}
+
+		missed, ch := h.subscribe(lastEventID(r))
+		defer h.unsubscribe(ch)
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.Header().Set("Connection", "keep-alive")
+		w.WriteHeader(http.StatusOK)
+
+		for _, e := range missed {
+			if err := writeSSE(w, e); err != nil {
+				return
+			}
+		}
+		flusher.Flush()
+
+		ticker := time.NewTicker(keepaliveInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-r.Context().Done():
+				return
+			case <-ticker.C:
+				if _, err := fmt.Fprint(w, ": keepalive\n\n"); err != nil {
+					return
+				}
+				flusher.Flush()
+			case e, ok := <-ch:
+				if !ok {
+					return
+				}
+				if err := writeSSE(w, e); err != nil {
+					return
+				}
+				flusher.Flush()
+			}
+		}
+	})
+}
+
+func writeSSE(w http.ResponseWriter, e Event) error {
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "id: %s\nevent: %s\ndata: %s\n\n", e.ID, e.Type, data)
+	return err
+}
+
+// WebSocketHandler streams events as JSON text messages.
+func (h *Hub) WebSocketHandler() http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !h.authorized(r) {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		conn, err := h.upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			log.WithError(err).Warn("unable to upgrade eventstream websocket")
+			return
+		}
+		defer conn.Close()
+
+		missed, ch := h.subscribe(lastEventID(r))
+		defer h.unsubscribe(ch)
+
+		// we don't expect anything from the client, but reading is how we notice it went away
+		closed := make(chan struct{})
+		go func() {
+			defer close(closed)
+			for {
+				if _, _, err := conn.ReadMessage(); err != nil {
+					return
+				}
+			}
+		}()
+
+		for _, e := range missed {
+			if err := conn.WriteJSON(e); err != nil {
+				return
+			}
+		}
+
+		ticker := time.NewTicker(keepaliveInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-closed:
+				return
+			case <-ticker.C:
+				if err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(10*time.Second)); err != nil {
+					return
+				}
+			case e, ok := <-ch:
+				if !ok {
+					_ = conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseTryAgainLater, "too slow"), time.Now().Add(time.Second))
+					return
+				}
+				if err := conn.WriteJSON(e); err != nil {
+					return
+				}
+			}
+		}
+	})
+}
@@ -0,0 +1,123 @@
+package eventstream
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	helix "github.com/nicklaw5/helix/v2"
+)
+
+func TestNormalize(t *testing.T) {
+	var tests = []struct {
+		name      string
+		payload   string
+		wantID    string
+		wantLogin string
+	}{
+		{"online", `{"broadcaster_user_id":"1","broadcaster_user_login":"foo"}`, "1", "foo"},
+		{"raid", `{"from_broadcaster_user_id":"2","from_broadcaster_user_login":"bar","to_broadcaster_user_id":"3"}`, "2", "bar"},
+		{"empty", `{}`, "99", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sub := helix.EventSubSubscription{Type: "stream.online", Condition: helix.EventSubCondition{BroadcasterUserID: "99"}}
+			got := Normalize("msg", "2023-01-02T03:04:05.123Z", sub, json.RawMessage(tt.payload))
+			if got.BroadcasterID != tt.wantID || got.BroadcasterLogin != tt.wantLogin {
+				t.Errorf("Normalize(%s) = %s/%s; want %s/%s", tt.payload, got.BroadcasterID, got.BroadcasterLogin, tt.wantID, tt.wantLogin)
+			}
+			if got.Timestamp.Year() != 2023 {
+				t.Errorf("Normalize timestamp = %s", got.Timestamp)
+			}
+		})
+	}
+}
+
+func TestReplay(t *testing.T) {
+	hub := New("secret", 3)
+	published := []Event{}
+	for i := 0; i < 4; i++ {
+		published = append(published, hub.Publish(Event{Type: "stream.online"}))
+	}
+
+	missed, ch := hub.subscribe(published[1].ID)
+	defer hub.unsubscribe(ch)
+	if len(missed) != 2 || missed[0].ID != published[2].ID || missed[1].ID != published[3].ID {
+		t.Errorf("subscribe(%s) replayed %+v; want the last two", published[1].ID, missed)
+	}
+
+	// the first event fell out of the buffer, and a restarted hub doesn't know any of these
+	for _, last := range []string{published[0].ID, "1", "99999999999999999999"} {
+		missed, ch := hub.subscribe(last)
+		hub.unsubscribe(ch)
+		if len(missed) != 3 || missed[0].ID != published[1].ID {
+			t.Errorf("subscribe(%s) replayed %+v; want everything buffered", last, missed)
+		}
+	}
+
+	restarted := New("secret", 3)
+	if id := restarted.Publish(Event{}).ID; mustParse(t, id) <= mustParse(t, published[3].ID) {
+		t.Errorf("restarted hub's ids went back to %s", id)
+	}
+
+	missed, ch2 := hub.subscribe("")
+	defer hub.unsubscribe(ch2)
+	if len(missed) != 0 {
+		t.Errorf("subscribe() replayed %d events; want none", len(missed))
+	}
+}
+
+func mustParse(t *testing.T, id string) uint64 {
+	parsed, err := strconv.ParseUint(id, 10, 64)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return parsed
+}
+
+func TestSSEHandler(t *testing.T) {
+	hub := New("secret", 10)
+	first := hub.Publish(Event{Type: "stream.online"})
+	second := hub.Publish(Event{Type: "stream.offline"})
+
+	srv := httptest.NewServer(hub.SSEHandler())
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL + "?token=wrong")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("bad token status = %d; want 401", resp.StatusCode)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, srv.URL, nil)
+	req.Header.Set("Authorization", "Bearer secret")
+	req.Header.Set("Last-Event-ID", first.ID)
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	reader := bufio.NewReader(resp.Body)
+	var lines []string
+	for len(lines) < 3 {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			t.Fatal(err)
+		}
+		lines = append(lines, strings.TrimSpace(line))
+	}
+	if lines[0] != "id: "+second.ID || lines[1] != "event: stream.offline" || !strings.HasPrefix(lines[2], "data: ") {
+		t.Errorf("unexpected sse frame %q", lines)
+	}
+}
@@ -0,0 +1,243 @@
+package eventsubws
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	helix "github.com/nicklaw5/helix/v2"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	DefaultURL = "wss://eventsub.wss.twitch.tv/ws"
+
+	welcomeTimeout = 10 * time.Second
+	maxBackoff     = 2 * time.Minute
+	seenMessages   = 200
+)
+
+type welcomeCallback func(sessionID string) error
+type notificationCallback func(messageID string, timestamp string, sub helix.EventSubSubscription, event json.RawMessage)
+type revocationCallback func(sub helix.EventSubSubscription)
+
+type metadata struct {
+	MessageID        string `json:"message_id"`
+	MessageType      string `json:"message_type"`
+	MessageTimestamp string `json:"message_timestamp"`
+}
+
+type message struct {
+	Metadata metadata        `json:"metadata"`
+	Payload  json.RawMessage `json:"payload"`
+}
+
+type session struct {
+	ID                      string `json:"id"`
+	Status                  string `json:"status"`
+	KeepaliveTimeoutSeconds int    `json:"keepalive_timeout_seconds"`
+	ReconnectURL            string `json:"reconnect_url"`
+}
+
+type sessionPayload struct {
+	Session session `json:"session"`
+}
+
+type notificationPayload struct {
+	Subscription helix.EventSubSubscription `json:"subscription"`
+	Event        json.RawMessage            `json:"event"`
+}
+
+// Client keeps a connection to the EventSub WebSocket server open, following
+// reconnect requests and starting a fresh session whenever the connection is lost.
+type Client struct {
+	URL string
+
+	// OnWelcome is called for every new session, subscriptions need to be (re)created against the session id.
+	// It is not called when twitch moves us to a new server with session_reconnect, those keep their subscriptions.
+	OnWelcome      welcomeCallback
+	OnNotification notificationCallback
+	OnRevocation   revocationCallback
+
+	dialer         *websocket.Dialer
+	keepaliveGrace time.Duration
+
+	mu   sync.Mutex
+	conn *websocket.Conn
+	seen []string
+}
+
+func New(URL string) *Client {
+	if len(URL) == 0 {
+		URL = DefaultURL
+	}
+
+	return &Client{
+		URL:            URL,
+		dialer:         websocket.DefaultDialer,
+		keepaliveGrace: 5 * time.Second,
+	}
+}
+
+// Run connects and keeps reconnecting until ctx is cancelled.
+func (c *Client) Run(ctx context.Context) error {
+	go func() {
+		<-ctx.Done()
+		c.closeConn()
+	}()
+
+	backoff := time.Second
+	for {
+		started := time.Now()
+		err := c.runSession(ctx)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		// a session that lived for a while was healthy, don't punish it for dropping
+		if time.Since(started) > maxBackoff {
+			backoff = time.Second
+		}
+		log.WithError(err).Warnf("eventsub websocket disconnected, reconnecting in %s", backoff)
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+		if backoff > maxBackoff {
+			backoff = maxBackoff
+		}
+	}
+}
+
+func (c *Client) setConn(conn *websocket.Conn) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.conn = conn
+}
+
+func (c *Client) closeConn() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.conn != nil {
+		c.conn.Close()
+	}
+}
+
+// connect dials URL and waits for the session_welcome message.
+func (c *Client) connect(ctx context.Context, URL string) (*websocket.Conn, session, error) {
+	conn, _, err := c.dialer.DialContext(ctx, URL, nil)
+	if err != nil {
+		return nil, session{}, errors.Wrap(err, "unable to connect to eventsub websocket")
+	}
+
+	_ = conn.SetReadDeadline(time.Now().Add(welcomeTimeout))
+	var msg message
+	if err := conn.ReadJSON(&msg); err != nil {
+		conn.Close()
+		return nil, session{}, errors.Wrap(err, "unable to read welcome message")
+	}
+	if msg.Metadata.MessageType != "session_welcome" {
+		conn.Close()
+		return nil, session{}, errors.Errorf("expected session_welcome but got %s", msg.Metadata.MessageType)
+	}
+
+	var payload sessionPayload
+	if err := json.Unmarshal(msg.Payload, &payload); err != nil {
+		conn.Close()
+		return nil, session{}, errors.Wrap(err, "unable to decode welcome message")
+	}
+	return conn, payload.Session, nil
+}
+
+func (c *Client) runSession(ctx context.Context) error {
+	conn, sess, err := c.connect(ctx, c.URL)
+	if err != nil {
+		return err
+	}
+	c.setConn(conn)
+	defer c.closeConn()
+
+	log.Infof("eventsub websocket session %s established", sess.ID)
+	if c.OnWelcome != nil {
+		if err := c.OnWelcome(sess.ID); err != nil {
+			return errors.Wrap(err, "unable to set up session")
+		}
+	}
+
+	keepalive := time.Duration(sess.KeepaliveTimeoutSeconds) * time.Second
+	for {
+		_ = conn.SetReadDeadline(time.Now().Add(keepalive + c.keepaliveGrace))
+
+		var msg message
+		if err := conn.ReadJSON(&msg); err != nil {
+			return errors.Wrap(err, "unable to read from eventsub websocket")
+		}
+
+		switch msg.Metadata.MessageType {
+		case "session_keepalive":
+			log.Debug("eventsub websocket keepalive")
+		case "notification":
+			if c.alreadySeen(msg.Metadata.MessageID) {
+				log.Debugf("duplicate eventsub message %s", msg.Metadata.MessageID)
+				continue
+			}
+			var payload notificationPayload
+			if err := json.Unmarshal(msg.Payload, &payload); err != nil {
+				log.WithError(err).Error("unable to decode eventsub notification")
+				continue
+			}
+			if c.OnNotification != nil {
+				c.OnNotification(msg.Metadata.MessageID, msg.Metadata.MessageTimestamp, payload.Subscription, payload.Event)
+			}
+		case "revocation":
+			var payload notificationPayload
+			if err := json.Unmarshal(msg.Payload, &payload); err != nil {
+				log.WithError(err).Error("unable to decode eventsub revocation")
+				continue
+			}
+			log.Warnf("eventsub subscription %s (%s) revoked: %s", payload.Subscription.ID, payload.Subscription.Type, payload.Subscription.Status)
+			if c.OnRevocation != nil {
+				c.OnRevocation(payload.Subscription)
+			}
+		case "session_reconnect":
+			var payload sessionPayload
+			if err := json.Unmarshal(msg.Payload, &payload); err != nil {
+				return errors.Wrap(err, "unable to decode reconnect message")
+			}
+			log.Infof("eventsub websocket asked to reconnect to %s", payload.Session.ReconnectURL)
+
+			// subscriptions move with the session, so keep the old connection until the new one is welcomed
+			newConn, newSess, err := c.connect(ctx, payload.Session.ReconnectURL)
+			if err != nil {
+				return err
+			}
+			conn.Close()
+			conn, sess = newConn, newSess
+			c.setConn(conn)
+			keepalive = time.Duration(sess.KeepaliveTimeoutSeconds) * time.Second
+			log.Infof("eventsub websocket session %s reconnected", sess.ID)
+		default:
+			log.Warnf("unknown eventsub websocket message type %s", msg.Metadata.MessageType)
+		}
+	}
+}
+
+// alreadySeen remembers recent message ids, twitch may deliver a message more than once.
+func (c *Client) alreadySeen(messageID string) bool {
+	for _, id := range c.seen {
+		if id == messageID {
+			return true
+		}
+	}
+	c.seen = append(c.seen, messageID)
+	if len(c.seen) > seenMessages {
+		c.seen = c.seen[len(c.seen)-seenMessages:]
+	}
+	return false
+}
@@ -0,0 +1,161 @@
+package eventsubws
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	helix "github.com/nicklaw5/helix/v2"
+)
+
+// fakeServer is a tiny stand in for twitch's EventSub websocket server. Each
+// connection gets a welcome and then runs the script registered for its path.
+type fakeServer struct {
+	t        *testing.T
+	srv      *httptest.Server
+	upgrader websocket.Upgrader
+
+	mu       sync.Mutex
+	sessions int
+	scripts  map[string]func(conn *websocket.Conn)
+}
+
+func newFakeServer(t *testing.T) *fakeServer {
+	fs := &fakeServer{t: t, scripts: map[string]func(conn *websocket.Conn){}}
+	fs.srv = httptest.NewServer(http.HandlerFunc(fs.handle))
+	t.Cleanup(fs.srv.Close)
+	return fs
+}
+
+func (fs *fakeServer) url(path string) string {
+	return "ws" + strings.TrimPrefix(fs.srv.URL, "http") + path
+}
+
+func (fs *fakeServer) handle(w http.ResponseWriter, r *http.Request) {
+	conn, err := fs.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		fs.t.Error(err)
+		return
+	}
+	defer conn.Close()
+
+	fs.mu.Lock()
+	fs.sessions++
+	id := fmt.Sprintf("session-%d", fs.sessions)
+	script := fs.scripts[r.URL.Path]
+	fs.mu.Unlock()
+
+	send(conn, "session_welcome", "", map[string]interface{}{
+		"session": map[string]interface{}{"id": id, "status": "connected", "keepalive_timeout_seconds": 1},
+	})
+	if script != nil {
+		script(conn)
+	}
+	// hold the connection open until the client goes away
+	for {
+		if _, _, err := conn.ReadMessage(); err != nil {
+			return
+		}
+	}
+}
+
+func send(conn *websocket.Conn, messageType string, messageID string, payload interface{}) {
+	_ = conn.WriteJSON(map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"message_id":        messageID,
+			"message_type":      messageType,
+			"message_timestamp": time.Now().Format(time.RFC3339Nano),
+		},
+		"payload": payload,
+	})
+}
+
+func notification(id string) map[string]interface{} {
+	return map[string]interface{}{
+		"subscription": map[string]interface{}{"id": "sub-" + id, "type": "stream.online", "version": "1"},
+		"event":        map[string]interface{}{"broadcaster_user_id": id},
+	}
+}
+
+func firstSession(fs *fakeServer, conn *websocket.Conn) {
+	send(conn, "session_keepalive", "k1", map[string]interface{}{})
+	send(conn, "notification", "m1", notification("1"))
+	send(conn, "notification", "m1", notification("1"))
+	send(conn, "revocation", "r1", map[string]interface{}{
+		"subscription": map[string]interface{}{"id": "sub-1", "type": "stream.online", "status": "authorization_revoked"},
+	})
+	send(conn, "session_reconnect", "c1", map[string]interface{}{
+		"session": map[string]interface{}{"id": "session-1", "status": "reconnecting", "reconnect_url": fs.url("/moved")},
+	})
+}
+
+func TestClient(t *testing.T) {
+	fs := newFakeServer(t)
+
+	var once sync.Once
+	fs.scripts["/ws"] = func(conn *websocket.Conn) {
+		once.Do(func() { firstSession(fs, conn) })
+	}
+	fs.scripts["/moved"] = func(conn *websocket.Conn) {
+		send(conn, "notification", "m2", notification("2"))
+		// then go quiet so the client hits its keepalive timeout and starts over on /ws
+	}
+
+	var mu sync.Mutex
+	var welcomed, notified, revoked []string
+	done := make(chan struct{})
+
+	c := New(fs.url("/ws"))
+	c.keepaliveGrace = 100 * time.Millisecond
+	c.OnWelcome = func(sessionID string) error {
+		mu.Lock()
+		defer mu.Unlock()
+		welcomed = append(welcomed, sessionID)
+		if len(welcomed) == 2 {
+			close(done)
+		}
+		return nil
+	}
+	c.OnNotification = func(messageID string, timestamp string, sub helix.EventSubSubscription, event json.RawMessage) {
+		mu.Lock()
+		defer mu.Unlock()
+		notified = append(notified, messageID+"/"+sub.ID)
+	}
+	c.OnRevocation = func(sub helix.EventSubSubscription) {
+		mu.Lock()
+		defer mu.Unlock()
+		revoked = append(revoked, sub.ID)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	go func() { _ = c.Run(ctx) }()
+
+	select {
+	case <-done:
+	case <-ctx.Done():
+		t.Fatal("timed out waiting for the client to start a new session")
+	}
+	cancel()
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	// session-2 is the reconnect and must not trigger OnWelcome, session-3 is the fresh start after the timeout
+	if strings.Join(welcomed, ",") != "session-1,session-3" {
+		t.Errorf("welcomed = %v; want [session-1 session-3]", welcomed)
+	}
+	if strings.Join(notified, ",") != "m1/sub-1,m2/sub-2" {
+		t.Errorf("notified = %v; want [m1/sub-1 m2/sub-2]", notified)
+	}
+	if strings.Join(revoked, ",") != "sub-1" {
+		t.Errorf("revoked = %v; want [sub-1]", revoked)
+	}
+}
@@ -0,0 +1,177 @@
+package poller
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	helix "github.com/nicklaw5/helix/v2"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+// BatchSize is the most user ids GetStreams accepts in one request.
+const BatchSize = 100
+
+type streamCallback func(stream helix.Stream)
+
+// Poller watches the roster with GetStreams and reports online/offline transitions.
+// A different stream id for someone who is already live counts as a new stream.
+type Poller struct {
+	client   *helix.Client
+	interval time.Duration
+
+	OnOnline  streamCallback
+	OnOffline streamCallback
+
+	mu      sync.Mutex
+	userIDs []string
+	live    map[string]helix.Stream
+	// ids that haven't been polled yet, their current state is recorded without announcing anything
+	unprimed map[string]bool
+}
+
+func New(client *helix.Client, interval time.Duration) *Poller {
+	return &Poller{
+		client:   client,
+		interval: interval,
+		live:     map[string]helix.Stream{},
+		unprimed: map[string]bool{},
+	}
+}
+
+// SetUserIDs replaces the roster being polled.
+func (p *Poller) SetUserIDs(userIDs []string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	wanted := map[string]bool{}
+	for _, id := range userIDs {
+		wanted[id] = true
+		if !p.isKnown(id) {
+			p.unprimed[id] = true
+		}
+	}
+	for id := range p.live {
+		if !wanted[id] {
+			delete(p.live, id)
+		}
+	}
+	for id := range p.unprimed {
+		if !wanted[id] {
+			delete(p.unprimed, id)
+		}
+	}
+
+	p.userIDs = userIDs
+}
+
+func (p *Poller) isKnown(id string) bool {
+	for _, known := range p.userIDs {
+		if known == id {
+			return true
+		}
+	}
+	return false
+}
+
+// Run polls every interval until ctx is cancelled.
+func (p *Poller) Run(ctx context.Context) {
+	log.Infof("Polling twitch for live streams every %s", p.interval)
+
+	ticker := time.NewTicker(p.interval)
+	defer ticker.Stop()
+
+	for {
+		if err := p.Poll(); err != nil {
+			log.Error(errors.Wrap(err, "unable to poll streams"))
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Poll does a single round of GetStreams and fires the callbacks for anything that changed.
+func (p *Poller) Poll() error {
+	p.mu.Lock()
+	userIDs := append([]string{}, p.userIDs...)
+	p.mu.Unlock()
+
+	current, err := FetchLive(p.client, userIDs)
+	if err != nil {
+		return err
+	}
+
+	p.mu.Lock()
+	var online, offline []helix.Stream
+	for _, id := range userIDs {
+		stream, isLive := current[id]
+		previous, wasLive := p.live[id]
+
+		if isLive {
+			p.live[id] = stream
+		} else {
+			delete(p.live, id)
+		}
+
+		if p.unprimed[id] {
+			delete(p.unprimed, id)
+			continue
+		}
+
+		if isLive && (!wasLive || previous.ID != stream.ID) {
+			if wasLive {
+				offline = append(offline, previous)
+			}
+			online = append(online, stream)
+		} else if !isLive && wasLive {
+			offline = append(offline, previous)
+		}
+	}
+	p.mu.Unlock()
+
+	for _, stream := range offline {
+		log.Infof("poller noticed %s went offline", stream.UserLogin)
+		if p.OnOffline != nil {
+			p.OnOffline(stream)
+		}
+	}
+	for _, stream := range online {
+		log.Infof("poller noticed %s went live (stream %s)", stream.UserLogin, stream.ID)
+		if p.OnOnline != nil {
+			p.OnOnline(stream)
+		}
+	}
+
+	return nil
+}
+
+// FetchLive returns the live streams for userIDs keyed by user id, asking twitch BatchSize users at a time.
+func FetchLive(client *helix.Client, userIDs []string) (map[string]helix.Stream, error) {
+	live := map[string]helix.Stream{}
+
+	for start := 0; start < len(userIDs); start += BatchSize {
+		end := start + BatchSize
+		if end > len(userIDs) {
+			end = len(userIDs)
+		}
+
+		resp, err := client.GetStreams(&helix.StreamsParams{UserIDs: userIDs[start:end], First: BatchSize})
+		if err != nil {
+			return nil, errors.Wrap(err, "unable to get streams")
+		}
+		if resp.ErrorStatus != 0 {
+			return nil, errors.Errorf("error fetching streams status=%d %s error=%s", resp.ErrorStatus, resp.Error, resp.ErrorMessage)
+		}
+
+		for _, stream := range resp.Data.Streams {
+			live[stream.UserID] = stream
+		}
+	}
+
+	return live, nil
+}
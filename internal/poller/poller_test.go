@@ -0,0 +1,87 @@
+package poller
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	helix "github.com/nicklaw5/helix/v2"
+)
+
+// fakeStreams answers GetStreams with whatever is currently in live, and records the batch sizes it saw.
+type fakeStreams struct {
+	mu      sync.Mutex
+	live    map[string]string // user id => stream id
+	batches []int
+}
+
+func (f *fakeStreams) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	ids := r.URL.Query()["user_id"]
+	f.batches = append(f.batches, len(ids))
+
+	streams := []helix.Stream{}
+	for _, id := range ids {
+		if streamID, ok := f.live[id]; ok {
+			streams = append(streams, helix.Stream{ID: streamID, UserID: id, UserLogin: "user" + id})
+		}
+	}
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{"data": streams})
+}
+
+func TestPoll(t *testing.T) {
+	fake := &fakeStreams{live: map[string]string{"1": "a"}}
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+
+	client, err := helix.NewClient(&helix.Options{ClientID: "id", APIBaseURL: srv.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var events []string
+	p := New(client, 0)
+	p.OnOnline = func(stream helix.Stream) { events = append(events, "online:"+stream.UserID+":"+stream.ID) }
+	p.OnOffline = func(stream helix.Stream) { events = append(events, "offline:"+stream.UserID+":"+stream.ID) }
+
+	// 150 users, so the roster has to be split over two requests
+	ids := []string{}
+	for i := 1; i <= 150; i++ {
+		ids = append(ids, strconv.Itoa(i))
+	}
+	p.SetUserIDs(ids)
+
+	var steps = []struct {
+		live map[string]string
+		want string
+	}{
+		// first poll only primes, 1 was already live before we started
+		{map[string]string{"1": "a"}, ""},
+		{map[string]string{"1": "a", "2": "b"}, "online:2:b"},
+		{map[string]string{"1": "c", "2": "b"}, "offline:1:a,online:1:c"},
+		{map[string]string{"1": "c"}, "offline:2:b"},
+	}
+	for _, step := range steps {
+		fake.mu.Lock()
+		fake.live = step.live
+		fake.mu.Unlock()
+
+		events = nil
+		if err := p.Poll(); err != nil {
+			t.Fatal(err)
+		}
+		if got := strings.Join(events, ","); got != step.want {
+			t.Errorf("Poll() with %v = %q; want %q", step.live, got, step.want)
+		}
+	}
+
+	if fake.batches[0] != 100 || fake.batches[1] != 50 {
+		t.Errorf("batches = %v; want 100 then 50", fake.batches[:2])
+	}
+}
@@ -0,0 +1,117 @@
+package roster
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+// reloadDelay lets an editor finish writing before the file is read again
+const reloadDelay = 250 * time.Millisecond
+
+// File is a roster kept in a local yaml, json or csv file, picked by the extension.
+//
+//	members:
+//	  - login: halkeye
+//	    display_name: Halkeye
+//	    discord_role: "1234"
+//	    tags: [art]
+//	  - login: someone_else
+//	    enabled: false
+type File struct {
+	path string
+
+	mu   sync.Mutex
+	last []Member
+}
+
+func NewFile(path string) *File {
+	return &File{path: path}
+}
+
+// Members reads the file.
+func (f *File) Members() ([]Member, error) {
+	members, err := f.read()
+	if err != nil {
+		return nil, err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.last = members
+	return members, nil
+}
+
+func (f *File) read() ([]Member, error) {
+	data, err := os.ReadFile(f.path)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to read roster file")
+	}
+
+	format := "json"
+	switch strings.ToLower(filepath.Ext(f.path)) {
+	case ".yaml", ".yml":
+		format = "yaml"
+	case ".csv":
+		format = "csv"
+	}
+	members, err := parseMembers(data, format)
+	return members, errors.Wrapf(err, "unable to parse roster file %s", f.path)
+}
+
+// Watch reloads the file whenever it changes on disk. A file that fails to parse is logged
+// and otherwise ignored, the roster stays as it was until the file is fixed.
+func (f *File) Watch(ctx context.Context, onChange ChangeFunc) error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return errors.Wrap(err, "unable to watch roster file")
+	}
+	defer watcher.Close()
+
+	// editors tend to replace the file rather than write to it, so watch the directory it is in
+	if err := watcher.Add(filepath.Dir(f.path)); err != nil {
+		return errors.Wrap(err, "unable to watch roster file")
+	}
+
+	reload := time.NewTimer(reloadDelay)
+	reload.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case event := <-watcher.Events:
+			if filepath.Clean(event.Name) == filepath.Clean(f.path) && !event.Has(fsnotify.Chmod) {
+				reload.Reset(reloadDelay)
+			}
+		case err := <-watcher.Errors:
+			log.Error(errors.Wrap(err, "roster file watcher"))
+		case <-reload.C:
+			f.reload(onChange)
+		}
+	}
+}
+
+func (f *File) reload(onChange ChangeFunc) {
+	members, err := f.read()
+	if err != nil {
+		log.Error(err)
+		return
+	}
+
+	f.mu.Lock()
+	changes := Diff(f.last, members)
+	f.last = members
+	f.mu.Unlock()
+
+	log.WithField("changes", len(changes)).Infof("reloaded roster from %s", f.path)
+	if len(changes) != 0 {
+		onChange(changes, members)
+	}
+}
@@ -0,0 +1,64 @@
+package roster
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "roster.yaml")
+	if err := os.WriteFile(path, []byte("members:\n  - login: https://twitch.tv/Halkeye\n    tags: [art]\n  - login: off\n    enabled: false\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	f := NewFile(path)
+	members, err := f.Members()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(members) != 2 || members[0].TwitchLogin != "halkeye" || !members[0].Enabled || members[1].Enabled {
+		t.Fatalf("Members() = %+v", members)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	changed := make(chan []Change, 1)
+	go func() {
+		_ = f.Watch(ctx, func(changes []Change, _ []Member) { changed <- changes })
+	}()
+	// give the watcher a moment to start
+	time.Sleep(100 * time.Millisecond)
+
+	if err := os.WriteFile(path, []byte("members:\n  - login: halkeye\n    tags: [art]\n  - login: new\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case changes := <-changed:
+		// off was disabled, so dropping it leaves nothing to unsubscribe
+		added, removed := LoginChanges(changes)
+		if len(changes) != 2 || len(added) != 1 || added[0] != "new" || len(removed) != 0 {
+			t.Errorf("changes = %+v", changes)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("roster file change was never picked up")
+	}
+}
+
+func TestFileJSON(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "roster.json")
+	if err := os.WriteFile(path, []byte(`{"members":[{"login":"@foo","discord_role":"1"}]}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	members, err := NewFile(path).Members()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(members) != 1 || members[0].TwitchLogin != "foo" || members[0].DiscordRole != "1" {
+		t.Errorf("Members() = %+v", members)
+	}
+}
@@ -0,0 +1,147 @@
+package roster
+
+import (
+	"context"
+	"strings"
+	"sync"
+)
+
+type MergeMode string
+
+const (
+	// Union is everyone in any of the sources
+	Union MergeMode = "union"
+	// Intersection is only the people in every source
+	Intersection MergeMode = "intersection"
+)
+
+// Merged combines several sources into one roster, matching members up by login. When someone is in
+// more than one source the first source's entry wins, so put the one with the announcement settings first.
+type Merged struct {
+	mode    MergeMode
+	sources []Source
+
+	mu sync.Mutex
+	// latest roster from each source
+	latest [][]Member
+	last   []Member
+}
+
+func Merge(mode MergeMode, sources ...Source) *Merged {
+	return &Merged{
+		mode:    mode,
+		sources: sources,
+		latest:  make([][]Member, len(sources)),
+	}
+}
+
+// Members fetches every source and combines them.
+func (m *Merged) Members() ([]Member, error) {
+	latest := make([][]Member, len(m.sources))
+	for i, source := range m.sources {
+		members, err := source.Members()
+		if err != nil {
+			return nil, err
+		}
+		latest[i] = members
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.latest = latest
+	m.last = m.combine()
+	return m.last, nil
+}
+
+// Watch watches every source, and calls onChange when a change in one of them changes the combined roster.
+func (m *Merged) Watch(ctx context.Context, onChange ChangeFunc) error {
+	var wg sync.WaitGroup
+	errs := make(chan error, len(m.sources))
+
+	for i, source := range m.sources {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs <- source.Watch(ctx, func(_ []Change, members []Member) {
+				m.mu.Lock()
+				m.latest[i] = members
+				combined := m.combine()
+				// whose entry wins can change, which changes the RecordID but not the member
+				changes := diffByLogin(m.last, combined)
+				m.last = combined
+				m.mu.Unlock()
+
+				if len(changes) != 0 {
+					onChange(changes, combined)
+				}
+			})
+		}()
+	}
+
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SetStatuses hands each source that can take them the results for its own members.
+func (m *Merged) SetStatuses(results []StatusResult) error {
+	m.mu.Lock()
+	latest := m.latest
+	m.mu.Unlock()
+
+	for i, source := range m.sources {
+		writer, ok := source.(StatusWriter)
+		if !ok {
+			continue
+		}
+
+		owned := map[string]bool{}
+		for _, member := range latest[i] {
+			owned[member.RecordID] = true
+		}
+		mine := []StatusResult{}
+		for _, result := range results {
+			if owned[result.Member.RecordID] {
+				mine = append(mine, result)
+			}
+		}
+		if err := writer.SetStatuses(mine); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *Merged) combine() []Member {
+	// lowercased login => how many sources have them
+	seen := map[string]int{}
+	for _, members := range m.latest {
+		inSource := map[string]bool{}
+		for _, member := range members {
+			login := strings.ToLower(member.TwitchLogin)
+			if !inSource[login] {
+				inSource[login] = true
+				seen[login]++
+			}
+		}
+	}
+
+	combined := []Member{}
+	added := map[string]bool{}
+	for _, members := range m.latest {
+		for _, member := range members {
+			login := strings.ToLower(member.TwitchLogin)
+			if added[login] || (m.mode == Intersection && seen[login] != len(m.sources)) {
+				continue
+			}
+			added[login] = true
+			combined = append(combined, member)
+		}
+	}
+	return combined
+}
@@ -0,0 +1,198 @@
+package roster
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"strings"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v3"
+)
+
+type fileRoster struct {
+	Members []fileMember `json:"members" yaml:"members"`
+}
+
+type fileMember struct {
+	// ID defaults to the login, set it to keep someone's settings across a rename
+	ID          string   `json:"id" yaml:"id"`
+	Login       string   `json:"login" yaml:"login"`
+	DisplayName string   `json:"display_name" yaml:"display_name"`
+	Enabled     *bool    `json:"enabled" yaml:"enabled"`
+	Message     string   `json:"message" yaml:"message"`
+	DiscordRole string   `json:"discord_role" yaml:"discord_role"`
+	Channel     string   `json:"channel" yaml:"channel"`
+	Tags        []string `json:"tags" yaml:"tags"`
+	TwitchID    string   `json:"twitch_id" yaml:"twitch_id"`
+	GameChanges bool     `json:"game_changes" yaml:"game_changes"`
+	Shoutouts   bool     `json:"shoutouts" yaml:"shoutouts"`
+}
+
+// loginHeaders are the csv headers taken to mean the twitch login, anything else and the first column is used
+var loginHeaders = map[string]bool{"login": true, "twitch_login": true, "twitch login": true, "twitch account": true, "twitch": true}
+
+// csvColumns are the other csv headers we read, spaces in a header count as underscores
+var csvColumns = map[string]bool{
+	"id": true, "display_name": true, "enabled": true, "message": true, "discord_role": true, "channel": true,
+	"tags": true, "twitch_id": true, "game_changes": true, "shoutouts": true,
+}
+
+// parseMembers reads a roster in format, which is yaml, json or csv. Besides the yaml/json layout File
+// documents, json can be a plain list of logins and csv is either a list of logins or has a header row
+// naming the same fields as the json does.
+func parseMembers(data []byte, format string) ([]Member, error) {
+	var entries []fileMember
+	var err error
+
+	switch format {
+	case "yaml":
+		var contents fileRoster
+		err = yaml.Unmarshal(data, &contents)
+		entries = contents.Members
+	case "csv":
+		entries, err = parseCSV(data)
+	default:
+		entries, err = parseJSON(data)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	members := []Member{}
+	for i, entry := range entries {
+		login := NormalizeLogin(entry.Login)
+		if len(login) == 0 {
+			log.Warnf("roster entry %d has no login", i)
+			continue
+		}
+		id := entry.ID
+		if len(id) == 0 {
+			id = login
+		}
+
+		members = append(members, Member{
+			RecordID:    id,
+			TwitchLogin: login,
+			DisplayName: entry.DisplayName,
+			Enabled:     entry.Enabled == nil || *entry.Enabled,
+			Message:     entry.Message,
+			DiscordRole: entry.DiscordRole,
+			Channel:     entry.Channel,
+			Tags:        append([]string{}, entry.Tags...),
+			ResolvedID:  entry.TwitchID,
+			GameChanges: entry.GameChanges,
+			Shoutouts:   entry.Shoutouts,
+		})
+	}
+	return members, nil
+}
+
+func parseJSON(data []byte) ([]fileMember, error) {
+	trimmed := bytes.TrimSpace(data)
+	if !bytes.HasPrefix(trimmed, []byte("[")) {
+		var contents fileRoster
+		decoder := json.NewDecoder(bytes.NewReader(trimmed))
+		decoder.DisallowUnknownFields()
+		err := decoder.Decode(&contents)
+		return contents.Members, err
+	}
+
+	var logins []string
+	if err := json.Unmarshal(trimmed, &logins); err == nil {
+		entries := []fileMember{}
+		for _, login := range logins {
+			entries = append(entries, fileMember{Login: login})
+		}
+		return entries, nil
+	}
+
+	var entries []fileMember
+	err := json.Unmarshal(trimmed, &entries)
+	return entries, err
+}
+
+func parseCSV(data []byte) ([]fileMember, error) {
+	reader := csv.NewReader(bytes.NewReader(data))
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+	rows, err := reader.ReadAll()
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid csv")
+	}
+	if len(rows) == 0 {
+		return []fileMember{}, nil
+	}
+
+	// column name => index, without a header row there's just the login
+	columns := map[string]int{"login": 0}
+	header := false
+	for i, name := range rows[0] {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if loginHeaders[name] {
+			columns["login"] = i
+			header = true
+		} else {
+			columns[strings.ReplaceAll(name, " ", "_")] = i
+		}
+	}
+	if header {
+		rows = rows[1:]
+	} else if looksLikeHeader(rows[0]) {
+		// reading it as a list of logins would announce nobody and subscribe to "streamer"
+		return nil, errors.Errorf("csv header %q has no login column, name it one of login, twitch_login, twitch login, twitch account or twitch", strings.Join(rows[0], ","))
+	}
+
+	field := func(row []string, name string) string {
+		i, ok := columns[name]
+		if !ok || (!header && name != "login") || i >= len(row) {
+			return ""
+		}
+		return strings.TrimSpace(row[i])
+	}
+
+	entries := []fileMember{}
+	for _, row := range rows {
+		entry := fileMember{
+			ID:          field(row, "id"),
+			Login:       field(row, "login"),
+			DisplayName: field(row, "display_name"),
+			Message:     field(row, "message"),
+			DiscordRole: field(row, "discord_role"),
+			Channel:     field(row, "channel"),
+			TwitchID:    field(row, "twitch_id"),
+		}
+		for _, tag := range strings.Split(field(row, "tags"), ",") {
+			if tag = strings.TrimSpace(tag); len(tag) != 0 {
+				entry.Tags = append(entry.Tags, tag)
+			}
+		}
+		if enabled := strings.ToLower(field(row, "enabled")); len(enabled) != 0 {
+			on := truthy(enabled)
+			entry.Enabled = &on
+		}
+		entry.GameChanges = truthy(strings.ToLower(field(row, "game_changes")))
+		entry.Shoutouts = truthy(strings.ToLower(field(row, "shoutouts")))
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+// truthy is how a spreadsheet says yes
+func truthy(value string) bool {
+	return value == "true" || value == "yes" || value == "1" || value == "x"
+}
+
+// looksLikeHeader reports whether a csv row names columns rather than listing a streamer, either it names
+// one of the columns we read or its first cell couldn't be a login.
+func looksLikeHeader(row []string) bool {
+	if len(row) > 1 {
+		for _, name := range row {
+			if csvColumns[strings.ReplaceAll(strings.ToLower(strings.TrimSpace(name)), " ", "_")] {
+				return true
+			}
+		}
+	}
+	return !ValidLogin(NormalizeLogin(row[0]))
+}
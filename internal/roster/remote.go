@@ -0,0 +1,150 @@
+package roster
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"mime"
+	"net/http"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+// Remote is a roster published at a url, json or csv (a google sheets csv export works). It is polled
+// with If-None-Match/If-Modified-Since so an unchanged roster costs the server next to nothing.
+type Remote struct {
+	url      string
+	interval time.Duration
+	client   *http.Client
+
+	mu           sync.Mutex
+	etag         string
+	lastModified string
+	body         []byte
+	last         []Member
+}
+
+func NewRemote(url string, interval time.Duration) *Remote {
+	return &Remote{
+		url:      url,
+		interval: interval,
+		client:   &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+// Members fetches the roster.
+func (r *Remote) Members() ([]Member, error) {
+	members, _, err := r.fetch()
+	return members, err
+}
+
+// fetch gets the roster if it changed since last time, changed is false when it didn't.
+func (r *Remote) fetch() (members []Member, changed bool, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	req, err := http.NewRequest(http.MethodGet, r.url, nil)
+	if err != nil {
+		return nil, false, errors.Wrap(err, "unable to create roster request")
+	}
+	if r.body != nil {
+		if len(r.etag) != 0 {
+			req.Header.Set("If-None-Match", r.etag)
+		}
+		if len(r.lastModified) != 0 {
+			req.Header.Set("If-Modified-Since", r.lastModified)
+		}
+	}
+
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return nil, false, errors.Wrap(err, "unable to fetch roster")
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotModified {
+		return r.last, false, nil
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return nil, false, errors.Errorf("unable to fetch roster: %s", resp.Status)
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, false, errors.Wrap(err, "unable to read roster")
+	}
+	r.etag = resp.Header.Get("ETag")
+	r.lastModified = resp.Header.Get("Last-Modified")
+	// plenty of servers don't bother with either header, so compare the content too
+	if r.body != nil && bytes.Equal(body, r.body) {
+		return r.last, false, nil
+	}
+
+	members, err = parseMembers(body, r.format(resp.Header.Get("Content-Type"), body))
+	if err != nil {
+		return nil, false, errors.Wrapf(err, "unable to parse roster from %s", r.url)
+	}
+	r.body = body
+	r.last = members
+	return members, true, nil
+}
+
+// format works out json or csv from the content type, the url, and as a last resort the content.
+func (r *Remote) format(contentType string, body []byte) string {
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	switch {
+	case strings.HasSuffix(mediaType, "json"):
+		return "json"
+	case mediaType == "text/csv":
+		return "csv"
+	case strings.HasSuffix(strings.ToLower(path.Ext(strings.SplitN(r.url, "?", 2)[0])), ".json"):
+		return "json"
+	case strings.HasSuffix(strings.ToLower(path.Ext(strings.SplitN(r.url, "?", 2)[0])), ".csv"):
+		return "csv"
+	}
+
+	trimmed := bytes.TrimSpace(body)
+	if bytes.HasPrefix(trimmed, []byte("{")) || bytes.HasPrefix(trimmed, []byte("[")) {
+		return "json"
+	}
+	return "csv"
+}
+
+// Watch polls the url every interval until ctx is done.
+func (r *Remote) Watch(ctx context.Context, onChange ChangeFunc) error {
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+
+	r.mu.Lock()
+	previous := r.last
+	r.mu.Unlock()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+
+		members, changed, err := r.fetch()
+		if err != nil {
+			log.Error(err)
+			continue
+		}
+		if !changed {
+			continue
+		}
+
+		changes := Diff(previous, members)
+		previous = members
+		if len(changes) != 0 {
+			log.WithField("changes", len(changes)).Infof("roster at %s changed", r.url)
+			onChange(changes, members)
+		}
+	}
+}
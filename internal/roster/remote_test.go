@@ -0,0 +1,98 @@
+package roster
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestRemote(t *testing.T) {
+	var mu sync.Mutex
+	body := "Twitch Account,Discord Role,Enabled\nhttps://twitch.tv/halkeye,1234,yes\noff,,no\n"
+	etag := `"v1"`
+	notModified := 0
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		if r.Header.Get("If-None-Match") == etag {
+			notModified++
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("ETag", etag)
+		w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+		_, _ = w.Write([]byte(body))
+	}))
+	defer srv.Close()
+
+	remote := NewRemote(srv.URL+"/export?format=csv", 10*time.Millisecond)
+	members, err := remote.Members()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(members) != 2 || members[0].TwitchLogin != "halkeye" || members[0].DiscordRole != "1234" || !members[0].Enabled || members[1].Enabled {
+		t.Fatalf("Members() = %+v", members)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	changed := make(chan []Change, 1)
+	go func() {
+		_ = remote.Watch(ctx, func(changes []Change, _ []Member) { changed <- changes })
+	}()
+	time.Sleep(50 * time.Millisecond)
+
+	mu.Lock()
+	if notModified == 0 {
+		t.Errorf("unchanged roster was fetched without If-None-Match")
+	}
+	body = "Twitch Account,Discord Role,Enabled\nhalkeye,1234,yes\nnew,,yes\n"
+	etag = `"v2"`
+	mu.Unlock()
+
+	select {
+	case changes := <-changed:
+		if added, _ := LoginChanges(changes); strings.Join(added, ",") != "new" {
+			t.Errorf("changes = %+v", changes)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("roster change was never picked up")
+	}
+}
+
+func TestParseMembers(t *testing.T) {
+	members, err := parseMembers([]byte(`["Foo", "@bar"]`), "json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if logins := Logins(members); strings.Join(logins, ",") != "foo,bar" {
+		t.Errorf("json list = %v", logins)
+	}
+
+	members, err = parseMembers([]byte("foo\nbar\n"), "csv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if logins := Logins(members); strings.Join(logins, ",") != "foo,bar" {
+		t.Errorf("csv without a header = %v", logins)
+	}
+
+	members, err = parseMembers([]byte("Twitch Login,Game Changes,Shoutouts\nfoo,yes,\nbar,,true\n"), "csv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(members) != 2 || !members[0].GameChanges || members[1].GameChanges || members[0].Shoutouts || !members[1].Shoutouts {
+		t.Errorf("csv with a header = %+v; want foo announcing game changes and bar shouting out raiders", members)
+	}
+
+	for _, data := range []string{"Streamer,Discord Role\nfoo,123\n", "Streamer Name\nfoo\n"} {
+		if members, err := parseMembers([]byte(data), "csv"); err == nil {
+			t.Errorf("csv %q = %+v; want an error about the missing login column", data, Logins(members))
+		}
+	}
+}
@@ -0,0 +1,227 @@
+package roster
+
+import (
+	"context"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Member is one streamer on the roster along with how their announcements should look.
+type Member struct {
+	// RecordID identifies the member within their source
+	RecordID    string
+	TwitchLogin string
+	DisplayName string
+	Enabled     bool
+	// Message overrides the go live message template
+	Message string
+	// DiscordRole is the id of the role to ping
+	DiscordRole string
+	// Channel is the discord webhook to announce to instead of the default one
+	Channel string
+	Tags    []string
+	// GameChanges opts in to announcing the streamer switching games while live
+	GameChanges bool
+	// Shoutouts opts in to the bot shouting out roster members who raid the streamer
+	Shoutouts bool
+	// Status is what roster validation last said about the login
+	Status string
+	// Source is set by sources that write back to their rows, so they can tell their own members apart in a merged roster
+	Source string
+
+	StreamCount int
+	// ResolvedID is the twitch user id the login last resolved to
+	ResolvedID string
+}
+
+type ChangeKind string
+
+const (
+	MemberAdded   ChangeKind = "added"
+	MemberRemoved ChangeKind = "removed"
+	MemberChanged ChangeKind = "changed"
+)
+
+// Change is something that happened to a single member of the roster.
+type Change struct {
+	Kind     ChangeKind
+	Member   Member
+	Previous Member
+}
+
+// ChangeFunc gets what changed in the roster along with the whole roster as it is now.
+type ChangeFunc func(changes []Change, members []Member)
+
+// Source is somewhere the roster is kept.
+type Source interface {
+	// Members returns everyone on the roster.
+	Members() ([]Member, error)
+	// Watch calls onChange whenever the roster changes until ctx is done. Sources that get told
+	// about changes, rather than going looking for them, hold on to onChange and return straight away.
+	Watch(ctx context.Context, onChange ChangeFunc) error
+}
+
+// StatusWriter is a Source that can record what roster validation found.
+type StatusWriter interface {
+	SetStatuses(results []StatusResult) error
+}
+
+// what roster validation says about a login
+const (
+	StatusOK       = "ok"
+	StatusNotFound = "not found"
+	StatusBanned   = "banned/suspended"
+)
+
+// StatusResult is the outcome of looking a member up on twitch.
+type StatusResult struct {
+	Member Member
+	Status string
+	// UserID is the twitch user id the login resolved to, if it did
+	UserID string
+	// Login is set when the member was found by UserID under a different login, they renamed
+	Login string
+}
+
+// Logins returns the twitch logins of the enabled members.
+func Logins(members []Member) []string {
+	logins := []string{}
+	for _, member := range members {
+		if member.Enabled {
+			logins = append(logins, member.TwitchLogin)
+		}
+	}
+	return logins
+}
+
+// UserIDs returns the twitch user ids of the enabled members whose login resolved, validation fills in ResolvedID.
+func UserIDs(members []Member) []string {
+	ids := []string{}
+	seen := map[string]bool{}
+	for _, member := range members {
+		if !member.Enabled || len(member.ResolvedID) == 0 || seen[member.ResolvedID] {
+			continue
+		}
+		if member.Status == StatusNotFound || member.Status == StatusBanned {
+			continue
+		}
+		seen[member.ResolvedID] = true
+		ids = append(ids, member.ResolvedID)
+	}
+	return ids
+}
+
+// IDChanges compares two lists of user ids, what needs subscribing and unsubscribing. A rename keeps
+// its user id, so it is neither.
+func IDChanges(previous []string, current []string) (added []string, removed []string) {
+	added, removed = []string{}, []string{}
+
+	before := map[string]bool{}
+	for _, id := range previous {
+		before[id] = true
+	}
+	after := map[string]bool{}
+	for _, id := range current {
+		after[id] = true
+		if !before[id] {
+			added = append(added, id)
+		}
+	}
+	for _, id := range previous {
+		if !after[id] {
+			removed = append(removed, id)
+		}
+	}
+	return added, removed
+}
+
+// NormalizeLogin turns whatever got typed into the login column (channel urls, @mentions) into a twitch login.
+func NormalizeLogin(login string) string {
+	login = strings.ToLower(strings.TrimSpace(login))
+	for _, prefix := range []string{"https://", "http://", "www.", "m.", "twitch.tv/", "@"} {
+		login = strings.TrimPrefix(login, prefix)
+	}
+	if i := strings.IndexAny(login, "/?#"); i >= 0 {
+		login = login[:i]
+	}
+	return login
+}
+
+// validLogin is what twitch accepts as a login, anything else gets the whole GetUsers batch rejected.
+var validLogin = regexp.MustCompile(`^[a-z0-9_]{1,25}$`)
+
+// ValidLogin reports whether a normalized login could be a twitch account.
+func ValidLogin(login string) bool {
+	return validLogin.MatchString(login)
+}
+
+// Diff compares two copies of a roster by RecordID.
+func Diff(previous []Member, current []Member) []Change {
+	return diff(previous, current, func(member Member) string { return member.RecordID })
+}
+
+// diffByLogin compares two copies of a roster by lowercased login, for rosters where the same member
+// can turn up under a different RecordID.
+func diffByLogin(previous []Member, current []Member) []Change {
+	return diff(previous, current, func(member Member) string { return strings.ToLower(member.TwitchLogin) })
+}
+
+func diff(previous []Member, current []Member, key func(Member) string) []Change {
+	before := map[string]Member{}
+	for _, member := range previous {
+		before[key(member)] = member
+	}
+	after := map[string]Member{}
+	for _, member := range current {
+		after[key(member)] = member
+	}
+
+	ids := []string{}
+	for id := range before {
+		ids = append(ids, id)
+	}
+	for id := range after {
+		if _, ok := before[id]; !ok {
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+
+	changes := []Change{}
+	for _, id := range ids {
+		was, wasKnown := before[id]
+		is, isKnown := after[id]
+
+		switch {
+		case wasKnown && !isKnown:
+			changes = append(changes, Change{Kind: MemberRemoved, Previous: was})
+		case !wasKnown && isKnown:
+			changes = append(changes, Change{Kind: MemberAdded, Member: is})
+		case !reflect.DeepEqual(was, is):
+			changes = append(changes, Change{Kind: MemberChanged, Member: is, Previous: was})
+		}
+	}
+	return changes
+}
+
+// LoginChanges boils changes down to the twitch logins that need subscribing and unsubscribing.
+func LoginChanges(changes []Change) (added []string, removed []string) {
+	added, removed = []string{}, []string{}
+
+	for _, change := range changes {
+		was := change.Previous.Enabled && len(change.Previous.TwitchLogin) != 0
+		is := change.Member.Enabled && len(change.Member.TwitchLogin) != 0
+		sameLogin := strings.EqualFold(change.Previous.TwitchLogin, change.Member.TwitchLogin)
+
+		if was && (!is || !sameLogin) {
+			removed = append(removed, change.Previous.TwitchLogin)
+		}
+		if is && (!was || !sameLogin) {
+			added = append(added, change.Member.TwitchLogin)
+		}
+	}
+
+	return added, removed
+}
@@ -0,0 +1,185 @@
+package roster
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestLogins(t *testing.T) {
+	if logins := Logins([]Member{{TwitchLogin: "a", Enabled: true}, {TwitchLogin: "b"}}); strings.Join(logins, ",") != "a" {
+		t.Errorf("Logins() = %v; want [a]", logins)
+	}
+}
+
+func TestUserIDs(t *testing.T) {
+	ids := UserIDs([]Member{
+		{TwitchLogin: "a", ResolvedID: "1", Enabled: true, Status: StatusOK},
+		{TwitchLogin: "unchecked", ResolvedID: "2", Enabled: true},
+		{TwitchLogin: "twice", ResolvedID: "1", Enabled: true},
+		{TwitchLogin: "unresolved", Enabled: true},
+		{TwitchLogin: "off", ResolvedID: "3"},
+		{TwitchLogin: "suspended", ResolvedID: "4", Enabled: true, Status: StatusBanned},
+	})
+	if strings.Join(ids, ",") != "1,2" {
+		t.Errorf("UserIDs() = %v; want [1 2]", ids)
+	}
+}
+
+func TestIDChanges(t *testing.T) {
+	added, removed := IDChanges([]string{"1", "2", "3"}, []string{"3", "1", "4"})
+	if strings.Join(added, ",") != "4" || strings.Join(removed, ",") != "2" {
+		t.Errorf("IDChanges() = %v, %v; want [4] and [2]", added, removed)
+	}
+}
+
+func TestDiff(t *testing.T) {
+	previous := []Member{
+		{RecordID: "1", TwitchLogin: "same", Enabled: true},
+		{RecordID: "2", TwitchLogin: "gone", Enabled: true},
+		{RecordID: "3", TwitchLogin: "edited", Enabled: true},
+	}
+	current := []Member{
+		{RecordID: "1", TwitchLogin: "same", Enabled: true},
+		{RecordID: "3", TwitchLogin: "edited", Enabled: true, Message: "hi"},
+		{RecordID: "4", TwitchLogin: "new", Enabled: true},
+	}
+
+	kinds := []string{}
+	for _, change := range Diff(previous, current) {
+		kinds = append(kinds, string(change.Kind))
+	}
+	if strings.Join(kinds, ",") != "removed,changed,added" {
+		t.Errorf("Diff() = %v; want [removed changed added]", kinds)
+	}
+}
+
+func TestLoginChanges(t *testing.T) {
+	changes := []Change{
+		{Kind: MemberAdded, Member: Member{TwitchLogin: "new", Enabled: true}},
+		{Kind: MemberAdded, Member: Member{TwitchLogin: "disabled"}},
+		{Kind: MemberRemoved, Previous: Member{TwitchLogin: "gone", Enabled: true}},
+		{Kind: MemberChanged, Previous: Member{TwitchLogin: "old", Enabled: true}, Member: Member{TwitchLogin: "renamed", Enabled: true}},
+		{Kind: MemberChanged, Previous: Member{TwitchLogin: "same", Enabled: true}, Member: Member{TwitchLogin: "Same", Enabled: true, Message: "hi"}},
+		{Kind: MemberChanged, Previous: Member{TwitchLogin: "off", Enabled: true}, Member: Member{TwitchLogin: "off"}},
+	}
+
+	added, removed := LoginChanges(changes)
+	if strings.Join(added, ",") != "new,renamed" {
+		t.Errorf("added = %v; want [new renamed]", added)
+	}
+	if strings.Join(removed, ",") != "gone,old,off" {
+		t.Errorf("removed = %v; want [gone old off]", removed)
+	}
+}
+
+func TestNormalizeLogin(t *testing.T) {
+	for input, want := range map[string]string{
+		"halkeye":                          "halkeye",
+		" HalkEye ":                        "halkeye",
+		"@halkeye":                         "halkeye",
+		"https://www.twitch.tv/halkeye":    "halkeye",
+		"twitch.tv/halkeye/":               "halkeye",
+		"http://m.twitch.tv/Halkeye?foo=1": "halkeye",
+	} {
+		if got := NormalizeLogin(input); got != want {
+			t.Errorf("NormalizeLogin(%q) = %q; want %q", input, got, want)
+		}
+	}
+}
+
+func TestValidLogin(t *testing.T) {
+	for login, want := range map[string]bool{
+		"halkeye":                    true,
+		"under_score_99":             true,
+		"":                           false,
+		"halk eye":                   false,
+		"halkeye!":                   false,
+		"youtube.com":                false,
+		"abcdefghijklmnopqrstuvwxyz": false,
+	} {
+		if got := ValidLogin(login); got != want {
+			t.Errorf("ValidLogin(%q) = %v; want %v", login, got, want)
+		}
+	}
+}
+
+type staticSource []Member
+
+func (s staticSource) Members() ([]Member, error) { return s, nil }
+
+func (s staticSource) Watch(context.Context, ChangeFunc) error { return nil }
+
+func TestMerge(t *testing.T) {
+	airtable := staticSource{
+		{RecordID: "rec1", TwitchLogin: "both", Enabled: true, Message: "custom"},
+		{RecordID: "rec2", TwitchLogin: "airtable_only", Enabled: true},
+	}
+	team := staticSource{
+		{RecordID: "1", TwitchLogin: "Both", Enabled: true},
+		{RecordID: "3", TwitchLogin: "team_only", Enabled: true},
+	}
+
+	members, err := Merge(Union, airtable, team).Members()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if logins := Logins(members); strings.Join(logins, ",") != "both,airtable_only,team_only" {
+		t.Errorf("union = %v", logins)
+	}
+	if members[0].Message != "custom" {
+		t.Errorf("union should keep the first source's settings, got %+v", members[0])
+	}
+
+	members, err = Merge(Intersection, airtable, team).Members()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if logins := Logins(members); strings.Join(logins, ",") != "both" {
+		t.Errorf("intersection = %v", logins)
+	}
+}
+
+// watchedSource hands its ChangeFunc to the test, which plays the source changing.
+type watchedSource struct {
+	members  []Member
+	onChange chan ChangeFunc
+}
+
+func (s *watchedSource) Members() ([]Member, error) { return s.members, nil }
+
+func (s *watchedSource) Watch(_ context.Context, onChange ChangeFunc) error {
+	s.onChange <- onChange
+	return nil
+}
+
+func TestMergeWatch(t *testing.T) {
+	airtable := &watchedSource{
+		members:  []Member{{RecordID: "rec1", TwitchLogin: "both", Enabled: true, Message: "custom"}},
+		onChange: make(chan ChangeFunc, 1),
+	}
+	team := &watchedSource{
+		members:  []Member{{RecordID: "1", TwitchLogin: "both", Enabled: true}},
+		onChange: make(chan ChangeFunc, 1),
+	}
+	merged := Merge(Union, airtable, team)
+	if _, err := merged.Members(); err != nil {
+		t.Fatal(err)
+	}
+
+	var changes []Change
+	go func() {
+		_ = merged.Watch(context.Background(), func(c []Change, _ []Member) { changes = c })
+	}()
+	fromAirtable := <-airtable.onChange
+	<-team.onChange
+
+	// they left the airtable, but are still on the team
+	fromAirtable(nil, []Member{})
+	if len(changes) != 1 || changes[0].Kind != MemberChanged || changes[0].Member.RecordID != "1" {
+		t.Fatalf("changes = %+v; want one change, to the team's entry", changes)
+	}
+	if added, removed := LoginChanges(changes); len(added) != 0 || len(removed) != 0 {
+		t.Errorf("LoginChanges() = %v, %v; want nobody resubscribed", added, removed)
+	}
+}
@@ -0,0 +1,83 @@
+package schedule
+
+import (
+	"net/http"
+	"time"
+
+	helix "github.com/nicklaw5/helix/v2"
+	"github.com/pkg/errors"
+)
+
+// PageSize is the most segments GetSchedule returns in one request.
+const PageSize = 25
+
+// Segment is one upcoming stream on a broadcaster's twitch schedule.
+type Segment struct {
+	ID               string
+	BroadcasterID    string
+	BroadcasterLogin string
+	BroadcasterName  string
+	Title            string
+	Game             string
+	StartTime        time.Time
+	EndTime          time.Time
+}
+
+// Fetch returns broadcasterID's segments that start between from and until, soonest first.
+// Canceled segments and anything during a vacation are left out, and broadcasters without a schedule have no segments.
+func Fetch(client *helix.Client, broadcasterID string, from time.Time, until time.Time) ([]Segment, error) {
+	segments := []Segment{}
+	after := ""
+
+	for {
+		resp, err := client.GetSchedule(&helix.GetScheduleParams{
+			BroadcasterID: broadcasterID,
+			StartTime:     helix.Time{Time: from.UTC()},
+			First:         PageSize,
+			After:         after,
+		})
+		if err != nil {
+			return nil, errors.Wrap(err, "unable to get schedule")
+		}
+		if resp.ErrorStatus == http.StatusNotFound {
+			// they never set up a schedule
+			return segments, nil
+		}
+		if resp.ErrorStatus != 0 {
+			return nil, errors.Errorf("error fetching schedule status=%d %s error=%s", resp.ErrorStatus, resp.Error, resp.ErrorMessage)
+		}
+
+		schedule := resp.Data.Schedule
+		for _, segment := range schedule.Segments {
+			start := segment.StartTime.Time
+			if !start.Before(until) {
+				return segments, nil
+			}
+			if start.Before(from) || len(segment.CanceledUntil) != 0 || onVacation(schedule.Vacation, start) {
+				continue
+			}
+			segments = append(segments, Segment{
+				ID:               segment.ID,
+				BroadcasterID:    schedule.BroadcasterID,
+				BroadcasterLogin: schedule.BroadcasterLogin,
+				BroadcasterName:  schedule.BroadcasterName,
+				Title:            segment.Title,
+				Game:             segment.Category.Name,
+				StartTime:        start,
+				EndTime:          segment.EndTime.Time,
+			})
+		}
+
+		after = resp.Data.Pagination.Cursor
+		if len(after) == 0 || len(schedule.Segments) == 0 {
+			return segments, nil
+		}
+	}
+}
+
+func onVacation(vacation helix.GetScheduleVacation, at time.Time) bool {
+	if vacation.StartTime.IsZero() {
+		return false
+	}
+	return !at.Before(vacation.StartTime.Time) && (vacation.EndTime.IsZero() || at.Before(vacation.EndTime.Time))
+}
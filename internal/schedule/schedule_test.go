@@ -0,0 +1,87 @@
+package schedule
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	helix "github.com/nicklaw5/helix/v2"
+)
+
+func TestFetch(t *testing.T) {
+	now := time.Date(2024, 1, 2, 12, 0, 0, 0, time.UTC)
+	segment := func(id string, start time.Time, canceled string) map[string]interface{} {
+		return map[string]interface{}{
+			"id":             id,
+			"start_time":     start.Format(time.RFC3339),
+			"end_time":       start.Add(2 * time.Hour).Format(time.RFC3339),
+			"title":          "stream " + id,
+			"canceled_until": canceled,
+			"category":       map[string]string{"id": "1", "name": "Art"},
+		}
+	}
+	pages := map[string]map[string]interface{}{
+		"": {
+			"data": map[string]interface{}{
+				"broadcaster_id":    "1",
+				"broadcaster_login": "halkeye",
+				"broadcaster_name":  "Halkeye",
+				"vacation": map[string]string{
+					"start_time": now.Add(4 * time.Hour).Format(time.RFC3339),
+					"end_time":   now.Add(6 * time.Hour).Format(time.RFC3339),
+				},
+				"segments": []interface{}{
+					segment("a", now.Add(time.Hour), ""),
+					segment("canceled", now.Add(2*time.Hour), now.Add(4*time.Hour).Format(time.RFC3339)),
+					segment("vacation", now.Add(5*time.Hour), ""),
+				},
+			},
+			"pagination": map[string]string{"cursor": "page2"},
+		},
+		"page2": {
+			"data": map[string]interface{}{
+				"broadcaster_id": "1",
+				"segments": []interface{}{
+					segment("b", now.Add(7*time.Hour), ""),
+					segment("tomorrow", now.Add(30*time.Hour), ""),
+				},
+			},
+			"pagination": map[string]string{"cursor": "page3"},
+		},
+	}
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("broadcaster_id") != "1" {
+			w.WriteHeader(http.StatusNotFound)
+			_, _ = w.Write([]byte(`{"error":"Not Found","status":404,"message":"segments were either all filtered out or not found"}`))
+			return
+		}
+		if r.URL.Query().Get("after") == "page3" {
+			t.Errorf("Fetch() kept paging after passing until")
+		}
+		_ = json.NewEncoder(w).Encode(pages[r.URL.Query().Get("after")])
+	}))
+	defer srv.Close()
+
+	client, err := helix.NewClient(&helix.Options{ClientID: "id", APIBaseURL: srv.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	segments, err := Fetch(client, "1", now, now.Add(24*time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(segments) != 2 || segments[0].ID != "a" || segments[1].ID != "b" {
+		t.Fatalf("Fetch() = %+v; want a and b without the canceled or vacation segments", segments)
+	}
+	if segments[0].BroadcasterLogin != "halkeye" || segments[0].Game != "Art" || !segments[0].StartTime.Equal(now.Add(time.Hour)) {
+		t.Errorf("Fetch() = %+v", segments[0])
+	}
+
+	if segments, err := Fetch(client, "2", now, now.Add(24*time.Hour)); err != nil || len(segments) != 0 {
+		t.Errorf("Fetch() without a schedule = %+v, %v", segments, err)
+	}
+}
@@ -0,0 +1,187 @@
+package shoutout
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+
+	helix "github.com/nicklaw5/helix/v2"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// BroadcasterCooldown is how often twitch lets a channel give a shoutout.
+	BroadcasterCooldown = 2 * time.Minute
+	// TargetCooldown is how often twitch lets a channel shout out the same streamer.
+	TargetCooldown = time.Hour
+)
+
+// idle is how long Run sleeps with nothing queued, Add wakes it up sooner.
+const idle = time.Hour
+
+// errCooldown is twitch saying no to a shoutout we thought was allowed, someone else shouted out in the meantime.
+var errCooldown = errors.New("shoutout is still on cooldown")
+
+// Shoutout is one channel shouting out another.
+type Shoutout struct {
+	FromBroadcasterID string
+	FromLogin         string
+	ToBroadcasterID   string
+	ToLogin           string
+	// QueuedAt is when the shoutout was asked for
+	QueuedAt time.Time
+}
+
+type pair struct {
+	from string
+	to   string
+}
+
+// Queue sends shoutouts as a moderator of the channels giving them, holding each one back until twitch's
+// cooldowns allow it. Shoutouts that would have to wait longer than maxWait are dropped, nobody remembers the raid by then.
+type Queue struct {
+	client      *helix.Client
+	moderatorID string
+	maxWait     time.Duration
+
+	mu      sync.Mutex
+	pending []Shoutout
+	// broadcaster id => when they can give their next shoutout
+	fromReady map[string]time.Time
+	// when a broadcaster can shout out the same streamer again
+	pairReady map[pair]time.Time
+	wake      chan struct{}
+}
+
+func New(client *helix.Client, moderatorID string, maxWait time.Duration) *Queue {
+	return &Queue{
+		client:      client,
+		moderatorID: moderatorID,
+		maxWait:     maxWait,
+		fromReady:   map[string]time.Time{},
+		pairReady:   map[pair]time.Time{},
+		wake:        make(chan struct{}, 1),
+	}
+}
+
+// Add queues a shoutout, unless the same one is already waiting.
+func (q *Queue) Add(shoutout Shoutout) {
+	q.mu.Lock()
+	for _, queued := range q.pending {
+		if queued.FromBroadcasterID == shoutout.FromBroadcasterID && queued.ToBroadcasterID == shoutout.ToBroadcasterID {
+			q.mu.Unlock()
+			return
+		}
+	}
+	q.pending = append(q.pending, shoutout)
+	q.mu.Unlock()
+
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Run sends shoutouts as their cooldowns run out until ctx is cancelled.
+func (q *Queue) Run(ctx context.Context) {
+	log.Infof("Sending shoutouts as moderator %s", q.moderatorID)
+
+	for {
+		timer := time.NewTimer(q.Send(time.Now()))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		case <-q.wake:
+			timer.Stop()
+		}
+	}
+}
+
+// Send sends every queued shoutout whose cooldowns have run out, one per broadcaster, drops the ones that
+// waited too long, and returns how long until the next one is ready.
+func (q *Queue) Send(now time.Time) time.Duration {
+	q.mu.Lock()
+	due := []Shoutout{}
+	remaining := []Shoutout{}
+	giving := map[string]bool{}
+	for _, shoutout := range q.pending {
+		ready := q.readyAt(shoutout)
+		if ready.Sub(shoutout.QueuedAt) > q.maxWait {
+			log.Infof("Dropping %s's shoutout of %s, twitch won't allow it until %s", shoutout.FromLogin, shoutout.ToLogin, ready.Format(time.Kitchen))
+			continue
+		}
+		if !ready.After(now) && !giving[shoutout.FromBroadcasterID] {
+			giving[shoutout.FromBroadcasterID] = true
+			due = append(due, shoutout)
+			continue
+		}
+		remaining = append(remaining, shoutout)
+	}
+	q.pending = remaining
+	q.mu.Unlock()
+
+	for _, shoutout := range due {
+		err := q.send(shoutout)
+
+		q.mu.Lock()
+		switch {
+		case err == errCooldown:
+			q.fromReady[shoutout.FromBroadcasterID] = now.Add(BroadcasterCooldown)
+			q.pending = append(q.pending, shoutout)
+		case err != nil:
+			log.Error(errors.Wrapf(err, "unable to send %s's shoutout of %s", shoutout.FromLogin, shoutout.ToLogin))
+		default:
+			log.Infof("%s shouted out %s", shoutout.FromLogin, shoutout.ToLogin)
+			q.fromReady[shoutout.FromBroadcasterID] = now.Add(BroadcasterCooldown)
+			q.pairReady[pair{shoutout.FromBroadcasterID, shoutout.ToBroadcasterID}] = now.Add(TargetCooldown)
+		}
+		q.mu.Unlock()
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	next := idle
+	for _, shoutout := range q.pending {
+		if wait := q.readyAt(shoutout).Sub(now); wait < next {
+			next = wait
+		}
+	}
+	if next < 0 {
+		next = 0
+	}
+	return next
+}
+
+// readyAt is when both of twitch's cooldowns allow shoutout, q.mu must be held.
+func (q *Queue) readyAt(shoutout Shoutout) time.Time {
+	ready := shoutout.QueuedAt
+	if from := q.fromReady[shoutout.FromBroadcasterID]; from.After(ready) {
+		ready = from
+	}
+	if target := q.pairReady[pair{shoutout.FromBroadcasterID, shoutout.ToBroadcasterID}]; target.After(ready) {
+		ready = target
+	}
+	return ready
+}
+
+func (q *Queue) send(shoutout Shoutout) error {
+	resp, err := q.client.SendShoutout(&helix.SendShoutoutParams{
+		FromBroadcasterID: shoutout.FromBroadcasterID,
+		ToBroadcasterID:   shoutout.ToBroadcasterID,
+		ModeratorID:       q.moderatorID,
+	})
+	if err != nil {
+		return errors.Wrap(err, "unable to send shoutout")
+	}
+	if resp.ErrorStatus == http.StatusTooManyRequests {
+		return errCooldown
+	}
+	if resp.ErrorStatus != 0 {
+		return errors.Errorf("error sending shoutout status=%d %s error=%s", resp.ErrorStatus, resp.Error, resp.ErrorMessage)
+	}
+	return nil
+}
@@ -0,0 +1,73 @@
+package shoutout
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	helix "github.com/nicklaw5/helix/v2"
+)
+
+func TestQueue(t *testing.T) {
+	var sent []string
+	busy := map[string]bool{"5": true}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		if query.Get("moderator_id") != "99" {
+			t.Errorf("shoutout sent as moderator %q", query.Get("moderator_id"))
+		}
+		from := query.Get("from_broadcaster_id")
+		if busy[from] {
+			// the streamer shouted someone out themselves
+			delete(busy, from)
+			w.WriteHeader(http.StatusTooManyRequests)
+			_, _ = w.Write([]byte(`{"error":"Too Many Requests","status":429,"message":"cooldown"}`))
+			return
+		}
+		sent = append(sent, from+">"+query.Get("to_broadcaster_id"))
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+
+	client, err := helix.NewClient(&helix.Options{ClientID: "id", APIBaseURL: srv.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Date(2024, 1, 2, 20, 0, 0, 0, time.UTC)
+	q := New(client, "99", 10*time.Minute)
+	for _, shoutout := range []Shoutout{
+		{FromBroadcasterID: "1", ToBroadcasterID: "2", QueuedAt: now},
+		{FromBroadcasterID: "1", ToBroadcasterID: "2", QueuedAt: now}, // the same raid twice
+		{FromBroadcasterID: "1", ToBroadcasterID: "3", QueuedAt: now},
+		{FromBroadcasterID: "4", ToBroadcasterID: "2", QueuedAt: now},
+		{FromBroadcasterID: "5", ToBroadcasterID: "6", QueuedAt: now},
+	} {
+		q.Add(shoutout)
+	}
+
+	steps := []struct {
+		at   time.Duration
+		add  *Shoutout
+		want string
+		wait time.Duration
+	}{
+		{0, nil, "1>2,4>2", BroadcasterCooldown},
+		{time.Minute, nil, "", time.Minute},
+		{BroadcasterCooldown, nil, "1>3,5>6", idle},
+		// 1 shouted 2 out less than an hour ago, which is longer than anyone will wait
+		{3 * time.Minute, &Shoutout{FromBroadcasterID: "1", ToBroadcasterID: "2", QueuedAt: now.Add(3 * time.Minute)}, "", idle},
+	}
+	for _, step := range steps {
+		if step.add != nil {
+			q.Add(*step.add)
+		}
+		sent = nil
+		wait := q.Send(now.Add(step.at))
+		if got := strings.Join(sent, ","); got != step.want || wait != step.wait {
+			t.Errorf("Send() at +%s sent %q, waiting %s; want %q, waiting %s", step.at, got, wait, step.want, step.wait)
+		}
+	}
+}
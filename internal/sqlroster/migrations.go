@@ -0,0 +1,57 @@
+package sqlroster
+
+// migrations are applied in order and never edited once released, add a new one instead.
+var migrations = map[string][]string{
+	sqlite: {
+		`CREATE TABLE streamers (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			twitch_login TEXT NOT NULL UNIQUE,
+			display_name TEXT NOT NULL DEFAULT '',
+			enabled BOOLEAN NOT NULL DEFAULT TRUE,
+			message TEXT NOT NULL DEFAULT '',
+			discord_role TEXT NOT NULL DEFAULT '',
+			discord_channel TEXT NOT NULL DEFAULT '',
+			tags TEXT NOT NULL DEFAULT '',
+			twitch_status TEXT NOT NULL DEFAULT '',
+			twitch_id TEXT NOT NULL DEFAULT '',
+			updated_at TEXT NOT NULL DEFAULT (strftime('%Y-%m-%d %H:%M:%f', 'now'))
+		)`,
+		`CREATE TRIGGER streamers_updated_at AFTER UPDATE ON streamers FOR EACH ROW WHEN NEW.updated_at = OLD.updated_at
+		BEGIN
+			UPDATE streamers SET updated_at = strftime('%Y-%m-%d %H:%M:%f', 'now') WHERE id = NEW.id;
+		END`,
+		`ALTER TABLE streamers ADD COLUMN game_changes BOOLEAN NOT NULL DEFAULT FALSE`,
+		`ALTER TABLE streamers ADD COLUMN shoutouts BOOLEAN NOT NULL DEFAULT FALSE`,
+	},
+	postgres: {
+		`CREATE TABLE streamers (
+			id BIGSERIAL PRIMARY KEY,
+			twitch_login TEXT NOT NULL UNIQUE,
+			display_name TEXT NOT NULL DEFAULT '',
+			enabled BOOLEAN NOT NULL DEFAULT TRUE,
+			message TEXT NOT NULL DEFAULT '',
+			discord_role TEXT NOT NULL DEFAULT '',
+			discord_channel TEXT NOT NULL DEFAULT '',
+			tags TEXT NOT NULL DEFAULT '',
+			twitch_status TEXT NOT NULL DEFAULT '',
+			twitch_id TEXT NOT NULL DEFAULT '',
+			updated_at TIMESTAMPTZ NOT NULL DEFAULT now()
+		)`,
+		`CREATE FUNCTION streamers_changed() RETURNS trigger AS $$
+		BEGIN
+			IF TG_OP = 'UPDATE' THEN
+				NEW.updated_at := now();
+			END IF;
+			PERFORM pg_notify('` + notifyChannel + `', TG_OP);
+			IF TG_OP = 'DELETE' THEN
+				RETURN OLD;
+			END IF;
+			RETURN NEW;
+		END;
+		$$ LANGUAGE plpgsql`,
+		`CREATE TRIGGER streamers_changed BEFORE INSERT OR UPDATE OR DELETE ON streamers
+		FOR EACH ROW EXECUTE FUNCTION streamers_changed()`,
+		`ALTER TABLE streamers ADD COLUMN game_changes BOOLEAN NOT NULL DEFAULT FALSE`,
+		`ALTER TABLE streamers ADD COLUMN shoutouts BOOLEAN NOT NULL DEFAULT FALSE`,
+	},
+}
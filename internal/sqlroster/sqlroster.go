@@ -0,0 +1,278 @@
+package sqlroster
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	_ "modernc.org/sqlite"
+
+	"github.com/halkeye/twitch_go_online/internal/roster"
+)
+
+const (
+	sqlite   = "sqlite"
+	postgres = "postgres"
+
+	// notifyChannel is what the postgres trigger tells about roster changes
+	notifyChannel = "roster_changed"
+)
+
+// DB is a roster kept in the streamers table of a sqlite or postgres database. Postgres tells us about
+// changes with LISTEN/NOTIFY, sqlite gets checked every PollInterval for rows with a new updated_at.
+type DB struct {
+	// PollInterval is how often sqlite is checked, and how often postgres is checked in case a notification was missed
+	PollInterval time.Duration
+
+	db      *sql.DB
+	dialect string
+	dsn     string
+
+	mu   sync.Mutex
+	last []roster.Member
+}
+
+// Open connects to dsn, a postgres:// url or the path of a sqlite database, and brings the schema up to date.
+func Open(dsn string) (*DB, error) {
+	dialect := sqlite
+	if strings.HasPrefix(dsn, "postgres://") || strings.HasPrefix(dsn, "postgresql://") {
+		dialect = postgres
+	}
+
+	db, err := sql.Open(dialect, dsn)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to open roster database")
+	}
+	if dialect == sqlite {
+		// sqlite only has the one writer anyways, and an in memory database is per connection
+		db.SetMaxOpenConns(1)
+	}
+
+	d := &DB{
+		PollInterval: 30 * time.Second,
+		db:           db,
+		dialect:      dialect,
+		dsn:          dsn,
+	}
+	if err := d.migrate(); err != nil {
+		_ = db.Close()
+		return nil, err
+	}
+	return d, nil
+}
+
+func (d *DB) Close() error {
+	return d.db.Close()
+}
+
+// migrate applies whatever migrations the database hasn't seen yet.
+func (d *DB) migrate() error {
+	if _, err := d.db.Exec(`CREATE TABLE IF NOT EXISTS roster_migrations (version INTEGER NOT NULL)`); err != nil {
+		return errors.Wrap(err, "unable to create migrations table")
+	}
+
+	var version int
+	if err := d.db.QueryRow(`SELECT COALESCE(MAX(version), 0) FROM roster_migrations`).Scan(&version); err != nil {
+		return errors.Wrap(err, "unable to read schema version")
+	}
+
+	for i, statement := range migrations[d.dialect] {
+		if i < version {
+			continue
+		}
+
+		tx, err := d.db.Begin()
+		if err != nil {
+			return errors.Wrap(err, "unable to start migration")
+		}
+		if _, err := tx.Exec(statement); err != nil {
+			_ = tx.Rollback()
+			return errors.Wrapf(err, "migration %d failed", i+1)
+		}
+		if _, err := tx.Exec(d.rebind(`INSERT INTO roster_migrations (version) VALUES (?)`), i+1); err != nil {
+			_ = tx.Rollback()
+			return errors.Wrapf(err, "unable to record migration %d", i+1)
+		}
+		if err := tx.Commit(); err != nil {
+			return errors.Wrapf(err, "unable to commit migration %d", i+1)
+		}
+		log.Infof("applied roster migration %d", i+1)
+	}
+	return nil
+}
+
+// rebind swaps the ? placeholders for postgres' numbered ones.
+func (d *DB) rebind(query string) string {
+	if d.dialect != postgres {
+		return query
+	}
+
+	var b strings.Builder
+	n := 0
+	for _, r := range query {
+		if r == '?' {
+			n++
+			b.WriteString("$" + strconv.Itoa(n))
+		} else {
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+// Members returns everyone in the streamers table.
+func (d *DB) Members() ([]roster.Member, error) {
+	members, err := d.read()
+	if err != nil {
+		return nil, err
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.last = members
+	return members, nil
+}
+
+func (d *DB) read() ([]roster.Member, error) {
+	rows, err := d.db.Query(`SELECT id, twitch_login, display_name, enabled, message, discord_role, discord_channel, tags, twitch_status, twitch_id,
+		game_changes, shoutouts FROM streamers ORDER BY twitch_login`)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to fetch streamers")
+	}
+	defer rows.Close()
+
+	members := []roster.Member{}
+	for rows.Next() {
+		var id int64
+		var tags string
+		var member roster.Member
+		err := rows.Scan(&id, &member.TwitchLogin, &member.DisplayName, &member.Enabled, &member.Message,
+			&member.DiscordRole, &member.Channel, &tags, &member.Status, &member.ResolvedID,
+			&member.GameChanges, &member.Shoutouts)
+		if err != nil {
+			return nil, errors.Wrap(err, "unable to read streamer")
+		}
+
+		member.RecordID = strconv.FormatInt(id, 10)
+		member.TwitchLogin = roster.NormalizeLogin(member.TwitchLogin)
+		member.Tags = []string{}
+		for _, tag := range strings.Split(tags, ",") {
+			if tag = strings.TrimSpace(tag); len(tag) != 0 {
+				member.Tags = append(member.Tags, tag)
+			}
+		}
+		if len(member.TwitchLogin) != 0 {
+			members = append(members, member)
+		}
+	}
+	return members, errors.Wrap(rows.Err(), "unable to read streamers")
+}
+
+// fingerprint changes whenever a row is added, removed or updated.
+func (d *DB) fingerprint() (string, error) {
+	var count int
+	var updated sql.NullString
+	err := d.db.QueryRow(`SELECT COUNT(*), CAST(MAX(updated_at) AS TEXT) FROM streamers`).Scan(&count, &updated)
+	if err != nil {
+		return "", errors.Wrap(err, "unable to check for roster changes")
+	}
+	return fmt.Sprintf("%d/%s", count, updated.String), nil
+}
+
+// Watch calls onChange when the streamers table changes until ctx is done.
+func (d *DB) Watch(ctx context.Context, onChange roster.ChangeFunc) error {
+	var notifications <-chan *pq.Notification
+	if d.dialect == postgres {
+		listener := pq.NewListener(d.dsn, time.Second, time.Minute, func(event pq.ListenerEventType, err error) {
+			if err != nil {
+				log.Error(errors.Wrap(err, "roster listener"))
+			}
+		})
+		defer listener.Close()
+		if err := listener.Listen(notifyChannel); err != nil {
+			return errors.Wrap(err, "unable to listen for roster changes")
+		}
+		notifications = listener.Notify
+	}
+
+	previous, err := d.fingerprint()
+	if err != nil {
+		return err
+	}
+
+	ticker := time.NewTicker(d.PollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-notifications:
+			// a nil notification means the connection was re-established, check anyways
+		case <-ticker.C:
+		}
+
+		current, err := d.fingerprint()
+		if err != nil {
+			log.Error(err)
+			continue
+		}
+		if current == previous {
+			continue
+		}
+		previous = current
+		d.reload(onChange)
+	}
+}
+
+func (d *DB) reload(onChange roster.ChangeFunc) {
+	members, err := d.read()
+	if err != nil {
+		log.Error(err)
+		return
+	}
+
+	d.mu.Lock()
+	changes := roster.Diff(d.last, members)
+	d.last = members
+	d.mu.Unlock()
+
+	if len(changes) != 0 {
+		log.WithField("changes", len(changes)).Info("roster database changed")
+		onChange(changes, members)
+	}
+}
+
+// SetStatuses records validation results, including renames, against the streamers.
+func (d *DB) SetStatuses(results []roster.StatusResult) error {
+	query := d.rebind(`UPDATE streamers SET twitch_status = ?, twitch_id = ?, twitch_login = ? WHERE id = ?`)
+	for _, result := range results {
+		member := result.Member
+		userID, login := member.ResolvedID, member.TwitchLogin
+		if len(result.UserID) != 0 {
+			userID = result.UserID
+		}
+		if len(result.Login) != 0 {
+			login = result.Login
+		}
+		if result.Status == member.Status && userID == member.ResolvedID && login == member.TwitchLogin {
+			continue
+		}
+
+		id, err := strconv.ParseInt(member.RecordID, 10, 64)
+		if err != nil {
+			// someone from a different source, merged in with ours
+			continue
+		}
+		if _, err := d.db.Exec(query, result.Status, userID, login, id); err != nil {
+			return errors.Wrapf(err, "unable to update status of %s", member.TwitchLogin)
+		}
+	}
+	return nil
+}
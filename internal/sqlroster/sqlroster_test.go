@@ -0,0 +1,83 @@
+package sqlroster
+
+import (
+	"context"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/halkeye/twitch_go_online/internal/roster"
+)
+
+func TestSQLite(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "roster.db")
+	d, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+	d.PollInterval = 10 * time.Millisecond
+
+	if _, err := d.db.Exec(`INSERT INTO streamers (twitch_login, discord_role, tags) VALUES ('https://twitch.tv/Halkeye', '1234', 'art, music'), ('off', '', '')`); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.db.Exec(`UPDATE streamers SET enabled = FALSE WHERE twitch_login = 'off'`); err != nil {
+		t.Fatal(err)
+	}
+
+	members, err := d.Members()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(members) != 2 || members[0].TwitchLogin != "halkeye" || strings.Join(members[0].Tags, ",") != "art,music" || members[1].Enabled {
+		t.Fatalf("Members() = %+v", members)
+	}
+
+	// opening it again doesn't re-run the migrations
+	again, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = again.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	changed := make(chan []roster.Change, 1)
+	go func() {
+		_ = d.Watch(ctx, func(changes []roster.Change, _ []roster.Member) { changed <- changes })
+	}()
+	time.Sleep(50 * time.Millisecond)
+
+	if _, err := d.db.Exec(`UPDATE streamers SET enabled = TRUE WHERE twitch_login = 'off'`); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case changes := <-changed:
+		if added, _ := roster.LoginChanges(changes); strings.Join(added, ",") != "off" {
+			t.Errorf("changes = %+v", changes)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("roster change was never picked up")
+	}
+
+	err = d.SetStatuses([]roster.StatusResult{{Member: members[0], Status: roster.StatusOK, UserID: "1", Login: "halkeye2"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	members, err = d.read()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if members[0].TwitchLogin != "halkeye2" || members[0].ResolvedID != "1" || members[0].Status != roster.StatusOK {
+		t.Errorf("after SetStatuses = %+v", members[0])
+	}
+}
+
+func TestRebind(t *testing.T) {
+	d := &DB{dialect: postgres}
+	if got := d.rebind(`UPDATE a SET b = ? WHERE c = ?`); got != `UPDATE a SET b = $1 WHERE c = $2` {
+		t.Errorf("rebind() = %s", got)
+	}
+}
@@ -0,0 +1,253 @@
+package stats
+
+import (
+	"sort"
+	"time"
+
+	"github.com/halkeye/twitch_go_online/internal/storage"
+)
+
+// topGames is how many games a report lists
+const topGames = 5
+
+// Game is how much of a period went to one game.
+type Game struct {
+	Game     string  `json:"game"`
+	Sessions int     `json:"sessions"`
+	Hours    float64 `json:"hours"`
+}
+
+// Streamer sums up one broadcaster's streams over a period.
+type Streamer struct {
+	BroadcasterID string  `json:"broadcaster_id"`
+	Login         string  `json:"login"`
+	Name          string  `json:"name"`
+	Sessions      int     `json:"sessions"`
+	Hours         float64 `json:"hours"`
+	AverageHours  float64 `json:"average_hours"`
+	// streaks are counted in days (UTC) with at least one stream
+	LongestStreak int    `json:"longest_streak"`
+	CurrentStreak int    `json:"current_streak"`
+	TopGames      []Game `json:"top_games"`
+}
+
+// Stream is a single session picked out of a period.
+type Stream struct {
+	StreamID  string    `json:"stream_id"`
+	Login     string    `json:"login"`
+	Name      string    `json:"name"`
+	Game      string    `json:"game"`
+	Title     string    `json:"title"`
+	StartedAt time.Time `json:"started_at"`
+	Hours     float64   `json:"hours"`
+}
+
+// Report sums up everyone's streams over a period.
+type Report struct {
+	Since     time.Time  `json:"since"`
+	Until     time.Time  `json:"until"`
+	Sessions  int        `json:"sessions"`
+	Hours     float64    `json:"hours"`
+	Streamers []Streamer `json:"streamers"`
+	TopGames  []Game     `json:"top_games"`
+	// LongestStream is nil when nobody streamed
+	LongestStream *Stream `json:"longest_stream,omitempty"`
+}
+
+// Duration is how long a session ran within until. A stream that never ended counts up to its last sample,
+// it may have ended while nobody was listening, and up to now when it was never sampled.
+func Duration(session storage.Session, until time.Time, now time.Time) time.Duration {
+	return end(session, until, now).Sub(session.StartedAt)
+}
+
+func end(session storage.Session, until time.Time, now time.Time) time.Time {
+	end := session.EndedAt
+	if end.IsZero() {
+		end = now
+		if !session.LastSampledAt.IsZero() && session.LastSampledAt.Before(now) {
+			end = session.LastSampledAt
+		}
+	}
+	if end.After(until) {
+		end = until
+	}
+	if end.Before(session.StartedAt) {
+		return session.StartedAt
+	}
+	return end
+}
+
+// Changes loads the game and title changes of sessions, by stream id.
+func Changes(store storage.Store, sessions []storage.Session) (map[string][]storage.StreamChange, error) {
+	changes := map[string][]storage.StreamChange{}
+	for _, session := range sessions {
+		streamChanges, err := store.Changes(session.StreamID)
+		if err != nil {
+			return nil, err
+		}
+		changes[session.StreamID] = streamChanges
+	}
+	return changes, nil
+}
+
+// gameHours splits a session's hours between the games it played, using its changes (oldest first).
+// Whatever came before the first change counts towards the first game, that change is the stream's first sample.
+func gameHours(session storage.Session, changes []storage.StreamChange, until time.Time, now time.Time) map[string]float64 {
+	ended := end(session, until, now)
+	if len(changes) == 0 {
+		return map[string]float64{session.Game: ended.Sub(session.StartedAt).Hours()}
+	}
+
+	hours := map[string]float64{}
+	for i, change := range changes {
+		from := change.At
+		if i == 0 || from.Before(session.StartedAt) {
+			from = session.StartedAt
+		}
+		to := ended
+		if i+1 < len(changes) && changes[i+1].At.Before(ended) {
+			to = changes[i+1].At
+		}
+		if to.After(from) {
+			hours[change.Game] += to.Sub(from).Hours()
+		}
+	}
+	if len(hours) == 0 {
+		// it still counts as a session of whatever it was playing
+		hours[changes[len(changes)-1].Game] = 0
+	}
+	return hours
+}
+
+// Compute builds a report from the sessions that started between since and until. changes are the
+// sessions' game changes by stream id, see Changes, and are what top games are counted from.
+func Compute(sessions []storage.Session, changes map[string][]storage.StreamChange, since time.Time, until time.Time, now time.Time) Report {
+	report := Report{Since: since, Until: until, Streamers: []Streamer{}}
+
+	byBroadcaster := map[string][]storage.Session{}
+	order := []string{}
+	var longest time.Duration
+	for _, session := range sessions {
+		if session.StartedAt.Before(since) || session.StartedAt.After(until) {
+			continue
+		}
+		if duration := Duration(session, until, now); report.LongestStream == nil || duration > longest {
+			longest = duration
+			report.LongestStream = &Stream{
+				StreamID:  session.StreamID,
+				Login:     session.BroadcasterLogin,
+				Name:      session.BroadcasterName,
+				Game:      session.Game,
+				Title:     session.Title,
+				StartedAt: session.StartedAt,
+				Hours:     round(duration.Hours()),
+			}
+		}
+		if _, ok := byBroadcaster[session.BroadcasterID]; !ok {
+			order = append(order, session.BroadcasterID)
+		}
+		byBroadcaster[session.BroadcasterID] = append(byBroadcaster[session.BroadcasterID], session)
+	}
+
+	allGames := map[string]*Game{}
+	for _, broadcasterID := range order {
+		streamer := streamerStats(byBroadcaster[broadcasterID], changes, until, now, allGames)
+		report.Sessions += streamer.Sessions
+		report.Hours += streamer.Hours
+		report.Streamers = append(report.Streamers, streamer)
+	}
+	sort.SliceStable(report.Streamers, func(i, j int) bool { return report.Streamers[i].Hours > report.Streamers[j].Hours })
+	report.Hours = round(report.Hours)
+	report.TopGames = top(allGames)
+	return report
+}
+
+func streamerStats(sessions []storage.Session, changes map[string][]storage.StreamChange, until time.Time, now time.Time, allGames map[string]*Game) Streamer {
+	// newest session has the freshest login
+	sort.Slice(sessions, func(i, j int) bool { return sessions[i].StartedAt.After(sessions[j].StartedAt) })
+	streamer := Streamer{
+		BroadcasterID: sessions[0].BroadcasterID,
+		Login:         sessions[0].BroadcasterLogin,
+		Name:          sessions[0].BroadcasterName,
+		Sessions:      len(sessions),
+	}
+
+	games := map[string]*Game{}
+	days := map[string]bool{}
+	for _, session := range sessions {
+		hours := Duration(session, until, now).Hours()
+		streamer.Hours += hours
+		days[session.StartedAt.UTC().Format("2006-01-02")] = true
+
+		for name, played := range gameHours(session, changes[session.StreamID], until, now) {
+			for _, counts := range []map[string]*Game{games, allGames} {
+				game, ok := counts[name]
+				if !ok {
+					game = &Game{Game: name}
+					counts[name] = game
+				}
+				game.Sessions++
+				game.Hours += played
+			}
+		}
+	}
+
+	streamer.AverageHours = round(streamer.Hours / float64(streamer.Sessions))
+	streamer.Hours = round(streamer.Hours)
+	streamer.LongestStreak, streamer.CurrentStreak = streaks(days, now)
+	streamer.TopGames = top(games)
+	return streamer
+}
+
+// streaks finds the longest run of consecutive days, and the run that is still going (today or yesterday).
+func streaks(days map[string]bool, now time.Time) (longest int, current int) {
+	dates := []time.Time{}
+	for day := range days {
+		date, _ := time.Parse("2006-01-02", day)
+		dates = append(dates, date)
+	}
+	sort.Slice(dates, func(i, j int) bool { return dates[i].Before(dates[j]) })
+
+	run := 0
+	for i, date := range dates {
+		if i > 0 && date.Sub(dates[i-1]) == 24*time.Hour {
+			run++
+		} else {
+			run = 1
+		}
+		if run > longest {
+			longest = run
+		}
+	}
+
+	today := now.UTC().Truncate(24 * time.Hour)
+	if len(dates) != 0 {
+		last := dates[len(dates)-1]
+		if last.Equal(today) || last.Equal(today.Add(-24*time.Hour)) {
+			current = run
+		}
+	}
+	return longest, current
+}
+
+func top(games map[string]*Game) []Game {
+	list := []Game{}
+	for _, game := range games {
+		list = append(list, Game{Game: game.Game, Sessions: game.Sessions, Hours: round(game.Hours)})
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Hours != list[j].Hours {
+			return list[i].Hours > list[j].Hours
+		}
+		return list[i].Game < list[j].Game
+	})
+	if len(list) > topGames {
+		list = list[:topGames]
+	}
+	return list
+}
+
+// round keeps hours to two decimal places, nobody needs more in a report
+func round(hours float64) float64 {
+	return float64(int64(hours*100+0.5)) / 100
+}
@@ -0,0 +1,64 @@
+package stats
+
+import (
+	"testing"
+	"time"
+
+	"github.com/halkeye/twitch_go_online/internal/storage"
+)
+
+func TestCompute(t *testing.T) {
+	now := time.Date(2024, 3, 10, 12, 0, 0, 0, time.UTC)
+	day := func(d int, hour int) time.Time { return time.Date(2024, 3, d, hour, 0, 0, 0, time.UTC) }
+
+	sessions := []storage.Session{
+		{StreamID: "1", BroadcasterID: "a", BroadcasterLogin: "alice", StartedAt: day(5, 10), EndedAt: day(5, 12), Game: "Art"},
+		{StreamID: "2", BroadcasterID: "a", BroadcasterLogin: "alice", StartedAt: day(6, 10), EndedAt: day(6, 11), Game: "Chess"},
+		{StreamID: "3", BroadcasterID: "a", BroadcasterLogin: "alice", StartedAt: day(9, 10), EndedAt: day(9, 13), Game: "Art"},
+		// still live, counts up to now
+		{StreamID: "4", BroadcasterID: "a", BroadcasterLogin: "alice", StartedAt: day(10, 11), Game: "Art"},
+		{StreamID: "5", BroadcasterID: "b", BroadcasterLogin: "bob", StartedAt: day(1, 10), EndedAt: day(1, 11), Game: "Chess"},
+		// before the period
+		{StreamID: "6", BroadcasterID: "b", BroadcasterLogin: "bob", StartedAt: day(1, 1).Add(-48 * time.Hour), EndedAt: day(1, 2), Game: "Chess"},
+		// never ended, we were down when it did, so it counts up to its last sample
+		{StreamID: "7", BroadcasterID: "b", BroadcasterLogin: "bob", StartedAt: day(8, 10), LastSampledAt: day(8, 12), Game: "Music"},
+	}
+	// stream 3 switched to chess for its last hour, the session only remembers the game it ended on
+	sessions[2].Game = "Chess"
+	changes := map[string][]storage.StreamChange{
+		"3": {{StreamID: "3", At: day(9, 10).Add(5 * time.Minute), Game: "Art"}, {StreamID: "3", At: day(9, 12), Game: "Chess"}},
+	}
+
+	report := Compute(sessions, changes, day(1, 0), now, now)
+	if report.Sessions != 6 || report.Hours != 10 {
+		t.Errorf("report = %d sessions, %v hours; want 6, 10", report.Sessions, report.Hours)
+	}
+	if len(report.Streamers) != 2 {
+		t.Fatalf("streamers = %+v", report.Streamers)
+	}
+
+	alice := report.Streamers[0]
+	if alice.Login != "alice" || alice.Sessions != 4 || alice.Hours != 7 || alice.AverageHours != 1.75 {
+		t.Errorf("alice = %+v", alice)
+	}
+	if alice.LongestStreak != 2 || alice.CurrentStreak != 2 {
+		t.Errorf("alice streaks = %d longest, %d current; want 2, 2", alice.LongestStreak, alice.CurrentStreak)
+	}
+	if alice.TopGames[0].Game != "Art" || alice.TopGames[0].Hours != 5 || alice.TopGames[1].Game != "Chess" || alice.TopGames[1].Hours != 2 {
+		t.Errorf("alice top games = %+v", alice.TopGames)
+	}
+	if bob := report.Streamers[1]; bob.Hours != 3 || bob.CurrentStreak != 0 || bob.LongestStreak != 1 {
+		t.Errorf("bob = %+v", bob)
+	}
+	want := []Game{{"Art", 3, 5}, {"Chess", 3, 3}, {"Music", 1, 2}}
+	if len(report.TopGames) != 3 || report.TopGames[0] != want[0] || report.TopGames[1] != want[1] || report.TopGames[2] != want[2] {
+		t.Errorf("top games = %+v; want %+v", report.TopGames, want)
+	}
+	if longest := report.LongestStream; longest == nil || longest.StreamID != "3" || longest.Hours != 3 {
+		t.Errorf("longest stream = %+v; want stream 3, 3 hours", longest)
+	}
+
+	if empty := Compute(nil, nil, day(1, 0), now, now); empty.LongestStream != nil || len(empty.Streamers) != 0 {
+		t.Errorf("empty report = %+v", empty)
+	}
+}
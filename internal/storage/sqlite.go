@@ -0,0 +1,555 @@
+package storage
+
+import (
+	"database/sql"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	_ "modernc.org/sqlite"
+)
+
+// migrations are applied in order and never edited once released, add a new one instead.
+// Times are unix seconds, and a NULL ended_at/announced_at means it hasn't happened yet.
+var migrations = []string{
+	`CREATE TABLE sessions (
+		stream_id TEXT PRIMARY KEY,
+		broadcaster_id TEXT NOT NULL,
+		broadcaster_login TEXT NOT NULL DEFAULT '',
+		broadcaster_name TEXT NOT NULL DEFAULT '',
+		started_at INTEGER NOT NULL,
+		ended_at INTEGER,
+		game TEXT NOT NULL DEFAULT '',
+		title TEXT NOT NULL DEFAULT '',
+		announced_at INTEGER
+	)`,
+	`CREATE INDEX sessions_broadcaster ON sessions (broadcaster_id, started_at)`,
+	`CREATE TABLE announcements (
+		stream_id TEXT NOT NULL,
+		destination TEXT NOT NULL,
+		message_id TEXT NOT NULL DEFAULT '',
+		sent_at INTEGER NOT NULL,
+		PRIMARY KEY (stream_id, destination)
+	)`,
+	`CREATE TABLE subscriptions (
+		id TEXT PRIMARY KEY,
+		type TEXT NOT NULL,
+		version TEXT NOT NULL DEFAULT '',
+		broadcaster_id TEXT NOT NULL DEFAULT '',
+		status TEXT NOT NULL DEFAULT '',
+		method TEXT NOT NULL DEFAULT '',
+		created_at INTEGER NOT NULL,
+		updated_at INTEGER NOT NULL
+	)`,
+	`ALTER TABLE sessions ADD COLUMN peak_viewers INTEGER NOT NULL DEFAULT 0`,
+	`ALTER TABLE sessions ADD COLUMN viewer_total INTEGER NOT NULL DEFAULT 0`,
+	`ALTER TABLE sessions ADD COLUMN viewer_samples INTEGER NOT NULL DEFAULT 0`,
+	`CREATE TABLE stream_changes (
+		stream_id TEXT NOT NULL,
+		at INTEGER NOT NULL,
+		game TEXT NOT NULL DEFAULT '',
+		title TEXT NOT NULL DEFAULT ''
+	)`,
+	`CREATE INDEX stream_changes_stream ON stream_changes (stream_id, at)`,
+	`CREATE TABLE raids (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		chain_id INTEGER NOT NULL DEFAULT 0,
+		from_broadcaster_id TEXT NOT NULL,
+		from_login TEXT NOT NULL DEFAULT '',
+		from_name TEXT NOT NULL DEFAULT '',
+		to_broadcaster_id TEXT NOT NULL,
+		to_login TEXT NOT NULL DEFAULT '',
+		to_name TEXT NOT NULL DEFAULT '',
+		viewers INTEGER NOT NULL DEFAULT 0,
+		at INTEGER NOT NULL
+	)`,
+	`CREATE INDEX raids_to ON raids (to_broadcaster_id, at)`,
+	`CREATE TABLE authorizations (
+		broadcaster_id TEXT PRIMARY KEY,
+		login TEXT NOT NULL DEFAULT '',
+		access_token TEXT NOT NULL,
+		refresh_token TEXT NOT NULL DEFAULT '',
+		scopes TEXT NOT NULL DEFAULT '',
+		expires_at INTEGER,
+		updated_at INTEGER NOT NULL
+	)`,
+	`CREATE TABLE milestones (
+		broadcaster_id TEXT NOT NULL,
+		kind TEXT NOT NULL,
+		total INTEGER NOT NULL DEFAULT 0,
+		announced INTEGER NOT NULL DEFAULT 0,
+		PRIMARY KEY (broadcaster_id, kind)
+	)`,
+	`CREATE TABLE reminders (
+		segment_id TEXT NOT NULL,
+		starts_at INTEGER NOT NULL,
+		sent_at INTEGER NOT NULL,
+		PRIMARY KEY (segment_id, starts_at)
+	)`,
+	`CREATE TABLE webhook_secrets (
+		notification_url TEXT PRIMARY KEY,
+		secret TEXT NOT NULL
+	)`,
+	`ALTER TABLE sessions ADD COLUMN last_sampled_at INTEGER`,
+}
+
+// SQLite is the default Store, a single file next to the bot.
+type SQLite struct {
+	db *sql.DB
+}
+
+func OpenSQLite(path string) (*SQLite, error) {
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to open storage")
+	}
+	// sqlite only has the one writer anyways, and an in memory database is per connection
+	db.SetMaxOpenConns(1)
+
+	s := &SQLite{db: db}
+	if err := s.migrate(); err != nil {
+		_ = db.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *SQLite) Close() error {
+	return s.db.Close()
+}
+
+func (s *SQLite) migrate() error {
+	if _, err := s.db.Exec(`CREATE TABLE IF NOT EXISTS storage_migrations (version INTEGER NOT NULL)`); err != nil {
+		return errors.Wrap(err, "unable to create migrations table")
+	}
+
+	var version int
+	if err := s.db.QueryRow(`SELECT COALESCE(MAX(version), 0) FROM storage_migrations`).Scan(&version); err != nil {
+		return errors.Wrap(err, "unable to read schema version")
+	}
+
+	for i, statement := range migrations {
+		if i < version {
+			continue
+		}
+
+		tx, err := s.db.Begin()
+		if err != nil {
+			return errors.Wrap(err, "unable to start migration")
+		}
+		if _, err := tx.Exec(statement); err != nil {
+			_ = tx.Rollback()
+			return errors.Wrapf(err, "migration %d failed", i+1)
+		}
+		if _, err := tx.Exec(`INSERT INTO storage_migrations (version) VALUES (?)`, i+1); err != nil {
+			_ = tx.Rollback()
+			return errors.Wrapf(err, "unable to record migration %d", i+1)
+		}
+		if err := tx.Commit(); err != nil {
+			return errors.Wrapf(err, "unable to commit migration %d", i+1)
+		}
+		log.Infof("applied storage migration %d", i+1)
+	}
+	return nil
+}
+
+func unix(t time.Time) sql.NullInt64 {
+	if t.IsZero() {
+		return sql.NullInt64{}
+	}
+	return sql.NullInt64{Int64: t.Unix(), Valid: true}
+}
+
+func fromUnix(n sql.NullInt64) time.Time {
+	if !n.Valid {
+		return time.Time{}
+	}
+	return time.Unix(n.Int64, 0).UTC()
+}
+
+func (s *SQLite) Announced(streamID string) bool {
+	var announced sql.NullInt64
+	err := s.db.QueryRow(`SELECT announced_at FROM sessions WHERE stream_id = ?`, streamID).Scan(&announced)
+	if err != nil && err != sql.ErrNoRows {
+		log.Error(errors.Wrap(err, "unable to check announcement history"))
+	}
+	return announced.Valid
+}
+
+func (s *SQLite) MarkAnnounced(streamID string, broadcasterID string, at time.Time) error {
+	_, err := s.db.Exec(`INSERT INTO sessions (stream_id, broadcaster_id, started_at, announced_at) VALUES (?, ?, ?, ?)
+		ON CONFLICT (stream_id) DO UPDATE SET announced_at = excluded.announced_at`,
+		streamID, broadcasterID, at.Unix(), at.Unix())
+	return errors.Wrap(err, "unable to record announcement")
+}
+
+func (s *SQLite) StartSession(session Session) error {
+	_, err := s.db.Exec(`INSERT INTO sessions (stream_id, broadcaster_id, broadcaster_login, broadcaster_name, started_at, game, title)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (stream_id) DO UPDATE SET
+			broadcaster_login = excluded.broadcaster_login,
+			broadcaster_name = excluded.broadcaster_name,
+			started_at = excluded.started_at,
+			game = excluded.game,
+			title = excluded.title`,
+		session.StreamID, session.BroadcasterID, session.BroadcasterLogin, session.BroadcasterName,
+		session.StartedAt.Unix(), session.Game, session.Title)
+	return errors.Wrap(err, "unable to record stream session")
+}
+
+func (s *SQLite) EndSession(broadcasterID string, endedAt time.Time) (Session, bool, error) {
+	sessions, err := s.query(`WHERE broadcaster_id = ? AND ended_at IS NULL ORDER BY started_at DESC LIMIT 1`, broadcasterID)
+	if err != nil || len(sessions) == 0 {
+		return Session{}, false, err
+	}
+
+	session := sessions[0]
+	session.EndedAt = endedAt.UTC().Truncate(time.Second)
+	// anything older that never saw an offline (we were down) is over too
+	_, err = s.db.Exec(`UPDATE sessions SET ended_at = ? WHERE broadcaster_id = ? AND ended_at IS NULL`, endedAt.Unix(), broadcasterID)
+	if err != nil {
+		return Session{}, false, errors.Wrap(err, "unable to end stream session")
+	}
+	return session, true, nil
+}
+
+func (s *SQLite) Session(streamID string) (Session, bool, error) {
+	sessions, err := s.query(`WHERE stream_id = ?`, streamID)
+	if err != nil || len(sessions) == 0 {
+		return Session{}, false, err
+	}
+	return sessions[0], true, nil
+}
+
+func (s *SQLite) Sessions(broadcasterID string, since time.Time) ([]Session, error) {
+	if len(broadcasterID) == 0 {
+		return s.query(`WHERE started_at >= ? ORDER BY started_at DESC`, since.Unix())
+	}
+	return s.query(`WHERE broadcaster_id = ? AND started_at >= ? ORDER BY started_at DESC`, broadcasterID, since.Unix())
+}
+
+func (s *SQLite) query(where string, args ...interface{}) ([]Session, error) {
+	rows, err := s.db.Query(`SELECT stream_id, broadcaster_id, broadcaster_login, broadcaster_name, started_at, ended_at, game, title, announced_at,
+		peak_viewers, viewer_total, viewer_samples, last_sampled_at
+		FROM sessions `+where, args...)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to fetch stream sessions")
+	}
+	defer rows.Close()
+
+	sessions := []Session{}
+	for rows.Next() {
+		var session Session
+		var started int64
+		var ended, announced, sampled sql.NullInt64
+		var viewerTotal, viewerSamples int
+		err := rows.Scan(&session.StreamID, &session.BroadcasterID, &session.BroadcasterLogin, &session.BroadcasterName,
+			&started, &ended, &session.Game, &session.Title, &announced,
+			&session.PeakViewers, &viewerTotal, &viewerSamples, &sampled)
+		if err != nil {
+			return nil, errors.Wrap(err, "unable to read stream session")
+		}
+		if viewerSamples != 0 {
+			session.AverageViewers = (viewerTotal + viewerSamples/2) / viewerSamples
+		}
+		session.StartedAt = time.Unix(started, 0).UTC()
+		session.EndedAt = fromUnix(ended)
+		session.AnnouncedAt = fromUnix(announced)
+		session.LastSampledAt = fromUnix(sampled)
+		sessions = append(sessions, session)
+	}
+	return sessions, errors.Wrap(rows.Err(), "unable to read stream sessions")
+}
+
+func (s *SQLite) RecordViewers(streamID string, viewers int, at time.Time) error {
+	_, err := s.db.Exec(`UPDATE sessions SET peak_viewers = MAX(peak_viewers, ?), viewer_total = viewer_total + ?, viewer_samples = viewer_samples + 1,
+		last_sampled_at = ? WHERE stream_id = ?`, viewers, viewers, at.Unix(), streamID)
+	return errors.Wrap(err, "unable to record viewers")
+}
+
+func (s *SQLite) RecordChange(change StreamChange) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return errors.Wrap(err, "unable to start recording stream change")
+	}
+	_, err = tx.Exec(`INSERT INTO stream_changes (stream_id, at, game, title) VALUES (?, ?, ?, ?)`,
+		change.StreamID, change.At.Unix(), change.Game, change.Title)
+	if err != nil {
+		_ = tx.Rollback()
+		return errors.Wrap(err, "unable to record stream change")
+	}
+	if _, err := tx.Exec(`UPDATE sessions SET game = ?, title = ? WHERE stream_id = ?`, change.Game, change.Title, change.StreamID); err != nil {
+		_ = tx.Rollback()
+		return errors.Wrap(err, "unable to update stream session")
+	}
+	return errors.Wrap(tx.Commit(), "unable to record stream change")
+}
+
+func (s *SQLite) Changes(streamID string) ([]StreamChange, error) {
+	rows, err := s.db.Query(`SELECT stream_id, at, game, title FROM stream_changes WHERE stream_id = ? ORDER BY at, rowid`, streamID)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to fetch stream changes")
+	}
+	defer rows.Close()
+
+	changes := []StreamChange{}
+	for rows.Next() {
+		var change StreamChange
+		var at int64
+		if err := rows.Scan(&change.StreamID, &at, &change.Game, &change.Title); err != nil {
+			return nil, errors.Wrap(err, "unable to read stream change")
+		}
+		change.At = time.Unix(at, 0).UTC()
+		changes = append(changes, change)
+	}
+	return changes, errors.Wrap(rows.Err(), "unable to read stream changes")
+}
+
+func (s *SQLite) RecordRaid(raid Raid) (Raid, error) {
+	result, err := s.db.Exec(`INSERT INTO raids (chain_id, from_broadcaster_id, from_login, from_name, to_broadcaster_id, to_login, to_name, viewers, at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		raid.ChainID, raid.FromBroadcasterID, raid.FromLogin, raid.FromName,
+		raid.ToBroadcasterID, raid.ToLogin, raid.ToName, raid.Viewers, raid.At.Unix())
+	if err != nil {
+		return raid, errors.Wrap(err, "unable to record raid")
+	}
+	if raid.ID, err = result.LastInsertId(); err != nil {
+		return raid, errors.Wrap(err, "unable to record raid")
+	}
+
+	if raid.ChainID == 0 {
+		raid.ChainID = raid.ID
+		if _, err := s.db.Exec(`UPDATE raids SET chain_id = id WHERE id = ?`, raid.ID); err != nil {
+			return raid, errors.Wrap(err, "unable to start raid chain")
+		}
+	}
+	raid.At = raid.At.UTC().Truncate(time.Second)
+	return raid, nil
+}
+
+func (s *SQLite) LastRaidTo(broadcasterID string, since time.Time) (Raid, bool, error) {
+	raids, err := s.raids(`WHERE to_broadcaster_id = ? AND at >= ? ORDER BY at DESC, id DESC LIMIT 1`, broadcasterID, since.Unix())
+	if err != nil || len(raids) == 0 {
+		return Raid{}, false, err
+	}
+	return raids[0], true, nil
+}
+
+func (s *SQLite) Raids(since time.Time) ([]Raid, error) {
+	return s.raids(`WHERE at >= ? ORDER BY at, id`, since.Unix())
+}
+
+func (s *SQLite) RaidChain(chainID int64) ([]Raid, error) {
+	return s.raids(`WHERE chain_id = ? ORDER BY at, id`, chainID)
+}
+
+func (s *SQLite) raids(where string, args ...interface{}) ([]Raid, error) {
+	rows, err := s.db.Query(`SELECT id, chain_id, from_broadcaster_id, from_login, from_name, to_broadcaster_id, to_login, to_name, viewers, at
+		FROM raids `+where, args...)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to fetch raids")
+	}
+	defer rows.Close()
+
+	raids := []Raid{}
+	for rows.Next() {
+		var raid Raid
+		var at int64
+		err := rows.Scan(&raid.ID, &raid.ChainID, &raid.FromBroadcasterID, &raid.FromLogin, &raid.FromName,
+			&raid.ToBroadcasterID, &raid.ToLogin, &raid.ToName, &raid.Viewers, &at)
+		if err != nil {
+			return nil, errors.Wrap(err, "unable to read raid")
+		}
+		raid.At = time.Unix(at, 0).UTC()
+		raids = append(raids, raid)
+	}
+	return raids, errors.Wrap(rows.Err(), "unable to read raids")
+}
+
+func (s *SQLite) SaveAuthorization(auth Authorization) error {
+	_, err := s.db.Exec(`INSERT INTO authorizations (broadcaster_id, login, access_token, refresh_token, scopes, expires_at, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (broadcaster_id) DO UPDATE SET
+			login = excluded.login,
+			access_token = excluded.access_token,
+			refresh_token = excluded.refresh_token,
+			scopes = excluded.scopes,
+			expires_at = excluded.expires_at,
+			updated_at = excluded.updated_at`,
+		auth.BroadcasterID, auth.Login, auth.AccessToken, auth.RefreshToken, strings.Join(auth.Scopes, " "),
+		unix(auth.ExpiresAt), time.Now().Unix())
+	return errors.Wrap(err, "unable to save authorization")
+}
+
+func (s *SQLite) Authorization(broadcasterID string) (Authorization, bool, error) {
+	auths, err := s.authorizations(`WHERE broadcaster_id = ?`, broadcasterID)
+	if err != nil || len(auths) == 0 {
+		return Authorization{}, false, err
+	}
+	return auths[0], true, nil
+}
+
+func (s *SQLite) Authorizations() ([]Authorization, error) {
+	return s.authorizations(`ORDER BY login`)
+}
+
+func (s *SQLite) authorizations(where string, args ...interface{}) ([]Authorization, error) {
+	rows, err := s.db.Query(`SELECT broadcaster_id, login, access_token, refresh_token, scopes, expires_at, updated_at
+		FROM authorizations `+where, args...)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to fetch authorizations")
+	}
+	defer rows.Close()
+
+	auths := []Authorization{}
+	for rows.Next() {
+		var auth Authorization
+		var scopes string
+		var expires sql.NullInt64
+		var updated int64
+		err := rows.Scan(&auth.BroadcasterID, &auth.Login, &auth.AccessToken, &auth.RefreshToken, &scopes, &expires, &updated)
+		if err != nil {
+			return nil, errors.Wrap(err, "unable to read authorization")
+		}
+		auth.Scopes = strings.Fields(scopes)
+		auth.ExpiresAt = fromUnix(expires)
+		auth.UpdatedAt = time.Unix(updated, 0).UTC()
+		auths = append(auths, auth)
+	}
+	return auths, errors.Wrap(rows.Err(), "unable to read authorizations")
+}
+
+func (s *SQLite) DeleteAuthorization(broadcasterID string) error {
+	_, err := s.db.Exec(`DELETE FROM authorizations WHERE broadcaster_id = ?`, broadcasterID)
+	return errors.Wrap(err, "unable to delete authorization")
+}
+
+func (s *SQLite) Milestone(broadcasterID string, kind string) (Milestone, bool, error) {
+	milestone := Milestone{BroadcasterID: broadcasterID, Kind: kind}
+	err := s.db.QueryRow(`SELECT total, announced FROM milestones WHERE broadcaster_id = ? AND kind = ?`, broadcasterID, kind).
+		Scan(&milestone.Total, &milestone.Announced)
+	if err == sql.ErrNoRows {
+		return milestone, false, nil
+	}
+	if err != nil {
+		return milestone, false, errors.Wrap(err, "unable to fetch milestone")
+	}
+	return milestone, true, nil
+}
+
+func (s *SQLite) SaveMilestone(milestone Milestone) error {
+	_, err := s.db.Exec(`INSERT INTO milestones (broadcaster_id, kind, total, announced) VALUES (?, ?, ?, ?)
+		ON CONFLICT (broadcaster_id, kind) DO UPDATE SET total = excluded.total, announced = excluded.announced`,
+		milestone.BroadcasterID, milestone.Kind, milestone.Total, milestone.Announced)
+	return errors.Wrap(err, "unable to save milestone")
+}
+
+func (s *SQLite) Reminded(segmentID string, startsAt time.Time) bool {
+	var sent int64
+	err := s.db.QueryRow(`SELECT sent_at FROM reminders WHERE segment_id = ? AND starts_at = ?`, segmentID, startsAt.Unix()).Scan(&sent)
+	if err != nil && err != sql.ErrNoRows {
+		log.Error(errors.Wrap(err, "unable to check reminder history"))
+	}
+	return err == nil
+}
+
+func (s *SQLite) MarkReminded(segmentID string, startsAt time.Time, at time.Time) error {
+	_, err := s.db.Exec(`INSERT INTO reminders (segment_id, starts_at, sent_at) VALUES (?, ?, ?)
+		ON CONFLICT (segment_id, starts_at) DO UPDATE SET sent_at = excluded.sent_at`,
+		segmentID, startsAt.Unix(), at.Unix())
+	return errors.Wrap(err, "unable to record reminder")
+}
+
+func (s *SQLite) WebhookSecret(notificationURL string) (string, bool, error) {
+	var secret string
+	err := s.db.QueryRow(`SELECT secret FROM webhook_secrets WHERE notification_url = ?`, notificationURL).Scan(&secret)
+	if err == sql.ErrNoRows {
+		return "", false, nil
+	}
+	if err != nil {
+		return "", false, errors.Wrap(err, "unable to fetch webhook secret")
+	}
+	return secret, true, nil
+}
+
+func (s *SQLite) SaveWebhookSecret(notificationURL string, secret string) error {
+	_, err := s.db.Exec(`INSERT INTO webhook_secrets (notification_url, secret) VALUES (?, ?)
+		ON CONFLICT (notification_url) DO UPDATE SET secret = excluded.secret`,
+		notificationURL, secret)
+	return errors.Wrap(err, "unable to save webhook secret")
+}
+
+func (s *SQLite) RecordAnnouncement(announcement Announcement) error {
+	_, err := s.db.Exec(`INSERT INTO announcements (stream_id, destination, message_id, sent_at) VALUES (?, ?, ?, ?)
+		ON CONFLICT (stream_id, destination) DO UPDATE SET message_id = excluded.message_id, sent_at = excluded.sent_at`,
+		announcement.StreamID, announcement.Destination, announcement.MessageID, announcement.SentAt.Unix())
+	return errors.Wrap(err, "unable to record announcement")
+}
+
+func (s *SQLite) Announcements(streamID string) ([]Announcement, error) {
+	rows, err := s.db.Query(`SELECT stream_id, destination, message_id, sent_at FROM announcements WHERE stream_id = ? ORDER BY sent_at`, streamID)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to fetch announcements")
+	}
+	defer rows.Close()
+
+	announcements := []Announcement{}
+	for rows.Next() {
+		var announcement Announcement
+		var sent int64
+		if err := rows.Scan(&announcement.StreamID, &announcement.Destination, &announcement.MessageID, &sent); err != nil {
+			return nil, errors.Wrap(err, "unable to read announcement")
+		}
+		announcement.SentAt = time.Unix(sent, 0).UTC()
+		announcements = append(announcements, announcement)
+	}
+	return announcements, errors.Wrap(rows.Err(), "unable to read announcements")
+}
+
+func (s *SQLite) ReplaceSubscriptions(subs []Subscription) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return errors.Wrap(err, "unable to start saving subscriptions")
+	}
+	if _, err := tx.Exec(`DELETE FROM subscriptions`); err != nil {
+		_ = tx.Rollback()
+		return errors.Wrap(err, "unable to clear subscriptions")
+	}
+	for _, sub := range subs {
+		_, err := tx.Exec(`INSERT INTO subscriptions (id, type, version, broadcaster_id, status, method, created_at, updated_at)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+			sub.ID, sub.Type, sub.Version, sub.BroadcasterID, sub.Status, sub.Method, sub.CreatedAt.Unix(), sub.UpdatedAt.Unix())
+		if err != nil {
+			_ = tx.Rollback()
+			return errors.Wrap(err, "unable to save subscription")
+		}
+	}
+	return errors.Wrap(tx.Commit(), "unable to save subscriptions")
+}
+
+func (s *SQLite) UpdateSubscriptionStatus(id string, status string) error {
+	_, err := s.db.Exec(`UPDATE subscriptions SET status = ?, updated_at = ? WHERE id = ?`, status, time.Now().Unix(), id)
+	return errors.Wrap(err, "unable to update subscription")
+}
+
+func (s *SQLite) Subscriptions() ([]Subscription, error) {
+	rows, err := s.db.Query(`SELECT id, type, version, broadcaster_id, status, method, created_at, updated_at FROM subscriptions ORDER BY broadcaster_id, type`)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to fetch subscriptions")
+	}
+	defer rows.Close()
+
+	subs := []Subscription{}
+	for rows.Next() {
+		var sub Subscription
+		var created, updated int64
+		if err := rows.Scan(&sub.ID, &sub.Type, &sub.Version, &sub.BroadcasterID, &sub.Status, &sub.Method, &created, &updated); err != nil {
+			return nil, errors.Wrap(err, "unable to read subscription")
+		}
+		sub.CreatedAt = time.Unix(created, 0).UTC()
+		sub.UpdatedAt = time.Unix(updated, 0).UTC()
+		subs = append(subs, sub)
+	}
+	return subs, errors.Wrap(rows.Err(), "unable to read subscriptions")
+}
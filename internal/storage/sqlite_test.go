@@ -0,0 +1,235 @@
+package storage
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestSQLite(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "storage.db")
+	s, err := OpenSQLite(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	started := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	if s.Announced("stream1") {
+		t.Errorf("stream1 announced before anything happened")
+	}
+	if err := s.StartSession(Session{StreamID: "stream1", BroadcasterID: "1", BroadcasterLogin: "halkeye", StartedAt: started, Game: "Art"}); err != nil {
+		t.Fatal(err)
+	}
+	if s.Announced("stream1") {
+		t.Errorf("stream1 announced before MarkAnnounced")
+	}
+	if err := s.MarkAnnounced("stream1", "1", started); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.RecordAnnouncement(Announcement{StreamID: "stream1", Destination: "https://discord/hook", MessageID: "99", SentAt: started}); err != nil {
+		t.Fatal(err)
+	}
+
+	// reopening keeps everything
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	s, err = OpenSQLite(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !s.Announced("stream1") {
+		t.Errorf("stream1 not announced after a restart")
+	}
+
+	session, ok, err := s.EndSession("1", started.Add(time.Hour))
+	if err != nil || !ok {
+		t.Fatalf("EndSession() = %v, %v", ok, err)
+	}
+	if session.StreamID != "stream1" || session.Game != "Art" || session.EndedAt.Sub(session.StartedAt) != time.Hour {
+		t.Errorf("EndSession() = %+v", session)
+	}
+	if _, ok, _ := s.EndSession("1", started.Add(2*time.Hour)); ok {
+		t.Errorf("EndSession() ended a stream twice")
+	}
+
+	announcements, err := s.Announcements("stream1")
+	if err != nil || len(announcements) != 1 || announcements[0].MessageID != "99" {
+		t.Errorf("Announcements() = %+v, %v", announcements, err)
+	}
+
+	sessions, err := s.Sessions("", started.Add(-time.Minute))
+	if err != nil || len(sessions) != 1 || sessions[0].EndedAt.IsZero() {
+		t.Errorf("Sessions() = %+v, %v", sessions, err)
+	}
+
+	if err := s.ReplaceSubscriptions([]Subscription{{ID: "sub1", Type: "stream.online", Status: "enabled"}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.UpdateSubscriptionStatus("sub1", "authorization_revoked"); err != nil {
+		t.Fatal(err)
+	}
+	subs, err := s.Subscriptions()
+	if err != nil || len(subs) != 1 || subs[0].Status != "authorization_revoked" {
+		t.Errorf("Subscriptions() = %+v, %v", subs, err)
+	}
+}
+
+func TestSQLiteSamples(t *testing.T) {
+	s, err := OpenSQLite(filepath.Join(t.TempDir(), "storage.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	started := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	if err := s.StartSession(Session{StreamID: "stream1", BroadcasterID: "1", StartedAt: started, Game: "Art", Title: "drawing"}); err != nil {
+		t.Fatal(err)
+	}
+	for i, viewers := range []int{10, 40, 25} {
+		if err := s.RecordViewers("stream1", viewers, started.Add(time.Duration(i)*time.Minute)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.RecordChange(StreamChange{StreamID: "stream1", At: started.Add(time.Hour), Game: "Chess", Title: "drawing"}); err != nil {
+		t.Fatal(err)
+	}
+
+	session, ok, err := s.Session("stream1")
+	if err != nil || !ok {
+		t.Fatalf("Session() = %v, %v", ok, err)
+	}
+	if session.PeakViewers != 40 || session.AverageViewers != 25 || session.Game != "Chess" || !session.LastSampledAt.Equal(started.Add(2*time.Minute)) {
+		t.Errorf("Session() = %+v; want 40 peak, 25 average, playing Chess, last sampled at the third sample", session)
+	}
+
+	changes, err := s.Changes("stream1")
+	if err != nil || len(changes) != 1 || changes[0].Game != "Chess" || !changes[0].At.Equal(started.Add(time.Hour)) {
+		t.Errorf("Changes() = %+v, %v", changes, err)
+	}
+}
+
+func TestSQLiteRaids(t *testing.T) {
+	s, err := OpenSQLite(filepath.Join(t.TempDir(), "storage.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	at := time.Date(2024, 1, 2, 20, 0, 0, 0, time.UTC)
+	first, err := s.RecordRaid(Raid{FromBroadcasterID: "1", ToBroadcasterID: "2", Viewers: 10, At: at})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first.ID == 0 || first.ChainID != first.ID {
+		t.Errorf("RecordRaid() = %+v; want it to start its own chain", first)
+	}
+
+	last, ok, err := s.LastRaidTo("2", at.Add(-time.Hour))
+	if err != nil || !ok || last.ID != first.ID {
+		t.Fatalf("LastRaidTo() = %+v, %v, %v", last, ok, err)
+	}
+	if _, ok, _ := s.LastRaidTo("2", at.Add(time.Hour)); ok {
+		t.Errorf("LastRaidTo() found a raid from before since")
+	}
+
+	second, err := s.RecordRaid(Raid{ChainID: last.ChainID, FromBroadcasterID: "2", ToBroadcasterID: "3", Viewers: 12, At: at.Add(2 * time.Hour)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if second.ChainID != first.ID {
+		t.Errorf("RecordRaid() = %+v; want it to continue chain %d", second, first.ID)
+	}
+
+	raids, err := s.Raids(at)
+	if err != nil || len(raids) != 2 || raids[0].ID != first.ID || raids[1].ChainID != first.ID || !raids[1].At.Equal(at.Add(2*time.Hour)) {
+		t.Errorf("Raids() = %+v, %v", raids, err)
+	}
+	if _, err := s.RecordRaid(Raid{FromBroadcasterID: "9", ToBroadcasterID: "8", At: at}); err != nil {
+		t.Fatal(err)
+	}
+	if chain, err := s.RaidChain(first.ID); err != nil || len(chain) != 2 || chain[1].ID != second.ID {
+		t.Errorf("RaidChain() = %+v, %v", chain, err)
+	}
+}
+
+func TestSQLiteAuthorizations(t *testing.T) {
+	s, err := OpenSQLite(filepath.Join(t.TempDir(), "storage.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	expires := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	auth := Authorization{BroadcasterID: "1", Login: "halkeye", AccessToken: "a", RefreshToken: "r", Scopes: []string{"bits:read", "moderator:read:followers"}, ExpiresAt: expires}
+	if err := s.SaveAuthorization(auth); err != nil {
+		t.Fatal(err)
+	}
+	auth.AccessToken = "b"
+	if err := s.SaveAuthorization(auth); err != nil {
+		t.Fatal(err)
+	}
+
+	saved, ok, err := s.Authorization("1")
+	if err != nil || !ok || saved.AccessToken != "b" || len(saved.Scopes) != 2 || !saved.ExpiresAt.Equal(expires) {
+		t.Errorf("Authorization() = %+v, %v, %v", saved, ok, err)
+	}
+	if err := s.DeleteAuthorization("1"); err != nil {
+		t.Fatal(err)
+	}
+	if auths, err := s.Authorizations(); err != nil || len(auths) != 0 {
+		t.Errorf("Authorizations() = %+v, %v; want nothing left", auths, err)
+	}
+
+	if _, ok, err := s.Milestone("1", "followers"); ok || err != nil {
+		t.Errorf("Milestone() = %v, %v; want nothing yet", ok, err)
+	}
+	if err := s.SaveMilestone(Milestone{BroadcasterID: "1", Kind: "followers", Total: 205, Announced: 200}); err != nil {
+		t.Fatal(err)
+	}
+	if milestone, ok, err := s.Milestone("1", "followers"); !ok || err != nil || milestone.Total != 205 || milestone.Announced != 200 {
+		t.Errorf("Milestone() = %+v, %v, %v", milestone, ok, err)
+	}
+
+}
+
+func TestSQLiteReminders(t *testing.T) {
+	s, err := OpenSQLite(filepath.Join(t.TempDir(), "storage.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	starts := time.Date(2024, 1, 2, 19, 0, 0, 0, time.UTC)
+	if s.Reminded("segment1", starts) {
+		t.Errorf("segment1 reminded before MarkReminded")
+	}
+	if err := s.MarkReminded("segment1", starts, starts.Add(-15*time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	if !s.Reminded("segment1", starts) || s.Reminded("segment1", starts.Add(7*24*time.Hour)) {
+		t.Errorf("Reminded() should only remember segment1 on the day it was reminded about")
+	}
+}
+
+func TestSQLiteWebhookSecrets(t *testing.T) {
+	s, err := OpenSQLite(filepath.Join(t.TempDir(), "storage.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	url := "https://example.com/webhook/airtable"
+	if _, ok, err := s.WebhookSecret(url); ok || err != nil {
+		t.Errorf("WebhookSecret() = %v, %v; want nothing saved yet", ok, err)
+	}
+	for _, secret := range []string{"first", "second"} {
+		if err := s.SaveWebhookSecret(url, secret); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if secret, ok, err := s.WebhookSecret(url); !ok || err != nil || secret != "second" {
+		t.Errorf("WebhookSecret() = %q, %v, %v; want the newest secret", secret, ok, err)
+	}
+}
@@ -0,0 +1,156 @@
+package storage
+
+import (
+	"strings"
+	"time"
+)
+
+// Session is one stream, from going live to going offline.
+type Session struct {
+	StreamID         string
+	BroadcasterID    string
+	BroadcasterLogin string
+	BroadcasterName  string
+	StartedAt        time.Time
+	// EndedAt is zero while the stream is live
+	EndedAt time.Time
+	Game    string
+	Title   string
+	// AnnouncedAt is zero until the stream has been announced (or deliberately skipped)
+	AnnouncedAt time.Time
+	// PeakViewers and AverageViewers are zero until the stream has been sampled
+	PeakViewers    int
+	AverageViewers int
+	// LastSampledAt is zero until the stream has been sampled
+	LastSampledAt time.Time
+}
+
+// StreamChange is the game or title of a live stream changing, the first sample of a stream counts as one too.
+type StreamChange struct {
+	StreamID string
+	At       time.Time
+	Game     string
+	Title    string
+}
+
+// Announcement is one message sent about a stream, per destination so it can be edited later.
+type Announcement struct {
+	StreamID string
+	// Destination is the discord webhook the message went to
+	Destination string
+	// MessageID is discord's id for the message
+	MessageID string
+	SentAt    time.Time
+}
+
+// Raid is one channel sending its viewers to another.
+type Raid struct {
+	ID int64
+	// ChainID is the ID of the raid that started the raid train this one is part of
+	ChainID           int64
+	FromBroadcasterID string
+	FromLogin         string
+	FromName          string
+	ToBroadcasterID   string
+	ToLogin           string
+	ToName            string
+	Viewers           int
+	At                time.Time
+}
+
+// Authorization is a broadcaster letting us use their token. The store keeps the tokens exactly as it is
+// handed them, so encrypt them first.
+type Authorization struct {
+	BroadcasterID string
+	Login         string
+	AccessToken   string
+	RefreshToken  string
+	Scopes        []string
+	// ExpiresAt is when AccessToken stops working, zero when we don't know
+	ExpiresAt time.Time
+	UpdatedAt time.Time
+}
+
+// Milestone is how far a broadcaster has got with one kind of milestone (followers, bits, ...).
+type Milestone struct {
+	BroadcasterID string
+	Kind          string
+	Total         int
+	// Announced is the last milestone that was announced
+	Announced int
+}
+
+// Subscription is what twitch last told us about one of our eventsub subscriptions.
+type Subscription struct {
+	ID            string
+	Type          string
+	Version       string
+	BroadcasterID string
+	Status        string
+	Method        string
+	CreatedAt     time.Time
+	UpdatedAt     time.Time
+}
+
+// Store keeps everything that has to survive a restart.
+type Store interface {
+	// Announced and MarkAnnounced dedupe announcements across restarts
+	Announced(streamID string) bool
+	MarkAnnounced(streamID string, broadcasterID string, at time.Time) error
+
+	// StartSession records a stream going live, or updates it if it is already known
+	StartSession(session Session) error
+	// EndSession ends whichever of broadcasterID's streams is still open, and returns it
+	EndSession(broadcasterID string, endedAt time.Time) (Session, bool, error)
+	Session(streamID string) (Session, bool, error)
+	// Sessions returns broadcasterID's streams that started since, newest first. An empty broadcasterID is everyone.
+	Sessions(broadcasterID string, since time.Time) ([]Session, error)
+
+	// RecordViewers adds a viewer count sample, taken at at, to a live stream
+	RecordViewers(streamID string, viewers int, at time.Time) error
+	// RecordChange remembers a stream switching game or title, and makes it the session's current one
+	RecordChange(change StreamChange) error
+	// Changes returns a stream's game and title changes, oldest first
+	Changes(streamID string) ([]StreamChange, error)
+
+	// RecordRaid saves raid, and returns it with its ID filled in. A zero ChainID starts a new chain.
+	RecordRaid(raid Raid) (Raid, error)
+	// LastRaidTo returns the newest raid of broadcasterID since, it is what their own raid continues
+	LastRaidTo(broadcasterID string, since time.Time) (Raid, bool, error)
+	// Raids returns the raids since, oldest first
+	Raids(since time.Time) ([]Raid, error)
+	// RaidChain returns the raids of a raid train, oldest first
+	RaidChain(chainID int64) ([]Raid, error)
+
+	// SaveAuthorization adds or replaces the broadcaster's authorization
+	SaveAuthorization(auth Authorization) error
+	Authorization(broadcasterID string) (Authorization, bool, error)
+	Authorizations() ([]Authorization, error)
+	DeleteAuthorization(broadcasterID string) error
+
+	Milestone(broadcasterID string, kind string) (Milestone, bool, error)
+	SaveMilestone(milestone Milestone) error
+
+	// Reminded and MarkReminded dedupe schedule reminders across restarts, startsAt tells a recurring segment's days apart
+	Reminded(segmentID string, startsAt time.Time) bool
+	MarkReminded(segmentID string, startsAt time.Time, at time.Time) error
+
+	// WebhookSecret and SaveWebhookSecret keep the secrets of webhooks that only hand theirs out once, by where they notify
+	WebhookSecret(notificationURL string) (string, bool, error)
+	SaveWebhookSecret(notificationURL string, secret string) error
+
+	RecordAnnouncement(announcement Announcement) error
+	Announcements(streamID string) ([]Announcement, error)
+
+	// ReplaceSubscriptions swaps the known subscriptions for subs
+	ReplaceSubscriptions(subs []Subscription) error
+	UpdateSubscriptionStatus(id string, status string) error
+	Subscriptions() ([]Subscription, error)
+
+	Close() error
+}
+
+// Open opens the store at dsn. Only sqlite exists for now, dsn is the path of the database.
+func Open(dsn string) (Store, error) {
+	return OpenSQLite(strings.TrimPrefix(dsn, "sqlite://"))
+}
@@ -0,0 +1,69 @@
+package tokens
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+
+	"github.com/pkg/errors"
+)
+
+// KeySize is the length of the AES-256 key tokens are encrypted with.
+const KeySize = 32
+
+// Cipher encrypts tokens with AES-GCM before they are stored.
+type Cipher struct {
+	aead cipher.AEAD
+}
+
+// ParseKey reads a KeySize byte key written as hex or base64.
+func ParseKey(key string) ([]byte, error) {
+	if decoded, err := hex.DecodeString(key); err == nil && len(decoded) == KeySize {
+		return decoded, nil
+	}
+	for _, encoding := range []*base64.Encoding{base64.StdEncoding, base64.RawStdEncoding, base64.URLEncoding, base64.RawURLEncoding} {
+		if decoded, err := encoding.DecodeString(key); err == nil && len(decoded) == KeySize {
+			return decoded, nil
+		}
+	}
+	return nil, errors.Errorf("key must be %d bytes, written as hex or base64", KeySize)
+}
+
+func NewCipher(key []byte) (*Cipher, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid key")
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to create cipher")
+	}
+	return &Cipher{aead: aead}, nil
+}
+
+// Seal encrypts plaintext, the nonce goes in front. The result is url safe.
+func (c *Cipher) Seal(plaintext string) (string, error) {
+	nonce := make([]byte, c.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", errors.Wrap(err, "unable to create nonce")
+	}
+	return base64.RawURLEncoding.EncodeToString(c.aead.Seal(nonce, nonce, []byte(plaintext), nil)), nil
+}
+
+// Open decrypts something Seal made, failing if it was tampered with or sealed with another key.
+func (c *Cipher) Open(sealed string) (string, error) {
+	data, err := base64.RawURLEncoding.DecodeString(sealed)
+	if err != nil {
+		return "", errors.Wrap(err, "invalid sealed value")
+	}
+	if len(data) < c.aead.NonceSize() {
+		return "", errors.New("sealed value is too short")
+	}
+	plaintext, err := c.aead.Open(nil, data[:c.aead.NonceSize()], data[c.aead.NonceSize():], nil)
+	if err != nil {
+		return "", errors.Wrap(err, "unable to decrypt")
+	}
+	return string(plaintext), nil
+}
@@ -0,0 +1,135 @@
+package tokens
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	helix "github.com/nicklaw5/helix/v2"
+
+	"github.com/halkeye/twitch_go_online/internal/storage"
+)
+
+func testCipher(t *testing.T) *Cipher {
+	key, err := ParseKey(hex.EncodeToString([]byte(strings.Repeat("k", KeySize))))
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := NewCipher(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func TestCipher(t *testing.T) {
+	if _, err := ParseKey("too short"); err == nil {
+		t.Errorf("ParseKey() accepted a short key")
+	}
+
+	c := testCipher(t)
+	sealed, err := c.Seal("secret token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(sealed, "secret") {
+		t.Errorf("Seal() = %q; want it encrypted", sealed)
+	}
+	if opened, err := c.Open(sealed); err != nil || opened != "secret token" {
+		t.Errorf("Open() = %q, %v", opened, err)
+	}
+
+	tampered := []byte(sealed)
+	tampered[len(tampered)-1] ^= 1
+	if _, err := c.Open(string(tampered)); err == nil {
+		t.Errorf("Open() accepted a tampered value")
+	}
+}
+
+// rewrite sends every request, id.twitch.tv ones included, to the test server
+type rewrite struct{ target *url.URL }
+
+func (r rewrite) RoundTrip(req *http.Request) (*http.Response, error) {
+	req.URL.Scheme, req.URL.Host = r.target.Scheme, r.target.Host
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+func TestVaultRefreshesTokens(t *testing.T) {
+	var refreshed []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, "/token") {
+			refreshed = append(refreshed, r.URL.Query().Get("refresh_token"))
+			_ = json.NewEncoder(w).Encode(map[string]interface{}{"access_token": "new-access", "refresh_token": "new-refresh", "expires_in": 3600})
+			return
+		}
+		if r.Header.Get("Authorization") != "Bearer new-access" {
+			w.WriteHeader(http.StatusUnauthorized)
+			_, _ = w.Write([]byte(`{"error":"Unauthorized","status":401,"message":"Invalid OAuth token"}`))
+			return
+		}
+		_, _ = w.Write([]byte(`{"data":[],"total":42}`))
+	}))
+	defer srv.Close()
+	target, _ := url.Parse(srv.URL)
+
+	store, err := storage.OpenSQLite(filepath.Join(t.TempDir(), "storage.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	c := testCipher(t)
+	vault := NewVault(store, c, helix.Options{ClientID: "id", ClientSecret: "secret", HTTPClient: &http.Client{Transport: rewrite{target}}})
+	// twitch rejects it, so helix refreshes it
+	err = vault.Save("1", "halkeye", helix.AccessCredentials{AccessToken: "old-access", RefreshToken: "old-refresh", Scopes: []string{"bits:read"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	auth, _, _ := store.Authorization("1")
+	if strings.Contains(auth.AccessToken, "old-access") || strings.Contains(auth.RefreshToken, "old-refresh") {
+		t.Errorf("tokens were stored in the clear: %+v", auth)
+	}
+
+	client, err := vault.Client("1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := client.GetChannelFollows(&helix.GetChannelFollowsParams{BroadcasterID: "1", First: 1})
+	if err != nil || resp.Data.Total != 42 {
+		t.Fatalf("GetChannelFollows() = %+v, %v", resp, err)
+	}
+	if len(refreshed) != 1 || refreshed[0] != "old-refresh" {
+		t.Errorf("refreshed with %q; want old-refresh once", refreshed)
+	}
+
+	auth, _, _ = store.Authorization("1")
+	if access, _ := c.Open(auth.AccessToken); access != "new-access" || len(auth.Scopes) != 1 {
+		t.Errorf("saved authorization = %+v (%s); want new-access keeping its scopes", auth, access)
+	}
+
+	// known to have expired, so it's refreshed before it is used
+	auth.ExpiresAt = time.Now().Add(-time.Minute)
+	if err := vault.save(auth, "old-access", "other-refresh"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := vault.Client("1"); err != nil {
+		t.Fatal(err)
+	}
+	if len(refreshed) != 2 || refreshed[1] != "other-refresh" {
+		t.Errorf("refreshed with %q; want other-refresh the second time", refreshed)
+	}
+	if auth, _, _ = store.Authorization("1"); !auth.ExpiresAt.After(time.Now()) {
+		t.Errorf("ExpiresAt = %v; want it pushed out after the refresh", auth.ExpiresAt)
+	}
+
+	if client, err := vault.Client("2"); client != nil || err != nil {
+		t.Errorf("Client() = %v, %v for someone who never authorized us", client, err)
+	}
+}
@@ -0,0 +1,121 @@
+package tokens
+
+import (
+	"time"
+
+	helix "github.com/nicklaw5/helix/v2"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/halkeye/twitch_go_online/internal/storage"
+)
+
+// Vault keeps the tokens broadcasters authorized us with, encrypted in the store.
+type Vault struct {
+	store  storage.Store
+	cipher *Cipher
+	// options are what every broadcaster's client is created with, ClientSecret is needed to refresh tokens
+	options helix.Options
+}
+
+func NewVault(store storage.Store, cipher *Cipher, options helix.Options) *Vault {
+	return &Vault{store: store, cipher: cipher, options: options}
+}
+
+// Save encrypts and stores the tokens broadcasterID authorized us with.
+func (v *Vault) Save(broadcasterID string, login string, credentials helix.AccessCredentials) error {
+	auth := storage.Authorization{
+		BroadcasterID: broadcasterID,
+		Login:         login,
+		Scopes:        credentials.Scopes,
+	}
+	if credentials.ExpiresIn > 0 {
+		auth.ExpiresAt = time.Now().Add(time.Duration(credentials.ExpiresIn) * time.Second)
+	}
+	return v.save(auth, credentials.AccessToken, credentials.RefreshToken)
+}
+
+func (v *Vault) save(auth storage.Authorization, accessToken string, refreshToken string) error {
+	var err error
+	if auth.AccessToken, err = v.cipher.Seal(accessToken); err != nil {
+		return err
+	}
+	if auth.RefreshToken, err = v.cipher.Seal(refreshToken); err != nil {
+		return err
+	}
+	return v.store.SaveAuthorization(auth)
+}
+
+// Authorized returns the ids of the broadcasters who authorized us.
+func (v *Vault) Authorized() (map[string]bool, error) {
+	auths, err := v.store.Authorizations()
+	if err != nil {
+		return nil, err
+	}
+	authorized := map[string]bool{}
+	for _, auth := range auths {
+		authorized[auth.BroadcasterID] = true
+	}
+	return authorized, nil
+}
+
+// Delete forgets broadcasterID's tokens, they took their authorization back.
+func (v *Vault) Delete(broadcasterID string) error {
+	return v.store.DeleteAuthorization(broadcasterID)
+}
+
+// Client returns a client using broadcasterID's token, or nil when they never authorized us. An expired token
+// is refreshed first, and helix refreshes it again if twitch rejects it, either way the new tokens are saved.
+func (v *Vault) Client(broadcasterID string) (*helix.Client, error) {
+	auth, ok, err := v.store.Authorization(broadcasterID)
+	if err != nil || !ok {
+		return nil, err
+	}
+	accessToken, err := v.cipher.Open(auth.AccessToken)
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to decrypt token of %s", auth.Login)
+	}
+	refreshToken, err := v.cipher.Open(auth.RefreshToken)
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to decrypt token of %s", auth.Login)
+	}
+
+	options := v.options
+	options.UserAccessToken = accessToken
+	options.RefreshToken = refreshToken
+	client, err := helix.NewClient(&options)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to create twitch client")
+	}
+
+	if !auth.ExpiresAt.IsZero() && time.Now().After(auth.ExpiresAt) {
+		resp, err := client.RefreshUserAccessToken(refreshToken)
+		if err != nil {
+			return nil, errors.Wrapf(err, "unable to refresh token of %s", auth.Login)
+		}
+		if resp.ErrorStatus != 0 {
+			return nil, errors.Errorf("unable to refresh token of %s (%d) - %s", auth.Login, resp.ErrorStatus, resp.ErrorMessage)
+		}
+		credentials := resp.Data
+		if len(credentials.Scopes) == 0 {
+			credentials.Scopes = auth.Scopes
+		}
+		client.SetUserAccessToken(credentials.AccessToken)
+		client.SetRefreshToken(credentials.RefreshToken)
+		if err := v.Save(auth.BroadcasterID, auth.Login, credentials); err != nil {
+			return nil, err
+		}
+		log.Infof("Refreshed twitch token of %s", auth.Login)
+	}
+
+	client.OnUserAccessTokenRefreshed(func(newAccessToken, newRefreshToken string) {
+		// we aren't told when the new one expires, helix refreshes it again when twitch says so
+		auth.ExpiresAt = time.Time{}
+		if err := v.save(auth, newAccessToken, newRefreshToken); err != nil {
+			log.Error(errors.Wrapf(err, "unable to save refreshed token of %s", auth.Login))
+			return
+		}
+		log.Infof("Refreshed twitch token of %s", auth.Login)
+	})
+	return client, nil
+}
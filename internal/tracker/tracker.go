@@ -0,0 +1,144 @@
+package tracker
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	helix "github.com/nicklaw5/helix/v2"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/halkeye/twitch_go_online/internal/poller"
+	"github.com/halkeye/twitch_go_online/internal/storage"
+)
+
+// Tracker samples streams while they are live, everyone at once with GetStreams,
+// and records viewer counts and game/title changes on the stream's session.
+type Tracker struct {
+	client   *helix.Client
+	store    storage.Store
+	interval time.Duration
+
+	mu sync.Mutex
+	// broadcaster id => the stream as we last saw it
+	live map[string]helix.Stream
+}
+
+func New(client *helix.Client, store storage.Store, interval time.Duration) *Tracker {
+	return &Tracker{
+		client:   client,
+		store:    store,
+		interval: interval,
+		live:     map[string]helix.Stream{},
+	}
+}
+
+// Track starts sampling stream, which counts as the first sample.
+func (t *Tracker) Track(stream helix.Stream) {
+	t.mu.Lock()
+	previous, ok := t.live[stream.UserID]
+	t.live[stream.UserID] = stream
+	t.mu.Unlock()
+
+	if ok && previous.ID == stream.ID {
+		return
+	}
+	t.record(stream, nil)
+}
+
+// Untrack stops sampling broadcasterID's stream.
+func (t *Tracker) Untrack(broadcasterID string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.live, broadcasterID)
+}
+
+// Changed updates the game and title of broadcasterID's stream, for changes that were already recorded elsewhere.
+func (t *Tracker) Changed(broadcasterID string, game string, title string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if stream, ok := t.live[broadcasterID]; ok {
+		stream.GameName, stream.Title = game, title
+		t.live[broadcasterID] = stream
+	}
+}
+
+// Run samples every interval until ctx is cancelled.
+func (t *Tracker) Run(ctx context.Context) {
+	log.Infof("Sampling live streams every %s", t.interval)
+
+	ticker := time.NewTicker(t.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		if err := t.Sample(); err != nil {
+			log.Error(errors.Wrap(err, "unable to sample live streams"))
+		}
+	}
+}
+
+// Sample records one round of viewer counts, and any game or title that changed since the last one.
+func (t *Tracker) Sample() error {
+	t.mu.Lock()
+	userIDs := []string{}
+	for id := range t.live {
+		userIDs = append(userIDs, id)
+	}
+	t.mu.Unlock()
+	if len(userIDs) == 0 {
+		return nil
+	}
+
+	current, err := poller.FetchLive(t.client, userIDs)
+	if err != nil {
+		return err
+	}
+
+	for _, id := range userIDs {
+		stream, isLive := current[id]
+
+		t.mu.Lock()
+		previous, tracked := t.live[id]
+		// going offline, or on to a new stream, is the announcer's business
+		sameStream := tracked && isLive && previous.ID == stream.ID
+		if sameStream {
+			t.live[id] = stream
+		}
+		t.mu.Unlock()
+
+		if sameStream {
+			t.record(stream, &previous)
+		}
+	}
+	return nil
+}
+
+// record saves a sample of stream, previous is what the last sample saw.
+func (t *Tracker) record(stream helix.Stream, previous *helix.Stream) {
+	if err := t.store.RecordViewers(stream.ID, stream.ViewerCount, time.Now()); err != nil {
+		log.Error(err)
+	}
+	if previous != nil && previous.GameName == stream.GameName && previous.Title == stream.Title {
+		return
+	}
+
+	if previous != nil {
+		log.Infof("%s changed to %s: %s", stream.UserLogin, stream.GameName, stream.Title)
+	}
+	err := t.store.RecordChange(storage.StreamChange{
+		StreamID: stream.ID,
+		At:       time.Now(),
+		Game:     stream.GameName,
+		Title:    stream.Title,
+	})
+	if err != nil {
+		log.Error(err)
+	}
+}
@@ -0,0 +1,86 @@
+package tracker
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	helix "github.com/nicklaw5/helix/v2"
+
+	"github.com/halkeye/twitch_go_online/internal/storage"
+)
+
+func TestSample(t *testing.T) {
+	var mu sync.Mutex
+	live := map[string]helix.Stream{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		streams := []helix.Stream{}
+		for _, id := range r.URL.Query()["user_id"] {
+			if stream, ok := live[id]; ok {
+				streams = append(streams, stream)
+			}
+		}
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"data": streams})
+	}))
+	defer srv.Close()
+	setLive := func(stream helix.Stream) {
+		mu.Lock()
+		defer mu.Unlock()
+		live[stream.UserID] = stream
+	}
+
+	client, err := helix.NewClient(&helix.Options{ClientID: "id", APIBaseURL: srv.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	store, err := storage.OpenSQLite(filepath.Join(t.TempDir(), "storage.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	stream := helix.Stream{ID: "s1", UserID: "1", UserLogin: "halkeye", GameName: "Art", Title: "drawing", ViewerCount: 10}
+	if err := store.StartSession(storage.Session{StreamID: "s1", BroadcasterID: "1", Game: "Art", Title: "drawing"}); err != nil {
+		t.Fatal(err)
+	}
+
+	tr := New(client, store, 0)
+	tr.Track(stream)
+
+	stream.ViewerCount = 30
+	setLive(stream)
+	if err := tr.Sample(); err != nil {
+		t.Fatal(err)
+	}
+
+	stream.ViewerCount = 20
+	stream.GameName = "Chess"
+	setLive(stream)
+	if err := tr.Sample(); err != nil {
+		t.Fatal(err)
+	}
+
+	// a new stream id is someone else's problem
+	setLive(helix.Stream{ID: "s2", UserID: "1", GameName: "Music", ViewerCount: 1000})
+	if err := tr.Sample(); err != nil {
+		t.Fatal(err)
+	}
+
+	session, _, err := store.Session("s1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if session.PeakViewers != 30 || session.AverageViewers != 20 || session.Game != "Chess" {
+		t.Errorf("session = %+v; want 30 peak, 20 average, playing Chess", session)
+	}
+
+	changes, err := store.Changes("s1")
+	if err != nil || len(changes) != 2 || changes[0].Game != "Art" || changes[1].Game != "Chess" {
+		t.Errorf("Changes() = %+v, %v; want Art then Chess", changes, err)
+	}
+}
@@ -0,0 +1,107 @@
+package twitchteam
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	helix "github.com/nicklaw5/helix/v2"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/halkeye/twitch_go_online/internal/roster"
+)
+
+// Team is a roster made of everyone in a twitch team. Twitch doesn't tell anyone when a team
+// changes, so Watch goes and looks every interval.
+type Team struct {
+	client   *helix.Client
+	params   helix.GetTeamsParams
+	interval time.Duration
+
+	mu   sync.Mutex
+	last []roster.Member
+}
+
+// New looks the team up by name, or by id when name is empty.
+func New(client *helix.Client, name string, id string, interval time.Duration) *Team {
+	params := helix.GetTeamsParams{Name: name}
+	if len(name) == 0 {
+		params.ID = id
+	}
+	return &Team{
+		client:   client,
+		params:   params,
+		interval: interval,
+	}
+}
+
+// Members returns everyone in the team.
+func (t *Team) Members() ([]roster.Member, error) {
+	members, err := t.fetch()
+	if err != nil {
+		return nil, err
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.last = members
+	return members, nil
+}
+
+func (t *Team) fetch() ([]roster.Member, error) {
+	params := t.params
+	resp, err := t.client.GetTeams(&params)
+	if err != nil {
+		return nil, errors.Wrap(err, "Error getting team")
+	}
+	if resp.ErrorStatus != 0 {
+		return nil, errors.Errorf("Error getting team (%d) - %s", resp.ErrorStatus, resp.ErrorMessage)
+	}
+	if len(resp.Data.Teams) == 0 {
+		return nil, errors.Errorf("no twitch team %s%s", t.params.Name, t.params.ID)
+	}
+
+	members := []roster.Member{}
+	for _, user := range resp.Data.Teams[0].Users {
+		members = append(members, roster.Member{
+			// the team hands us user ids, so renames never look like someone leaving
+			RecordID:    user.UserID,
+			TwitchLogin: roster.NormalizeLogin(user.UserLogin),
+			Enabled:     true,
+			Tags:        []string{},
+			ResolvedID:  user.UserID,
+		})
+	}
+	return members, nil
+}
+
+// Watch refetches the team every interval until ctx is done.
+func (t *Team) Watch(ctx context.Context, onChange roster.ChangeFunc) error {
+	ticker := time.NewTicker(t.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+
+		members, err := t.fetch()
+		if err != nil {
+			log.Error(errors.Wrap(err, "unable to refresh twitch team"))
+			continue
+		}
+
+		t.mu.Lock()
+		changes := roster.Diff(t.last, members)
+		t.last = members
+		t.mu.Unlock()
+
+		if len(changes) != 0 {
+			log.WithField("changes", len(changes)).Info("twitch team changed")
+			onChange(changes, members)
+		}
+	}
+}
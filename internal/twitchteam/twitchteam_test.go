@@ -0,0 +1,33 @@
+package twitchteam
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	helix "github.com/nicklaw5/helix/v2"
+)
+
+func TestMembers(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("name") != "goldenpixels" || r.URL.Query().Has("id") {
+			t.Errorf("unexpected query %s", r.URL.RawQuery)
+		}
+		_, _ = w.Write([]byte(`{"data":[{"team_name":"goldenpixels","users":[{"user_id":"1","user_login":"halkeye","user_name":"Halkeye"}]}]}`))
+	}))
+	defer srv.Close()
+
+	client, err := helix.NewClient(&helix.Options{ClientID: "id", APIBaseURL: srv.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	members, err := New(client, "goldenpixels", "", time.Hour).Members()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(members) != 1 || members[0].TwitchLogin != "halkeye" || members[0].ResolvedID != "1" || !members[0].Enabled {
+		t.Errorf("Members() = %+v", members)
+	}
+}
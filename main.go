@@ -2,12 +2,14 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
 	"os"
 	"regexp"
+	"strconv"
 	"strings"
 	"time"
 
@@ -19,7 +21,18 @@ import (
 	log "github.com/sirupsen/logrus"
 
 	"github.com/halkeye/twitch_go_online/internal/airtable"
+	"github.com/halkeye/twitch_go_online/internal/conduit"
 	"github.com/halkeye/twitch_go_online/internal/discordsender"
+	"github.com/halkeye/twitch_go_online/internal/eventstream"
+	"github.com/halkeye/twitch_go_online/internal/eventsubws"
+	"github.com/halkeye/twitch_go_online/internal/poller"
+	"github.com/halkeye/twitch_go_online/internal/roster"
+	"github.com/halkeye/twitch_go_online/internal/shoutout"
+	"github.com/halkeye/twitch_go_online/internal/sqlroster"
+	"github.com/halkeye/twitch_go_online/internal/storage"
+	"github.com/halkeye/twitch_go_online/internal/tokens"
+	"github.com/halkeye/twitch_go_online/internal/tracker"
+	"github.com/halkeye/twitch_go_online/internal/twitchteam"
 )
 
 // eventSubNotification is a struct to hold the eventSub webhook request from Twitch.
@@ -45,7 +58,40 @@ func fetchStreamInfo(client *helix.Client, user_id string) (*helix.Stream, error
 	return nil, fmt.Errorf("no stream returned for uid: %s", user_id)
 }
 
-func handlerEventSub(secretKey string, client *helix.Client, ds *discordsender.DiscordSender) http.HandlerFunc {
+// lookupUsers resolves logins to twitch users, GetUsers only takes 100 at a time. Logins twitch would
+// reject are left out, one of them fails its whole batch.
+func lookupUsers(client *helix.Client, logins []string) ([]helix.User, error) {
+	users := []helix.User{}
+
+	usernames := []string{}
+	for _, login := range logins {
+		if !roster.ValidLogin(login) {
+			log.Warnf("skipping %q, it isn't a twitch login", login)
+			continue
+		}
+		usernames = append(usernames, login)
+	}
+
+	for start := 0; start < len(usernames); start += poller.BatchSize {
+		end := start + poller.BatchSize
+		if end > len(usernames) {
+			end = len(usernames)
+		}
+
+		getUserResp, err := client.GetUsers(&helix.UsersParams{Logins: usernames[start:end]})
+		if err != nil {
+			return nil, errors.Wrap(err, "Error getting users")
+		}
+		if getUserResp.ErrorStatus != 0 {
+			return nil, errors.Errorf("Error getting users (%d) - %s", getUserResp.ErrorStatus, getUserResp.ErrorMessage)
+		}
+		users = append(users, getUserResp.Data.Users...)
+	}
+
+	return users, nil
+}
+
+func handlerEventSub(secretKey string, an *announcer) http.HandlerFunc {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		// Read the request body.
 		body, err := io.ReadAll(r.Body)
@@ -54,9 +100,10 @@ func handlerEventSub(secretKey string, client *helix.Client, ds *discordsender.D
 		}
 		defer dclose(r.Body)
 
-		// Verify that the notification came from twitch using the secret.
-		if !helix.VerifyEventSubNotification(secretKey, r.Header, string(body)) {
+		// Verify that the notification came from twitch using the secret, an empty secret verifies anything
+		if len(secretKey) == 0 || !helix.VerifyEventSubNotification(secretKey, r.Header, string(body)) {
 			log.Println("invalid signature on message")
+			http.Error(w, "invalid signature", http.StatusForbidden)
 			return
 		} else {
 			log.Println("verified signature on message")
@@ -81,34 +128,22 @@ func handlerEventSub(secretKey string, client *helix.Client, ds *discordsender.D
 			return
 		}
 
-		if vals.Subscription.Type == "stream.online" {
-			var onlineEvent helix.EventSubStreamOnlineEvent
-			_ = json.NewDecoder(bytes.NewReader(vals.Event)).Decode(&onlineEvent)
-			log.Printf("got online event for: %s\n", onlineEvent.BroadcasterUserName)
-
-			// We got the event successfully, let twitch know
-			w.WriteHeader(200)
-			_, err := w.Write([]byte("ok"))
-			if err != nil {
-				panic(fmt.Errorf("unable to write body: %w", err))
-			}
+		if r.Header.Get("Twitch-Eventsub-Message-Type") == "revocation" {
+			log.Warnf("subscription %s (%s) revoked: %s", vals.Subscription.ID, vals.Subscription.Type, vals.Subscription.Status)
+			an.revoked(vals.Subscription)
+			return
+		}
 
-			stream, err := fetchStreamInfo(client, onlineEvent.BroadcasterUserID)
-			if err != nil {
-				log.Error(err)
-				panic(errors.Wrap(err, fmt.Sprintf("Error fetching stream info for %s (uid: %s)", onlineEvent.BroadcasterUserName, onlineEvent.BroadcasterUserID)))
-			}
+		// We got the event successfully, let twitch know
+		w.WriteHeader(200)
+		_, err = w.Write([]byte("ok"))
+		if err != nil {
+			panic(fmt.Errorf("unable to write body: %w", err))
+		}
 
-			tmplParams := map[string]string{
-				"Game":        escapeMarkdown(stream.GameName),
-				"ChannelName": escapeMarkdown(stream.UserName),
-				"ChannelUrl":  fmt.Sprintf("https://www.twitch.tv/%s", stream.UserLogin),
-			}
-			if err := ds.Send(tmplParams); err != nil {
-				panic(errors.Wrap(err, "unable to send webhook"))
-			}
-		} else {
-			log.Errorf("error: event type %s has not been implemented -- pull requests welcome!", r.Header.Get("Twitch-Eventsub-Subscription-Type"))
+		err = an.handle(r.Header.Get("Twitch-Eventsub-Message-Id"), r.Header.Get("Twitch-Eventsub-Message-Timestamp"), vals.Subscription, vals.Event)
+		if err != nil {
+			panic(err)
 		}
 	})
 }
@@ -133,65 +168,245 @@ func handlerEventSub(secretKey string, client *helix.Client, ds *discordsender.D
 //  return http.HandlerFunc(logFn)
 //}
 
-func registerSubscription(secretKey string, client *helix.Client, usernames []string, publicUrl string) error {
+// renameMessageTmpl is what ADMIN_DISCORD_WEBHOOK gets told when a streamer changes their login.
+const renameMessageTmpl = `{{.OldLogin}} is now {{.NewLogin}} on twitch (user id {{.UserID}}), the roster has been updated`
+
+// subscriptionTypes are the eventsub subscriptions created for every member of the roster.
+var subscriptionTypes = []string{helix.EventSubTypeStreamOnline, helix.EventSubTypeStreamOffline}
+
+// authorizedSubscriptionTypes are only created for members who authorized us on /auth/twitch.
+var authorizedSubscriptionTypes = []string{helix.EventSubTypeChannelFollow, helix.EventSubTypeChannelSubscription, helix.EventSubTypeChannelCheer}
+
+// subscriptionVersions are the subscription types we don't use version 1 of
+var subscriptionVersions = map[string]string{helix.EventSubTypeChannelUpdate: "2", helix.EventSubTypeChannelFollow: "2"}
+
+func subscriptionVersion(subType string) string {
+	if version, ok := subscriptionVersions[subType]; ok {
+		return version
+	}
+	return "1"
+}
+
+// subscriptionCondition points a subscription of subType at userId, raids are about the channel doing the raiding.
+func subscriptionCondition(subType string, userId string) helix.EventSubCondition {
+	if subType == helix.EventSubTypeChannelRaid {
+		return helix.EventSubCondition{FromBroadcasterUserID: userId}
+	}
+	if subType == helix.EventSubTypeChannelFollow {
+		// the broadcaster counts as a moderator of their own channel
+		return helix.EventSubCondition{BroadcasterUserID: userId, ModeratorUserID: userId}
+	}
+	return helix.EventSubCondition{BroadcasterUserID: userId}
+}
+
+// subscriptionTypesFor is what userId gets subscribed to. A websocket session can only subscribe to what the
+// bot's own token is allowed to, so it never gets the types that need someone else's authorization.
+func subscriptionTypesFor(userId string, authorized map[string]bool, transport helix.EventSubTransport) []string {
+	if !authorized[userId] || transport.Method == "websocket" {
+		return subscriptionTypes
+	}
+	return append(append([]string{}, subscriptionTypes...), authorizedSubscriptionTypes...)
+}
+
+func needsAuthorization(subType string) bool {
+	for _, authorizedType := range authorizedSubscriptionTypes {
+		if subType == authorizedType {
+			return true
+		}
+	}
+	return false
+}
+
+// createSubscriptions creates each of types for userId. A type that needs the streamer's authorization only
+// gets its failure logged, they can take the authorization back whenever they like.
+func createSubscriptions(userId string, types []string, create func(userId string, subType string) error) error {
+	for _, subType := range types {
+		if err := create(userId, subType); err != nil {
+			if !needsAuthorization(subType) {
+				return err
+			}
+			log.Error(errors.Wrapf(err, "unable to create %s subscription for %s", subType, userId))
+		}
+	}
+	return nil
+}
+
+// subscriptionUser is the user id a subscription was created for.
+func subscriptionUser(sub helix.EventSubSubscription) string {
+	if len(sub.Condition.BroadcasterUserID) != 0 {
+		return sub.Condition.BroadcasterUserID
+	}
+	return sub.Condition.FromBroadcasterUserID
+}
+
+func registerSubscription(client *helix.Client, userIds []string, authorized map[string]bool, transport helix.EventSubTransport) error {
 	/*
-	* 1) Lookup all usernames and get IDs
-	* 2) Delete all subscriptions with url prefix
-	* 3) Register all userids
+	* 1) Delete all subscriptions for our transport
+	* 2) Register all userids
 	 */
 
-	getUserResp, err := client.GetUsers(&helix.UsersParams{Logins: usernames})
+	for _, userId := range userIds {
+		log.Infof("Monitoring: %s", userId)
+	}
+
+	subs, err := listSubscriptions(client)
 	if err != nil {
 		return errors.Wrap(err, "Error getting subscriptions")
 	}
 
-	userIds := []string{}
+	for _, sub := range subs {
+		if ownSubscription(sub, transport) {
+			_, err = client.RemoveEventSubSubscription(sub.ID)
+			if err != nil {
+				return errors.Wrap(err, "Error removing subscriptions")
+			}
+		} else {
+			log.Infof("Not one of my subscriptions: %s => %s", sub.Transport.Callback, subscriptionUser(sub))
+		}
+	}
 
-	for _, userData := range getUserResp.Data.Users {
-		userIds = append(userIds, userData.ID)
-		log.Infof("Monitoring: %s => %s", userData.Login, userData.ID)
+	create := func(userId string, subType string) error {
+		return createSubscription(client, userId, subType, transport)
+	}
+	for _, userId := range userIds {
+		if err := createSubscriptions(userId, subscriptionTypesFor(userId, authorized, transport), create); err != nil {
+			return err
+		}
 	}
 
-	getSubResp, err := client.GetEventSubSubscriptions(&helix.EventSubSubscriptionsParams{})
+	return nil
+}
+
+func createSubscription(client *helix.Client, userId string, subType string, transport helix.EventSubTransport) error {
+	createSubResp, err := client.CreateEventSubSubscription(&helix.EventSubSubscription{
+		Type:      subType,
+		Version:   subscriptionVersion(subType),
+		Condition: subscriptionCondition(subType, userId),
+		Transport: transport,
+	})
+
+	if err != nil {
+		return errors.Wrap(err, "Error creating subscription")
+	}
+
+	if createSubResp.ErrorStatus == http.StatusConflict {
+		// already subscribed, a renamed streamer comes back through here under their new login
+		return nil
+	}
+	if createSubResp.ErrorStatus > 0 {
+		return errors.Errorf("Error creating subscription (%d) - %s", createSubResp.ErrorStatus, createSubResp.Error)
+	}
+	return nil
+}
+
+// registerConduitSubscription makes the conduit's subscriptions match the roster. Several instances
+// can share a conduit, so rather than starting from scratch only the differences are applied.
+func registerConduitSubscription(client *helix.Client, conduits *conduit.Client, conduitID string, userIds []string, authorized map[string]bool) error {
+	wanted := map[string]bool{}
+	for _, userId := range userIds {
+		wanted[userId] = true
+		log.Infof("Monitoring: %s", userId)
+	}
+
+	// only our own conduit, other deployments of the same app have conduits of their own
+	subs, err := conduits.Subscriptions(conduitID)
 	if err != nil {
 		return errors.Wrap(err, "Error getting subscriptions")
 	}
 
-	for _, sub := range getSubResp.Data.EventSubSubscriptions {
-		if strings.HasPrefix(sub.Transport.Callback, publicUrl) {
+	existing := map[string]bool{}
+	for _, sub := range subs {
+		userId := subscriptionUser(sub)
+		// streamers who took their authorization back keep the rest of their subscriptions
+		revoked := needsAuthorization(sub.Type) && (!authorized[userId] || sub.Status == "authorization_revoked")
+		if !wanted[userId] || revoked {
 			_, err = client.RemoveEventSubSubscription(sub.ID)
 			if err != nil {
 				return errors.Wrap(err, "Error removing subscriptions")
 			}
-		} else {
-			log.Infof("Not one of my subscriptions: %s => %s", sub.Transport.Callback, sub.Condition.BroadcasterUserID)
+			continue
 		}
+		existing[sub.Type+"/"+userId] = true
 	}
 
-	for _, userId := range userIds {
-		createSubResp, err := client.CreateEventSubSubscription(&helix.EventSubSubscription{
-			Type:      helix.EventSubTypeStreamOnline,
-			Version:   "1",
-			Condition: helix.EventSubCondition{BroadcasterUserID: userId},
-			Transport: helix.EventSubTransport{
-				Method:   "webhook",
-				Callback: fmt.Sprintf("%swebhook/callbacks", publicUrl),
-				Secret:   secretKey,
-			},
-		})
+	create := func(userId string, subType string) error {
+		if existing[subType+"/"+userId] {
+			return nil
+		}
+		err := conduits.CreateSubscription(conduitID, subType, subscriptionVersion(subType), subscriptionCondition(subType, userId))
+		return errors.Wrap(err, "Error creating subscription")
+	}
+	transport := helix.EventSubTransport{Method: conduit.Method}
+	for userId := range wanted {
+		if err := createSubscriptions(userId, subscriptionTypesFor(userId, authorized, transport), create); err != nil {
+			return err
+		}
+	}
 
+	return nil
+}
+
+// listSubscriptions returns every eventsub subscription of the app, following pagination.
+func listSubscriptions(client *helix.Client) ([]helix.EventSubSubscription, error) {
+	subs := []helix.EventSubSubscription{}
+	after := ""
+	for {
+		getSubResp, err := client.GetEventSubSubscriptions(&helix.EventSubSubscriptionsParams{After: after})
 		if err != nil {
-			return errors.Wrap(err, "Error creating subscription")
+			return nil, err
 		}
+		if getSubResp.ErrorStatus != 0 {
+			return nil, errors.Errorf("Error getting subscriptions (%d) - %s", getSubResp.ErrorStatus, getSubResp.ErrorMessage)
+		}
+		subs = append(subs, getSubResp.Data.EventSubSubscriptions...)
 
-		if createSubResp.ErrorStatus > 0 {
-			return errors.Errorf("Error creating subscription (%d) - %s", createSubResp.ErrorStatus, createSubResp.Error)
+		if len(getSubResp.Data.Pagination.Cursor) == 0 {
+			return subs, nil
 		}
+		after = getSubResp.Data.Pagination.Cursor
+	}
+}
+
+// catchUp deals with roster members who are live while we weren't around to hear about it. Streams announced
+// before a restart are picked back up, with a mode ("announce" or "record") ones that started within window are too.
+func catchUp(client *helix.Client, an *announcer, userIds []string, window time.Duration, mode string) error {
+	live, err := poller.FetchLive(client, userIds)
+	if err != nil {
+		return err
 	}
 
+	for _, stream := range live {
+		if an.store.Announced(stream.ID) {
+			log.Infof("Picking %s's stream %s back up", stream.UserLogin, stream.ID)
+			an.resume(stream)
+			continue
+		}
+		if len(mode) == 0 || time.Since(stream.StartedAt) > window {
+			continue
+		}
+
+		if mode == "announce" {
+			log.Infof("Catching up on %s who went live at %s", stream.UserLogin, stream.StartedAt)
+			an.polled(helix.EventSubTypeStreamOnline, stream)
+		} else {
+			log.Infof("Recording %s who went live at %s without announcing", stream.UserLogin, stream.StartedAt)
+			if err := an.record(stream); err != nil {
+				return errors.Wrap(err, "unable to record stream")
+			}
+		}
+	}
 	return nil
 }
 
+// ownSubscription reports whether sub was created by us for the given transport, and so can be replaced.
+func ownSubscription(sub helix.EventSubSubscription, transport helix.EventSubTransport) bool {
+	if transport.Method == "websocket" {
+		// websocket sessions die with the connection, anything left over is from one of our earlier sessions
+		return sub.Transport.Method == "websocket"
+	}
+	return sub.Transport.Method == "webhook" && strings.HasPrefix(sub.Transport.Callback, transport.Callback)
+}
+
 func mustJson(data interface{}) string {
 	b, err := json.MarshalIndent(data, "", "  ")
 	if err != nil {
@@ -224,6 +439,57 @@ func scheduleRefresh(client *helix.Client, refreshToken string, expiresIn int) {
 	})
 }
 
+// shoutoutQueue sends shoutouts as whoever userAccessToken belongs to, they have to be a moderator of every streamer who opted in.
+// It gets a client of its own, the main one sends the app token outside of the websocket transport.
+func shoutoutQueue(options helix.Options, maxWait time.Duration) (*shoutout.Queue, error) {
+	client, err := helix.NewClient(&options)
+	if err != nil {
+		return nil, errors.Wrap(err, "Unable to create the shoutout twitch client")
+	}
+	valid, validated, err := client.ValidateToken(options.UserAccessToken)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to validate the shoutout user token")
+	}
+	if !valid {
+		return nil, errors.New("the shoutout user token isn't valid")
+	}
+	scoped := false
+	for _, scope := range validated.Data.Scopes {
+		scoped = scoped || scope == "moderator:manage:shoutouts"
+	}
+	if !scoped {
+		log.Warnf("%s's token is missing the moderator:manage:shoutouts scope, twitch will refuse every shoutout", validated.Data.Login)
+	}
+	return shoutout.New(client, validated.Data.UserID, maxWait), nil
+}
+
+// webhookSecret loads the airtable webhook secret saved for url, opening it with cipher when there is one.
+// It also returns the secret as it was saved, a secret saved before the cipher was set up doesn't match it and gets sealed.
+func webhookSecret(store storage.Store, cipher *tokens.Cipher, url string) (string, string, error) {
+	saved, _, err := store.WebhookSecret(url)
+	if err != nil || cipher == nil || len(saved) == 0 {
+		return saved, saved, err
+	}
+	secret, err := cipher.Open(saved)
+	if err != nil {
+		log.Warn("The saved airtable webhook secret isn't sealed with TOKEN_ENCRYPTION_KEY, sealing it as it is")
+		return saved, "", nil
+	}
+	return secret, secret, nil
+}
+
+// saveWebhookSecret saves the airtable webhook secret for url, sealed with cipher when there is one.
+func saveWebhookSecret(store storage.Store, cipher *tokens.Cipher, url string, secret string) error {
+	if cipher != nil {
+		sealed, err := cipher.Seal(secret)
+		if err != nil {
+			return errors.Wrap(err, "unable to seal the airtable webhook secret")
+		}
+		secret = sealed
+	}
+	return store.SaveWebhookSecret(url, secret)
+}
+
 func main() {
 	for _, level := range log.AllLevels {
 		if level.String() == os.Getenv("LOG_LEVEL") {
@@ -257,57 +523,503 @@ func realMain() error {
 	publicUrl := os.Getenv("PUBLIC_URL")
 	goliveMessage := os.Getenv("GOLIVE_MESSAGE")
 	discordWebhook := os.Getenv("DISCORD_WEBHOOK")
-	airtableAPIKey := os.Getenv("AIRTABLE_API_KEY")
-	airtableTableName := os.Getenv("AIRTABLE_TABLE_NAME")
-	airtableBaseId := "app9gXc0ovBSGKOSE"
+	eventsToken := os.Getenv("EVENTS_TOKEN")
+	apiToken := os.Getenv("API_TOKEN")
+	eventsubTransport := os.Getenv("EVENTSUB_TRANSPORT")
+	userAccessToken := os.Getenv("TWITCH_USER_ACCESS_TOKEN")
+	writeActivity := os.Getenv("AIRTABLE_WRITE_ACTIVITY") == "true"
+	userRefreshToken := os.Getenv("TWITCH_USER_REFRESH_TOKEN")
+	pollMode := os.Getenv("POLL_MODE")
+	storageDSN := os.Getenv("STORAGE_DSN")
+	offlineMessage := os.Getenv("OFFLINE_MESSAGE")
+	catchupMode := os.Getenv("CATCHUP_MODE")
+	recapSchedule := os.Getenv("RECAP_SCHEDULE")
+	gameChanges := os.Getenv("GAME_CHANGES")
+	gameChangeMessage := os.Getenv("GAME_CHANGE_MESSAGE")
+	raids := os.Getenv("RAIDS")
+	shoutouts := os.Getenv("SHOUTOUTS") == "true"
+	raidMessage := os.Getenv("RAID_MESSAGE")
+	tokenKey := os.Getenv("TOKEN_ENCRYPTION_KEY")
+	milestoneMessage := os.Getenv("MILESTONE_MESSAGE")
+	scheduleReminders := os.Getenv("SCHEDULE_REMINDERS") == "true"
+	agendaSchedule := os.Getenv("AGENDA_SCHEDULE")
+	highlightMode := os.Getenv("HIGHLIGHTS")
+	highlightMessage := os.Getenv("HIGHLIGHT_MESSAGE")
+	catchupWindow := 10 * time.Minute
 
-	if len(secretKey) == 0 {
-		return errors.New("no secret key provided")
+	if len(storageDSN) == 0 {
+		storageDSN = "twitch_go_online.db"
+	}
+	if os.Getenv("CATCHUP_WINDOW") != "" {
+		parsed, err := time.ParseDuration(os.Getenv("CATCHUP_WINDOW"))
+		if err != nil {
+			return errors.Wrap(err, "invalid CATCHUP_WINDOW")
+		}
+		catchupWindow = parsed
+	}
+	// CATCHUP_MODE=announce posts streams that started while we were down, CATCHUP_MODE=record only remembers them
+	if catchupMode != "" && catchupMode != "announce" && catchupMode != "record" {
+		return errors.Errorf("unknown CATCHUP_MODE %s", catchupMode)
+	}
+	eventsReplaySize := 0
+	if os.Getenv("EVENTS_REPLAY_SIZE") != "" {
+		parsed, err := strconv.Atoi(os.Getenv("EVENTS_REPLAY_SIZE"))
+		if err != nil {
+			return errors.Wrap(err, "invalid EVENTS_REPLAY_SIZE")
+		}
+		eventsReplaySize = parsed
+	}
+
+	pollInterval := 2 * time.Minute
+
+	if os.Getenv("POLL_INTERVAL") != "" {
+		parsed, err := time.ParseDuration(os.Getenv("POLL_INTERVAL"))
+		if err != nil {
+			return errors.Wrap(err, "invalid POLL_INTERVAL")
+		}
+		if parsed <= 0 {
+			return errors.Errorf("invalid POLL_INTERVAL %s, it has to be positive", parsed)
+		}
+		pollInterval = parsed
+	}
+
+	// TRACK_INTERVAL=0 stops sampling viewers and game changes while streams are live
+	trackInterval := 5 * time.Minute
+	if os.Getenv("TRACK_INTERVAL") != "" {
+		parsed, err := time.ParseDuration(os.Getenv("TRACK_INTERVAL"))
+		if err != nil {
+			return errors.Wrap(err, "invalid TRACK_INTERVAL")
+		}
+		if parsed < 0 {
+			return errors.Errorf("invalid TRACK_INTERVAL %s, it has to be positive or 0", parsed)
+		}
+		trackInterval = parsed
 	}
 
-	if len(airtableAPIKey) == 0 || len(airtableBaseId) == 0 || len(airtableTableName) == 0 {
-		return errors.New("missing airtable config")
+	// GAME_CHANGES=post announces streamers (who opted in) switching games, GAME_CHANGES=edit updates their announcement instead
+	if gameChanges != "" && gameChanges != "post" && gameChanges != "edit" {
+		return errors.Errorf("unknown GAME_CHANGES %s", gameChanges)
+	}
+	if len(gameChanges) != 0 {
+		subscriptionTypes = append(subscriptionTypes, helix.EventSubTypeChannelUpdate)
+	}
+	if len(gameChangeMessage) == 0 {
+		gameChangeMessage = gameChangeMessageTmpl
+	}
+	gameChangeInterval := 10 * time.Minute
+	if os.Getenv("GAME_CHANGE_INTERVAL") != "" {
+		parsed, err := time.ParseDuration(os.Getenv("GAME_CHANGE_INTERVAL"))
+		if err != nil {
+			return errors.Wrap(err, "invalid GAME_CHANGE_INTERVAL")
+		}
+		gameChangeInterval = parsed
+	}
+
+	// RAIDS=record keeps track of raid trains between roster members, RAIDS=announce posts about each raid too
+	if raids != "" && raids != "record" && raids != "announce" {
+		return errors.Errorf("unknown RAIDS %s", raids)
+	}
+	// SHOUTOUTS=true has raided members (who opted in) shout out their raider, as the moderator TWITCH_USER_ACCESS_TOKEN belongs to
+	if shoutouts && len(userAccessToken) == 0 {
+		return errors.New("shoutouts need a moderator's user access token")
+	}
+	if shoutouts && len(raids) == 0 {
+		raids = "record"
+	}
+	shoutoutMaxWait := 10 * time.Minute
+	if os.Getenv("SHOUTOUT_MAX_WAIT") != "" {
+		parsed, err := time.ParseDuration(os.Getenv("SHOUTOUT_MAX_WAIT"))
+		if err != nil {
+			return errors.Wrap(err, "invalid SHOUTOUT_MAX_WAIT")
+		}
+		shoutoutMaxWait = parsed
+	}
+	if len(raids) != 0 {
+		subscriptionTypes = append(subscriptionTypes, helix.EventSubTypeChannelRaid)
+	}
+	if raids != "announce" {
+		raidMessage = ""
+	} else if len(raidMessage) == 0 {
+		raidMessage = raidMessageTmpl
+	}
+	raidChainWindow := 12 * time.Hour
+	if os.Getenv("RAID_CHAIN_WINDOW") != "" {
+		parsed, err := time.ParseDuration(os.Getenv("RAID_CHAIN_WINDOW"))
+		if err != nil {
+			return errors.Wrap(err, "invalid RAID_CHAIN_WINDOW")
+		}
+		raidChainWindow = parsed
+	}
+
+	if len(milestoneMessage) == 0 {
+		milestoneMessage = milestoneMessageTmpl
+	}
+	milestones := map[string]int{milestoneFollowers: 100, milestoneSubscribers: 50, milestoneBits: 10000}
+	for env, kind := range map[string]string{
+		"FOLLOWER_MILESTONE":   milestoneFollowers,
+		"SUBSCRIBER_MILESTONE": milestoneSubscribers,
+		"BITS_MILESTONE":       milestoneBits,
+	} {
+		if os.Getenv(env) != "" {
+			parsed, err := strconv.Atoi(os.Getenv(env))
+			if err != nil {
+				return errors.Wrapf(err, "invalid %s", env)
+			}
+			milestones[kind] = parsed
+		}
+	}
+
+	// HIGHLIGHTS=post sends the VOD and top clips next to a stream's announcement once it ends, HIGHLIGHTS=append adds them to it
+	if highlightMode != "" && highlightMode != "post" && highlightMode != "append" {
+		return errors.Errorf("unknown HIGHLIGHTS %s", highlightMode)
+	}
+	if len(highlightMessage) == 0 {
+		highlightMessage = highlightMessageTmpl
+	}
+	highlightDelay := 10 * time.Minute
+	if os.Getenv("HIGHLIGHT_DELAY") != "" {
+		parsed, err := time.ParseDuration(os.Getenv("HIGHLIGHT_DELAY"))
+		if err != nil {
+			return errors.Wrap(err, "invalid HIGHLIGHT_DELAY")
+		}
+		highlightDelay = parsed
+	}
+	highlightClips := 3
+	if os.Getenv("HIGHLIGHT_CLIPS") != "" {
+		parsed, err := strconv.Atoi(os.Getenv("HIGHLIGHT_CLIPS"))
+		if err != nil {
+			return errors.Wrap(err, "invalid HIGHLIGHT_CLIPS")
+		}
+		highlightClips = parsed
+	}
+
+	// SCHEDULE_REMINDERS=true posts a reminder before streams on a member's twitch schedule, AGENDA_SCHEDULE posts the day's schedule
+	scheduleLocation := time.UTC
+	if os.Getenv("SCHEDULE_TIMEZONE") != "" {
+		parsed, err := time.LoadLocation(os.Getenv("SCHEDULE_TIMEZONE"))
+		if err != nil {
+			return errors.Wrap(err, "invalid SCHEDULE_TIMEZONE")
+		}
+		scheduleLocation = parsed
+	}
+	reminderLead := 15 * time.Minute
+	if os.Getenv("SCHEDULE_REMINDER_LEAD") != "" {
+		parsed, err := time.ParseDuration(os.Getenv("SCHEDULE_REMINDER_LEAD"))
+		if err != nil {
+			return errors.Wrap(err, "invalid SCHEDULE_REMINDER_LEAD")
+		}
+		reminderLead = parsed
+	}
+	scheduleCheckInterval := 5 * time.Minute
+	if os.Getenv("SCHEDULE_CHECK_INTERVAL") != "" {
+		parsed, err := time.ParseDuration(os.Getenv("SCHEDULE_CHECK_INTERVAL"))
+		if err != nil {
+			return errors.Wrap(err, "invalid SCHEDULE_CHECK_INTERVAL")
+		}
+		if parsed <= 0 {
+			return errors.Errorf("invalid SCHEDULE_CHECK_INTERVAL %s, it has to be positive", parsed)
+		}
+		scheduleCheckInterval = parsed
+	}
+
+	rosterCheckInterval := 6 * time.Hour
+	if os.Getenv("ROSTER_CHECK_INTERVAL") != "" {
+		parsed, err := time.ParseDuration(os.Getenv("ROSTER_CHECK_INTERVAL"))
+		if err != nil {
+			return errors.Wrap(err, "invalid ROSTER_CHECK_INTERVAL")
+		}
+		if parsed <= 0 {
+			return errors.Errorf("invalid ROSTER_CHECK_INTERVAL %s, it has to be positive", parsed)
+		}
+		rosterCheckInterval = parsed
+	}
+
+	// POLL_MODE=only skips eventsub entirely, POLL_MODE=hybrid polls to catch anything eventsub missed
+	if pollMode != "" && pollMode != "only" && pollMode != "hybrid" {
+		return errors.Errorf("unknown POLL_MODE %s", pollMode)
+	}
+	useEventSub := pollMode != "only"
+
+	useWebsocket := useEventSub && eventsubTransport == "websocket"
+	useConduit := useEventSub && eventsubTransport == "conduit"
+	// only webhooks (plain or as a conduit shard) get notifications on /webhook/callbacks
+	useWebhook := useEventSub && !useWebsocket && !(useConduit && os.Getenv("CONDUIT_SHARD_TRANSPORT") == "websocket")
+	conduitShardID := os.Getenv("CONDUIT_SHARD_ID")
+	conduitShardCount := 1
+
+	if len(conduitShardID) == 0 {
+		conduitShardID = "0"
+	}
+	if os.Getenv("CONDUIT_SHARD_COUNT") != "" {
+		parsed, err := strconv.Atoi(os.Getenv("CONDUIT_SHARD_COUNT"))
+		if err != nil {
+			return errors.Wrap(err, "invalid CONDUIT_SHARD_COUNT")
+		}
+		conduitShardCount = parsed
+	}
+	if useWebsocket {
+		if len(userAccessToken) == 0 {
+			return errors.New("the websocket transport needs a user access token")
+		}
+	} else if useWebhook && len(secretKey) == 0 {
+		return errors.New("no secret key provided")
 	}
 
 	ds := discordsender.New(discordWebhook, goliveMessage)
-	at := airtable.New(airtableAPIKey, airtableBaseId, airtableTableName)
+	var admins *discordsender.DiscordSender
+	if adminWebhook := os.Getenv("ADMIN_DISCORD_WEBHOOK"); len(adminWebhook) != 0 {
+		admins = discordsender.New(adminWebhook, renameMessageTmpl)
+	}
+	hub := eventstream.New(eventsToken, eventsReplaySize)
 
-	client, err := helix.NewClient(&helix.Options{
-		ClientID:     clientId,
-		ClientSecret: clientSecret,
-	})
+	userOptions := helix.Options{
+		ClientID:        clientId,
+		ClientSecret:    clientSecret,
+		UserAccessToken: userAccessToken,
+		RefreshToken:    userRefreshToken,
+	}
+	options := helix.Options{ClientID: clientId, ClientSecret: clientSecret}
+	if useWebsocket {
+		options = userOptions
+	}
+	client, err := helix.NewClient(&options)
 	if err != nil {
 		return errors.Wrap(err, "Unable to create twitch client")
 	}
+	source, at, err := rosterSource(client)
+	if err != nil {
+		return err
+	}
+
+	if useWebsocket {
+		// websocket subscriptions must be created with a user token, helix refreshes it for us when it expires
+		client.OnUserAccessTokenRefreshed(func(newAccessToken, newRefreshToken string) {
+			log.Info("Refreshed twitch user token")
+		})
+	} else {
+		resp, err := client.RequestAppAccessToken([]string{"user:read:email"})
+		if err != nil {
+			return errors.Wrap(err, "Unable to request app token")
+		}
 
-	resp, err := client.RequestAppAccessToken([]string{"user:read:email"})
+		// Set the access token on the client
+		client.SetAppAccessToken(resp.Data.AccessToken)
+		scheduleRefresh(client, resp.Data.RefreshToken, resp.Data.ExpiresIn)
+	}
+	store, err := storage.Open(storageDSN)
 	if err != nil {
-		return errors.Wrap(err, "Unable to request app token")
+		return errors.Wrap(err, "Unable to open storage")
 	}
+	defer dclose(store)
+	an := newAnnouncer(client, ds, hub, store)
+	an.offlineMessage = offlineMessage
+	an.gameChanges = gameChanges
+	an.gameChangeMessage = gameChangeMessage
+	an.gameChangeInterval = gameChangeInterval
+	an.raidMessage = raidMessage
+	an.raidChainWindow = raidChainWindow
+	an.milestoneMessage = milestoneMessage
+	an.milestones = milestones
+	an.highlightMode = highlightMode
+	an.highlightMessage = highlightMessage
+	an.highlightDelay = highlightDelay
+	an.highlightClips = highlightClips
 
-	// Set the access token on the client
-	client.SetAppAccessToken(resp.Data.AccessToken)
-	scheduleRefresh(client, resp.Data.RefreshToken, resp.Data.ExpiresIn)
+	// TOKEN_ENCRYPTION_KEY lets streamers authorize follower, subscriber and cheer events on /auth/twitch
+	var tokenCipher *tokens.Cipher
+	var vault *tokens.Vault
+	if len(tokenKey) != 0 {
+		key, err := tokens.ParseKey(tokenKey)
+		if err != nil {
+			return errors.Wrap(err, "invalid TOKEN_ENCRYPTION_KEY")
+		}
+		if tokenCipher, err = tokens.NewCipher(key); err != nil {
+			return err
+		}
+		vault = tokens.NewVault(store, tokenCipher, helix.Options{ClientID: clientId, ClientSecret: clientSecret})
+		an.authorizations = vault
+	}
+	if writeActivity && at != nil {
+		// the activity columns have to exist in the table, airtable rejects the whole update otherwise
+		an.activity = at.NewActivityWriter(5 * time.Second)
+	}
+	if shoutouts {
+		queue, err := shoutoutQueue(userOptions, shoutoutMaxWait)
+		if err != nil {
+			return err
+		}
+		an.shoutouts = queue
+		go queue.Run(context.Background())
+	}
+	if trackInterval > 0 {
+		an.tracker = tracker.New(client, store, trackInterval)
+		go an.tracker.Run(context.Background())
+	}
+	if len(recapSchedule) != 0 {
+		recapWebhook := os.Getenv("RECAP_DISCORD_WEBHOOK")
+		if len(recapWebhook) == 0 {
+			recapWebhook = discordWebhook
+		}
+		recapMessage := os.Getenv("RECAP_MESSAGE")
+		if len(recapMessage) == 0 {
+			recapMessage = recapMessageTmpl
+		}
+		recaps, err := scheduleRecap(recapSchedule, &recapper{store: store, ds: discordsender.New(recapWebhook, recapMessage)})
+		if err != nil {
+			return err
+		}
+		defer recaps.Stop()
+	}
+	if scheduleReminders || len(agendaSchedule) != 0 {
+		sc := newScheduler(client, an, store)
+		sc.lead = reminderLead
+		sc.interval = scheduleCheckInterval
+		sc.location = scheduleLocation
+		if reminderMessage := os.Getenv("SCHEDULE_REMINDER_MESSAGE"); len(reminderMessage) != 0 {
+			sc.reminderMessage = reminderMessage
+		}
+		if scheduleReminders {
+			go sc.Run(context.Background())
+		}
+		if len(agendaSchedule) != 0 {
+			agendaWebhook := os.Getenv("AGENDA_DISCORD_WEBHOOK")
+			if len(agendaWebhook) == 0 {
+				agendaWebhook = discordWebhook
+			}
+			agendaMessage := os.Getenv("AGENDA_MESSAGE")
+			if len(agendaMessage) == 0 {
+				agendaMessage = agendaMessageTmpl
+			}
+			sc.agenda = discordsender.New(agendaWebhook, agendaMessage)
+			agendas, err := scheduleAgenda(agendaSchedule, sc)
+			if err != nil {
+				return err
+			}
+			defer agendas.Stop()
+		}
+	}
 
 	port := ":3000"
 	if os.Getenv("PORT") != "" {
 		port = ":" + os.Getenv("PORT")
 	}
 
-	err = at.RegisterWebhook(fmt.Sprintf("%swebhook/airtable", publicUrl))
-	if err != nil {
-		return errors.Wrap(err, "Unable to register airtable webhook")
+	if at != nil && len(publicUrl) != 0 {
+		webhookURL := fmt.Sprintf("%swebhook/airtable", publicUrl)
+		// airtable only hands out the secret when the webhook is created, without it the webhook gets recreated
+		known := ""
+		if len(at.MACSecret) == 0 {
+			at.MACSecret, known, err = webhookSecret(store, tokenCipher, webhookURL)
+			if err != nil {
+				return err
+			}
+		}
+		err = at.RegisterWebhook(webhookURL)
+		if err != nil {
+			return errors.Wrap(err, "Unable to register airtable webhook")
+		}
+		if at.MACSecret != known {
+			if err := saveWebhookSecret(store, tokenCipher, webhookURL, at.MACSecret); err != nil {
+				return err
+			}
+		}
+	} else if at != nil {
+		log.Warn("No PUBLIC_URL set, so airtable changes are only picked up on restart")
 	}
-	twitchusernames, err := at.Usernames()
+	members, err := source.Members()
 	if err != nil {
 		return errors.Wrap(err, "Error fetching usernames")
 	}
+	members, err = checkRoster(client, source, admins, members)
+	if err != nil {
+		return err
+	}
+	an.setMembers(members)
+	userIDs := roster.UserIDs(members)
 
-	log.WithFields(log.Fields{"usernames": twitchusernames}).Debug("twitch user names")
+	log.WithFields(log.Fields{"usernames": roster.Logins(members), "user_ids": userIDs}).Debug("twitch users")
 
-	err = registerSubscription(secretKey, client, twitchusernames, publicUrl)
-	if err != nil {
-		return errors.Wrap(err, "Unable to create subscriptions")
+	handleWebsocketNotification := func(messageID string, timestamp string, sub helix.EventSubSubscription, event json.RawMessage) {
+		if err := an.handle(messageID, timestamp, sub, event); err != nil {
+			log.Error(err)
+		}
+	}
+	webhookCallback := fmt.Sprintf("%swebhook/callbacks", publicUrl)
+
+	sm := newSubscriptionManager(client, userIDs)
+	sm.store = store
+	sm.authorizations = vault
+	if !useEventSub {
+		log.Info("Polling only, so not subscribing to eventsub")
+	} else if useWebsocket {
+		ws := eventsubws.New(os.Getenv("EVENTSUB_WEBSOCKET_URL"))
+		ws.OnWelcome = func(sessionID string) error {
+			return sm.SetTransport(helix.EventSubTransport{Method: "websocket", SessionID: sessionID})
+		}
+		ws.OnNotification = handleWebsocketNotification
+		ws.OnRevocation = an.revoked
+		go func() {
+			if err := ws.Run(context.Background()); err != nil {
+				log.Error(errors.Wrap(err, "eventsub websocket stopped"))
+			}
+		}()
+	} else if useConduit {
+		conduits := conduit.New(clientId, client.GetAppAccessToken)
+		cd, err := conduits.Ensure(os.Getenv("CONDUIT_ID"), conduitShardCount)
+		if err != nil {
+			return errors.Wrap(err, "Unable to set up conduit")
+		}
+		cs := &conduitShard{conduits: conduits, conduitID: cd.ID, shardID: conduitShardID}
+
+		if os.Getenv("CONDUIT_SHARD_TRANSPORT") == "websocket" {
+			ws := eventsubws.New(os.Getenv("EVENTSUB_WEBSOCKET_URL"))
+			ws.OnWelcome = func(sessionID string) error {
+				return cs.assign(conduit.Transport{Method: "websocket", SessionID: sessionID})
+			}
+			ws.OnNotification = handleWebsocketNotification
+			ws.OnRevocation = an.revoked
+			go func() {
+				if err := ws.Run(context.Background()); err != nil {
+					log.Error(errors.Wrap(err, "eventsub websocket stopped"))
+				}
+			}()
+		} else {
+			transport := conduit.Transport{Method: "webhook", Callback: webhookCallback, Secret: secretKey}
+			if err := cs.assign(transport); err != nil {
+				return errors.Wrap(err, "Unable to assign conduit shard")
+			}
+			go cs.watch(context.Background(), time.Minute, transport)
+		}
+
+		if err := sm.SetConduit(conduits, cd.ID); err != nil {
+			return errors.Wrap(err, "Unable to create subscriptions")
+		}
+	} else {
+		err = sm.SetTransport(helix.EventSubTransport{
+			Method:   "webhook",
+			Callback: webhookCallback,
+			Secret:   secretKey,
+		})
+		if err != nil {
+			return errors.Wrap(err, "Unable to create subscriptions")
+		}
+	}
+
+	if err := catchUp(client, an, userIDs, catchupWindow, catchupMode); err != nil {
+		return errors.Wrap(err, "Unable to catch up on live streams")
+	}
+
+	var p *poller.Poller
+	if len(pollMode) != 0 {
+		p = poller.New(client, pollInterval)
+		p.OnOnline = func(stream helix.Stream) {
+			an.polled(helix.EventSubTypeStreamOnline, stream)
+		}
+		p.OnOffline = func(stream helix.Stream) {
+			an.polled(helix.EventSubTypeStreamOffline, stream)
+		}
+		p.SetUserIDs(userIDs)
+		go p.Run(context.Background())
 	}
 
 	// ticker := time.NewTicker(1 * time.Minute)
@@ -335,13 +1047,48 @@ func realMain() error {
 
 	log.Printf("server starting on %s\n", port)
 
-	http.HandleFunc("/webhook/callbacks", sentryHandler.HandleFunc(handlerEventSub(secretKey, client, ds)))
-	http.HandleFunc("/webhook/airtable", sentryHandler.HandleFunc(at.HttpHandler(func(usernames []string) {
-		err = registerSubscription(secretKey, client, twitchusernames, publicUrl)
+	if useWebhook {
+		http.HandleFunc("/webhook/callbacks", sentryHandler.HandleFunc(handlerEventSub(secretKey, an)))
+	}
+	if vault != nil && len(publicUrl) != 0 {
+		authClient, err := helix.NewClient(&helix.Options{
+			ClientID:     clientId,
+			ClientSecret: clientSecret,
+			RedirectURI:  fmt.Sprintf("%sauth/twitch/callback", publicUrl),
+		})
 		if err != nil {
-			panic(errors.Wrap(err, "Unable to create subscriptions"))
+			return errors.Wrap(err, "Unable to create twitch client")
+		}
+		af := &authFlow{client: authClient, cipher: tokenCipher, vault: vault, an: an, sm: sm}
+		http.HandleFunc("GET /auth/twitch", sentryHandler.HandleFunc(af.startHandler()))
+		http.HandleFunc("GET /auth/twitch/callback", sentryHandler.HandleFunc(af.callbackHandler()))
+	} else {
+		log.Info("No TOKEN_ENCRYPTION_KEY or PUBLIC_URL set, so streamers can't authorize follower, subscriber and cheer events")
+	}
+	if len(eventsToken) != 0 {
+		http.HandleFunc("/events", hub.SSEHandler())
+		http.HandleFunc("/events/ws", hub.WebSocketHandler())
+	} else {
+		log.Info("No EVENTS_TOKEN set, so not exposing the event stream")
+	}
+	if len(apiToken) != 0 {
+		reports := &api{token: apiToken, store: store, client: client, an: an}
+		http.HandleFunc("GET /api/streamers/{login}/sessions", sentryHandler.HandleFunc(reports.sessionsHandler()))
+		http.HandleFunc("GET /api/stats", sentryHandler.HandleFunc(reports.statsHandler()))
+		http.HandleFunc("GET /api/raids", sentryHandler.HandleFunc(reports.raidsHandler()))
+	} else {
+		log.Info("No API_TOKEN set, so not exposing the api")
+	}
+	rs := &rosterSync{client: client, source: source, admins: admins, an: an, sm: sm, p: p}
+	go rs.recheck(rosterCheckInterval)
+	go func() {
+		if err := source.Watch(context.Background(), rs.changed); err != nil {
+			log.Error(errors.Wrap(err, "stopped watching the roster"))
 		}
-	})))
+	}()
+	if at != nil {
+		http.HandleFunc("/webhook/airtable", sentryHandler.HandleFunc(at.HttpHandler()))
+	}
 	http.HandleFunc("/", func(w http.ResponseWriter, _ *http.Request) {
 		_, err := io.WriteString(w, "\n")
 		if err != nil {
@@ -356,6 +1103,160 @@ func realMain() error {
 	return nil
 }
 
+// legacyAirtableBaseID is the airtable base the roster was read from before AIRTABLE_BASE_ID existed.
+const legacyAirtableBaseID = "app9gXc0ovBSGKOSE"
+
+// rosterSource picks where the roster comes from. ROSTER_SOURCE=file reads ROSTER_FILE, and is the default
+// when ROSTER_FILE is set, ROSTER_SOURCE=sql reads the streamers table of ROSTER_DATABASE_URL (a postgres url
+// or sqlite path), ROSTER_SOURCE=url polls a json or csv roster at ROSTER_URL, ROSTER_SOURCE=team is everyone in TWITCH_TEAM (or TWITCH_TEAM_ID), otherwise the
+// roster lives in airtable. Setting TWITCH_TEAM alongside another source merges the two, TWITCH_TEAM_MERGE
+// picks union (the default) or intersection. The airtable is also handed back so its airtable only features
+// (webhooks, activity) can be set up.
+func rosterSource(client *helix.Client) (roster.Source, *airtable.Airtable, error) {
+	kind := os.Getenv("ROSTER_SOURCE")
+	if len(kind) == 0 {
+		kind = "airtable"
+		if len(os.Getenv("ROSTER_FILE")) != 0 {
+			kind = "file"
+		}
+	}
+
+	var team roster.Source
+	if teamName, teamID := os.Getenv("TWITCH_TEAM"), os.Getenv("TWITCH_TEAM_ID"); len(teamName) != 0 || len(teamID) != 0 {
+		interval := time.Hour
+		if os.Getenv("TWITCH_TEAM_INTERVAL") != "" {
+			parsed, err := time.ParseDuration(os.Getenv("TWITCH_TEAM_INTERVAL"))
+			if err != nil {
+				return nil, nil, errors.Wrap(err, "invalid TWITCH_TEAM_INTERVAL")
+			}
+			if parsed <= 0 {
+				return nil, nil, errors.Errorf("invalid TWITCH_TEAM_INTERVAL %s, it has to be positive", parsed)
+			}
+			interval = parsed
+		}
+		team = twitchteam.New(client, teamName, teamID, interval)
+	}
+
+	var source roster.Source
+	var at *airtable.Airtable
+	switch kind {
+	case "team":
+		if team == nil {
+			return nil, nil, errors.New("missing TWITCH_TEAM")
+		}
+		return team, nil, nil
+	case "file":
+		if len(os.Getenv("ROSTER_FILE")) == 0 {
+			return nil, nil, errors.New("missing ROSTER_FILE")
+		}
+		source = roster.NewFile(os.Getenv("ROSTER_FILE"))
+	case "url":
+		if len(os.Getenv("ROSTER_URL")) == 0 {
+			return nil, nil, errors.New("missing ROSTER_URL")
+		}
+		interval := 5 * time.Minute
+		if os.Getenv("ROSTER_URL_INTERVAL") != "" {
+			parsed, err := time.ParseDuration(os.Getenv("ROSTER_URL_INTERVAL"))
+			if err != nil {
+				return nil, nil, errors.Wrap(err, "invalid ROSTER_URL_INTERVAL")
+			}
+			if parsed <= 0 {
+				return nil, nil, errors.Errorf("invalid ROSTER_URL_INTERVAL %s, it has to be positive", parsed)
+			}
+			interval = parsed
+		}
+		source = roster.NewRemote(os.Getenv("ROSTER_URL"), interval)
+	case "sql":
+		if len(os.Getenv("ROSTER_DATABASE_URL")) == 0 {
+			return nil, nil, errors.New("missing ROSTER_DATABASE_URL")
+		}
+		db, err := sqlroster.Open(os.Getenv("ROSTER_DATABASE_URL"))
+		if err != nil {
+			return nil, nil, err
+		}
+		if os.Getenv("ROSTER_DATABASE_POLL_INTERVAL") != "" {
+			parsed, err := time.ParseDuration(os.Getenv("ROSTER_DATABASE_POLL_INTERVAL"))
+			if err != nil {
+				return nil, nil, errors.Wrap(err, "invalid ROSTER_DATABASE_POLL_INTERVAL")
+			}
+			if parsed <= 0 {
+				return nil, nil, errors.Errorf("invalid ROSTER_DATABASE_POLL_INTERVAL %s, it has to be positive", parsed)
+			}
+			db.PollInterval = parsed
+		}
+		source = db
+	case "airtable":
+		apiKey := os.Getenv("AIRTABLE_API_KEY")
+		baseID := os.Getenv("AIRTABLE_BASE_ID")
+		tableName := os.Getenv("AIRTABLE_TABLE_NAME")
+		if len(baseID) == 0 {
+			// the base used to be hardcoded, keep existing deployments working until they set it
+			log.Warnf("AIRTABLE_BASE_ID is not set, falling back to %s, this default is deprecated and will go away", legacyAirtableBaseID)
+			baseID = legacyAirtableBaseID
+		}
+		if len(apiKey) == 0 || len(tableName) == 0 {
+			return nil, nil, errors.New("missing airtable config")
+		}
+
+		at = airtable.New(apiKey, baseID, tableName)
+		at.View = os.Getenv("AIRTABLE_VIEW")
+		at.FilterByFormula = os.Getenv("AIRTABLE_FILTER_BY_FORMULA")
+		if os.Getenv("AIRTABLE_PAGE_SIZE") != "" {
+			parsed, err := strconv.Atoi(os.Getenv("AIRTABLE_PAGE_SIZE"))
+			if err != nil {
+				return nil, nil, errors.Wrap(err, "invalid AIRTABLE_PAGE_SIZE")
+			}
+			at.PageSize = parsed
+		}
+		at.Columns = airtableColumns()
+		at.MACSecret = os.Getenv("AIRTABLE_WEBHOOK_MAC_SECRET")
+		source = at
+	default:
+		return nil, nil, errors.Errorf("unknown ROSTER_SOURCE %s", kind)
+	}
+
+	if team == nil {
+		return source, at, nil
+	}
+	mode := roster.MergeMode(os.Getenv("TWITCH_TEAM_MERGE"))
+	if len(mode) == 0 {
+		mode = roster.Union
+	}
+	if mode != roster.Union && mode != roster.Intersection {
+		return nil, nil, errors.Errorf("unknown TWITCH_TEAM_MERGE %s", mode)
+	}
+	// the roster's own entries go first so their announcement settings win over the bare team entries
+	return roster.Merge(mode, source, team), at, nil
+}
+
+// airtableColumns lets the column names of the roster table be overridden with AIRTABLE_*_COLUMN.
+func airtableColumns() airtable.Columns {
+	columns := airtable.DefaultColumns()
+	for env, column := range map[string]*string{
+		"AIRTABLE_LOGIN_COLUMN":        &columns.TwitchLogin,
+		"AIRTABLE_DISPLAY_NAME_COLUMN": &columns.DisplayName,
+		"AIRTABLE_ENABLED_COLUMN":      &columns.Enabled,
+		"AIRTABLE_MESSAGE_COLUMN":      &columns.Message,
+		"AIRTABLE_ROLE_COLUMN":         &columns.DiscordRole,
+		"AIRTABLE_CHANNEL_COLUMN":      &columns.Channel,
+		"AIRTABLE_TAGS_COLUMN":         &columns.Tags,
+		"AIRTABLE_STATUS_COLUMN":       &columns.Status,
+		"AIRTABLE_GAME_CHANGES_COLUMN": &columns.GameChanges,
+		"AIRTABLE_SHOUTOUTS_COLUMN":    &columns.Shoutouts,
+		"AIRTABLE_LAST_LIVE_COLUMN":    &columns.LastLiveAt,
+		"AIRTABLE_LAST_ENDED_COLUMN":   &columns.LastEndedAt,
+		"AIRTABLE_LAST_GAME_COLUMN":    &columns.LastGame,
+		"AIRTABLE_LAST_TITLE_COLUMN":   &columns.LastTitle,
+		"AIRTABLE_STREAM_COUNT_COLUMN": &columns.StreamCount,
+		"AIRTABLE_TWITCH_ID_COLUMN":    &columns.ResolvedID,
+	} {
+		if val, ok := os.LookupEnv(env); ok {
+			*column = val
+		}
+	}
+	return columns
+}
+
 func dclose(c io.Closer) {
 	if err := c.Close(); err != nil {
 		log.Fatal(err)
@@ -1,7 +1,25 @@
 package main
 
 import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sort"
+	"strings"
 	"testing"
+	"time"
+
+	helix "github.com/nicklaw5/helix/v2"
+
+	"github.com/halkeye/twitch_go_online/internal/conduit"
+	"github.com/halkeye/twitch_go_online/internal/discordsender"
+	"github.com/halkeye/twitch_go_online/internal/eventstream"
+	"github.com/halkeye/twitch_go_online/internal/storage"
+	"github.com/halkeye/twitch_go_online/internal/tokens"
 )
 
 func TestEscapeMarkdown(t *testing.T) {
@@ -24,3 +42,218 @@ func TestEscapeMarkdown(t *testing.T) {
 		})
 	}
 }
+
+func TestSummarizeChanges(t *testing.T) {
+	started := time.Date(2024, 1, 2, 3, 0, 0, 0, time.UTC)
+	ended := storage.Session{StartedAt: started, Game: "Chess"}
+
+	games, lines := summarizeChanges(ended, []storage.StreamChange{
+		{At: started, Game: "Art", Title: "drawing"},
+		{At: started.Add(90 * time.Minute), Game: "Chess", Title: "drawing"},
+		{At: started.Add(2 * time.Hour), Game: "Art", Title: "painting"},
+	})
+	if games != "Art, Chess" {
+		t.Errorf("games = %q; want %q", games, "Art, Chess")
+	}
+	if want := "0m: Art - drawing\n1h 30m: Chess - drawing\n2h 0m: Art - painting"; lines != want {
+		t.Errorf("lines = %q; want %q", lines, want)
+	}
+
+	// streams nobody sampled only know their last game
+	if games, lines := summarizeChanges(ended, nil); games != "Chess" || lines != "" {
+		t.Errorf("summarizeChanges() = %q, %q; want Chess and nothing else", games, lines)
+	}
+}
+
+func TestHandlerEventSub(t *testing.T) {
+	body := `{"challenge":"pong","subscription":{"type":"stream.online"}}`
+	request := func(secret string) *http.Request {
+		r := httptest.NewRequest(http.MethodPost, "/webhook/callbacks", strings.NewReader(body))
+		r.Header.Set("Twitch-Eventsub-Message-Id", "1")
+		r.Header.Set("Twitch-Eventsub-Message-Timestamp", "2024-01-02T03:04:05Z")
+		mac := hmac.New(sha256.New, []byte(secret))
+		mac.Write([]byte("1" + "2024-01-02T03:04:05Z" + body))
+		r.Header.Set("Twitch-Eventsub-Message-Signature", "sha256="+hex.EncodeToString(mac.Sum(nil)))
+		return r
+	}
+
+	// signed with the empty key, which anyone can do
+	w := httptest.NewRecorder()
+	handlerEventSub("", nil).ServeHTTP(w, request(""))
+	if w.Code != http.StatusForbidden {
+		t.Errorf("without a secret = %d; want every request refused", w.Code)
+	}
+
+	w = httptest.NewRecorder()
+	handlerEventSub("secret", nil).ServeHTTP(w, request("wrong"))
+	if w.Code != http.StatusForbidden {
+		t.Errorf("with the wrong signature = %d; want it refused", w.Code)
+	}
+
+	w = httptest.NewRecorder()
+	handlerEventSub("secret", nil).ServeHTTP(w, request("secret"))
+	if w.Code != http.StatusOK || w.Body.String() != "pong" {
+		t.Errorf("with the right signature = %d %q; want the challenge back", w.Code, w.Body.String())
+	}
+}
+
+func TestCatchUp(t *testing.T) {
+	var posted []string
+	discord := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body struct {
+			Content string `json:"content"`
+		}
+		_ = json.NewDecoder(r.Body).Decode(&body)
+		posted = append(posted, body.Content)
+		_, _ = w.Write([]byte(`{"id":"1"}`))
+	}))
+	defer discord.Close()
+
+	streams := map[string]helix.Stream{
+		"1": {ID: "fresh", UserID: "1", UserLogin: "halkeye", UserName: "Halkeye", StartedAt: time.Now().Add(-5 * time.Minute)},
+		"2": {ID: "old", UserID: "2", UserLogin: "marathon", UserName: "Marathon", StartedAt: time.Now().Add(-3 * time.Hour)},
+		"3": {ID: "announced", UserID: "3", UserLogin: "restarted", UserName: "Restarted", StartedAt: time.Now().Add(-5 * time.Minute)},
+	}
+	twitch := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/streams":
+			live := []helix.Stream{}
+			for _, id := range r.URL.Query()["user_id"] {
+				if stream, ok := streams[id]; ok {
+					live = append(live, stream)
+				}
+			}
+			_ = json.NewEncoder(w).Encode(map[string]interface{}{"data": live})
+		default:
+			t.Errorf("unexpected request to %s", r.URL.Path)
+		}
+	}))
+	defer twitch.Close()
+
+	client, err := helix.NewClient(&helix.Options{ClientID: "id", APIBaseURL: twitch.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	userIDs := []string{"1", "2", "3", "4"}
+
+	for _, mode := range []string{"announce", "record", ""} {
+		store, err := storage.OpenSQLite(filepath.Join(t.TempDir(), "storage.db"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer store.Close()
+		if err := store.MarkAnnounced("announced", "3", time.Now()); err != nil {
+			t.Fatal(err)
+		}
+
+		posted = nil
+		an := newAnnouncer(client, discordsender.New(discord.URL, "{{.ChannelName}} is live"), eventstream.New("", 0), store)
+		if err := catchUp(client, an, userIDs, time.Hour, mode); err != nil {
+			t.Fatal(err)
+		}
+
+		if mode == "announce" && (len(posted) != 1 || posted[0] != "Halkeye is live") {
+			t.Errorf("catchUp(announce) posted %q; want only halkeye's stream inside the window", posted)
+		}
+		if mode != "announce" && len(posted) != 0 {
+			t.Errorf("catchUp(%q) posted %q; want nothing announced", mode, posted)
+		}
+		if store.Announced("fresh") != (mode != "") {
+			t.Errorf("catchUp(%q) announced halkeye's stream = %v", mode, store.Announced("fresh"))
+		}
+		if session, ok, _ := store.Session("fresh"); mode != "" && (!ok || session.BroadcasterLogin != "halkeye") {
+			t.Errorf("catchUp(%q) session = %+v, %v; want halkeye's stream started", mode, session, ok)
+		}
+		if store.Announced("old") {
+			t.Errorf("catchUp(%q) picked up a stream from before the window", mode)
+		}
+		// announced before the restart, so channel updates for it have to keep working
+		if an.live["3"] != "announced" {
+			t.Errorf("catchUp(%q) live = %v; want the already announced stream picked back up", mode, an.live)
+		}
+	}
+}
+
+func TestWebhookSecret(t *testing.T) {
+	store, err := storage.OpenSQLite(filepath.Join(t.TempDir(), "storage.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	key, err := tokens.ParseKey(hex.EncodeToString([]byte(strings.Repeat("k", tokens.KeySize))))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cipher, err := tokens.NewCipher(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// saved before TOKEN_ENCRYPTION_KEY was set
+	if err := store.SaveWebhookSecret("old", "plain"); err != nil {
+		t.Fatal(err)
+	}
+	if secret, known, err := webhookSecret(store, cipher, "old"); err != nil || secret != "plain" || known != "" {
+		t.Errorf("webhookSecret(old) = %q, %q, %v; want the plain secret, to be sealed", secret, known, err)
+	}
+
+	if err := saveWebhookSecret(store, cipher, "new", "s3cret"); err != nil {
+		t.Fatal(err)
+	}
+	if saved, _, _ := store.WebhookSecret("new"); saved == "s3cret" {
+		t.Errorf("the secret was saved in plaintext")
+	}
+	if secret, known, err := webhookSecret(store, cipher, "new"); err != nil || secret != "s3cret" || known != "s3cret" {
+		t.Errorf("webhookSecret(new) = %q, %q, %v; want s3cret", secret, known, err)
+	}
+}
+
+func TestRegisterConduitSubscription(t *testing.T) {
+	var removed, created []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			_, _ = w.Write([]byte(`{"data":[
+				{"id":"online-1","type":"stream.online","status":"enabled","condition":{"broadcaster_user_id":"1"},"transport":{"method":"conduit","conduit_id":"c"}},
+				{"id":"cheer-1","type":"channel.cheer","status":"authorization_revoked","condition":{"broadcaster_user_id":"1"},"transport":{"method":"conduit","conduit_id":"c"}},
+				{"id":"online-2","type":"stream.online","status":"enabled","condition":{"broadcaster_user_id":"2"},"transport":{"method":"conduit","conduit_id":"c"}},
+				{"id":"cheer-2","type":"channel.cheer","status":"enabled","condition":{"broadcaster_user_id":"2"},"transport":{"method":"conduit","conduit_id":"c"}},
+				{"id":"online-3","type":"stream.online","status":"enabled","condition":{"broadcaster_user_id":"3"},"transport":{"method":"conduit","conduit_id":"c"}}
+			],"pagination":{}}`))
+		case http.MethodDelete:
+			removed = append(removed, r.URL.Query().Get("id"))
+			w.WriteHeader(http.StatusNoContent)
+		case http.MethodPost:
+			var body struct {
+				Type      string                  `json:"type"`
+				Condition helix.EventSubCondition `json:"condition"`
+			}
+			_ = json.NewDecoder(r.Body).Decode(&body)
+			created = append(created, body.Type+"/"+body.Condition.BroadcasterUserID)
+			w.WriteHeader(http.StatusAccepted)
+		}
+	}))
+	defer srv.Close()
+
+	client, err := helix.NewClient(&helix.Options{ClientID: "id", APIBaseURL: srv.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	conduits := conduit.New("id", func() string { return "app-token" })
+	conduits.BaseURL = srv.URL
+
+	// 1 revoked their authorization, 2 still has theirs, 3 left the roster
+	err = registerConduitSubscription(client, conduits, "c", []string{"1", "2"}, map[string]bool{"2": true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(removed)
+	if strings.Join(removed, ",") != "cheer-1,online-3" {
+		t.Errorf("removed %v; want 1's cheers and 3's subscriptions", removed)
+	}
+	for _, subscription := range created {
+		if strings.HasSuffix(subscription, "/1") && needsAuthorization(strings.TrimSuffix(subscription, "/1")) {
+			t.Errorf("created %s for someone who revoked their authorization", subscription)
+		}
+	}
+}
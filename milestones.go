@@ -0,0 +1,133 @@
+package main
+
+import (
+	"fmt"
+
+	helix "github.com/nicklaw5/helix/v2"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/halkeye/twitch_go_online/internal/discordsender"
+)
+
+// the kinds of milestones, and how they read in a message
+const (
+	milestoneFollowers   = "followers"
+	milestoneSubscribers = "subscribers"
+	milestoneBits        = "bits"
+)
+
+var milestoneLabels = map[string]string{
+	milestoneFollowers:   "followers",
+	milestoneSubscribers: "subscribers",
+	milestoneBits:        "bits cheered",
+}
+
+// milestoneMessageTmpl is the default MILESTONE_MESSAGE.
+const milestoneMessageTmpl = `{{.ChannelName}} just hit {{.Milestone}} {{.Kind}}! Go give them some love
+Channel URL: {{.ChannelUrl}}`
+
+// followed counts a new follower, twitch knows the total so it's asked with the broadcaster's own token.
+func (an *announcer) followed(followEvent helix.EventSubChannelFollowEvent) error {
+	client, err := an.broadcasterClient(followEvent.BroadcasterUserID)
+	if err != nil || client == nil {
+		return err
+	}
+	resp, err := client.GetChannelFollows(&helix.GetChannelFollowsParams{BroadcasterID: followEvent.BroadcasterUserID, First: 1})
+	if err != nil {
+		return errors.Wrap(err, "unable to count followers")
+	}
+	if resp.ErrorStatus != 0 {
+		return errors.Errorf("unable to count followers (%d) - %s", resp.ErrorStatus, resp.ErrorMessage)
+	}
+	return an.milestone(followEvent.BroadcasterUserID, followEvent.BroadcasterUserLogin, followEvent.BroadcasterUserName, milestoneFollowers, total(resp.Data.Total))
+}
+
+// subscribed counts a new subscriber the same way.
+func (an *announcer) subscribed(subscribeEvent helix.EventSubChannelSubscribeEvent) error {
+	client, err := an.broadcasterClient(subscribeEvent.BroadcasterUserID)
+	if err != nil || client == nil {
+		return err
+	}
+	resp, err := client.GetSubscriptions(&helix.SubscriptionsParams{BroadcasterID: subscribeEvent.BroadcasterUserID, First: 1})
+	if err != nil {
+		return errors.Wrap(err, "unable to count subscribers")
+	}
+	if resp.ErrorStatus != 0 {
+		return errors.Errorf("unable to count subscribers (%d) - %s", resp.ErrorStatus, resp.ErrorMessage)
+	}
+	return an.milestone(subscribeEvent.BroadcasterUserID, subscribeEvent.BroadcasterUserLogin, subscribeEvent.BroadcasterUserName, milestoneSubscribers, total(resp.Data.Total))
+}
+
+// cheered adds up bits, twitch has no running total of them so it counts from when they authorized us.
+func (an *announcer) cheered(cheerEvent helix.EventSubChannelCheerEvent) error {
+	return an.milestone(cheerEvent.BroadcasterUserID, cheerEvent.BroadcasterUserLogin, cheerEvent.BroadcasterUserName, milestoneBits, func(previous int) int {
+		return previous + cheerEvent.Bits
+	})
+}
+
+func (an *announcer) broadcasterClient(broadcasterID string) (*helix.Client, error) {
+	if an.authorizations == nil {
+		return nil, nil
+	}
+	client, err := an.authorizations.Client(broadcasterID)
+	if err == nil && client == nil {
+		log.Warnf("%s hasn't authorized us, so their milestones can't be counted", broadcasterID)
+	}
+	return client, err
+}
+
+// total is a count twitch gave us, it replaces whatever was counted before.
+func total(count int) func(int) int {
+	return func(int) int { return count }
+}
+
+// milestone records broadcasterID's new total of kind, worked out from the previous one, and announces the
+// highest milestone step they passed. The first total we hear of is where they already were, there's nothing
+// to celebrate about it.
+func (an *announcer) milestone(broadcasterID string, login string, name string, kind string, next func(previous int) int) error {
+	step := an.milestones[kind]
+	if step <= 0 {
+		return nil
+	}
+
+	reached, announce, err := an.countMilestone(broadcasterID, kind, step, next)
+	if err != nil || !announce {
+		return err
+	}
+
+	member := an.member(broadcasterID, login)
+	tmplParams := an.tmplParams(member, login, name, "")
+	tmplParams["Milestone"] = fmt.Sprint(reached)
+	tmplParams["Kind"] = milestoneLabels[kind]
+	msg := discordsender.Message{Template: an.milestoneMessage, Webhook: member.Channel}
+	if _, err := an.ds.SendMessage(msg, tmplParams); err != nil {
+		return errors.Wrap(err, "unable to announce milestone")
+	}
+	return nil
+}
+
+// countMilestone saves the new total, and whether it reached a step that wasn't announced yet.
+// Events for the same channel can arrive together, so nobody else reads the total until it's saved.
+func (an *announcer) countMilestone(broadcasterID string, kind string, step int, next func(previous int) int) (int, bool, error) {
+	an.milestoneMu.Lock()
+	defer an.milestoneMu.Unlock()
+
+	current, known, err := an.store.Milestone(broadcasterID, kind)
+	if err != nil {
+		return 0, false, err
+	}
+	current.Total = next(current.Total)
+	reached := current.Total / step * step
+	if !known && kind != milestoneBits {
+		current.Announced = reached
+	}
+	announce := reached > current.Announced
+	if announce {
+		current.Announced = reached
+	}
+	if err := an.store.SaveMilestone(current); err != nil {
+		return 0, false, err
+	}
+	return reached, announce, nil
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/robfig/cron/v3"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/halkeye/twitch_go_online/internal/discordsender"
+	"github.com/halkeye/twitch_go_online/internal/stats"
+	"github.com/halkeye/twitch_go_online/internal/storage"
+)
+
+// recapPeriod is how far back a recap looks
+const recapPeriod = 7 * 24 * time.Hour
+
+// recapStreamersLength is as much of a recap as the streamer list gets, discord cuts messages off at 2000
+// characters and the rest of the template needs room too. Whoever streamed least is left out first.
+const recapStreamersLength = 1200
+
+// recapMessageTmpl is the default RECAP_MESSAGE.
+const recapMessageTmpl = `**This week on the team** ({{.Since}} - {{.Until}})
+{{.StreamerCount}} streamers went live {{.Sessions}} times for {{.Hours}} hours.
+
+{{.Streamers}}
+
+Top games: {{.TopGames}}
+Longest stream: {{.LongestStream}}`
+
+// recapper posts a summary of the last week of streams.
+type recapper struct {
+	store storage.Store
+	ds    *discordsender.DiscordSender
+}
+
+// scheduleRecap posts a recap every time schedule (a cron expression, CRON_TZ= prefix and all) comes around.
+func scheduleRecap(schedule string, rc *recapper) (*cron.Cron, error) {
+	c := cron.New()
+	_, err := c.AddFunc(schedule, func() {
+		if err := rc.post(time.Now()); err != nil {
+			log.Error(err)
+		}
+	})
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid recap schedule %q", schedule)
+	}
+	c.Start()
+	return c, nil
+}
+
+// post sends the recap of the week leading up to now, weeks nobody streamed are skipped.
+func (rc *recapper) post(now time.Time) error {
+	since := now.Add(-recapPeriod)
+	sessions, err := rc.store.Sessions("", since)
+	if err != nil {
+		return errors.Wrap(err, "unable to load sessions for the recap")
+	}
+
+	changes, err := stats.Changes(rc.store, sessions)
+	if err != nil {
+		return errors.Wrap(err, "unable to load game changes for the recap")
+	}
+	report := stats.Compute(sessions, changes, since, now, now)
+	if report.Sessions == 0 {
+		log.Info("Nobody streamed this week, skipping the recap")
+		return nil
+	}
+	if err := rc.ds.Send(recapParams(report)); err != nil {
+		return errors.Wrap(err, "unable to post the recap")
+	}
+	return nil
+}
+
+// recapParams flattens a report into what the recap template can use.
+func recapParams(report stats.Report) map[string]string {
+	streamers := []string{}
+	length := 0
+	for i, streamer := range report.Streamers {
+		name := streamer.Name
+		if len(name) == 0 {
+			name = streamer.Login
+		}
+		streams := "streams"
+		if streamer.Sessions == 1 {
+			streams = "stream"
+		}
+		line := fmt.Sprintf("- %s: %d %s, %sh", escapeMarkdown(name), streamer.Sessions, streams, formatHours(streamer.Hours))
+		length += len(line) + 1
+		if length > recapStreamersLength {
+			streamers = append(streamers, fmt.Sprintf("- and %d more", len(report.Streamers)-i))
+			break
+		}
+		streamers = append(streamers, line)
+	}
+
+	games := []string{}
+	for _, game := range report.TopGames {
+		if len(game.Game) == 0 {
+			continue
+		}
+		games = append(games, fmt.Sprintf("%s (%sh)", escapeMarkdown(game.Game), formatHours(game.Hours)))
+	}
+
+	longest := ""
+	if stream := report.LongestStream; stream != nil {
+		name := stream.Name
+		if len(name) == 0 {
+			name = stream.Login
+		}
+		longest = fmt.Sprintf("%s, %s", escapeMarkdown(name), formatDuration(time.Duration(stream.Hours*float64(time.Hour))))
+		if len(stream.Game) != 0 {
+			longest += " of " + escapeMarkdown(stream.Game)
+		}
+	}
+
+	return map[string]string{
+		"Since":         report.Since.Format("Jan 2"),
+		"Until":         report.Until.Format("Jan 2"),
+		"Sessions":      fmt.Sprint(report.Sessions),
+		"Hours":         formatHours(report.Hours),
+		"StreamerCount": fmt.Sprint(len(report.Streamers)),
+		"Streamers":     strings.Join(streamers, "\n"),
+		"TopGames":      strings.Join(games, ", "),
+		"LongestStream": longest,
+	}
+}
+
+// formatHours drops the decimals when there aren't any.
+func formatHours(hours float64) string {
+	return strings.TrimSuffix(strings.TrimRight(fmt.Sprintf("%.2f", hours), "0"), ".")
+}
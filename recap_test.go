@@ -0,0 +1,56 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/halkeye/twitch_go_online/internal/stats"
+	"github.com/halkeye/twitch_go_online/internal/storage"
+)
+
+func TestRecapParams(t *testing.T) {
+	now := time.Date(2024, 3, 11, 9, 0, 0, 0, time.UTC)
+	sessions := []storage.Session{
+		{StreamID: "1", BroadcasterID: "a", BroadcasterName: "Alice", StartedAt: now.Add(-50 * time.Hour), EndedAt: now.Add(-47*time.Hour - 30*time.Minute), Game: "Art"},
+		{StreamID: "2", BroadcasterID: "b", BroadcasterLogin: "bob_", StartedAt: now.Add(-10 * time.Hour), EndedAt: now.Add(-9 * time.Hour), Game: "Chess"},
+	}
+
+	params := recapParams(stats.Compute(sessions, nil, now.Add(-recapPeriod), now, now))
+	want := map[string]string{
+		"Since":         "Mar 4",
+		"Until":         "Mar 11",
+		"Sessions":      "2",
+		"Hours":         "3.5",
+		"StreamerCount": "2",
+		"Streamers":     "- Alice: 1 stream, 2.5h\n- bob\\_: 1 stream, 1h",
+		"TopGames":      "Art (2.5h), Chess (1h)",
+		"LongestStream": "Alice, 2h 30m of Art",
+	}
+	for key, value := range want {
+		if params[key] != value {
+			t.Errorf("%s = %q; want %q", key, params[key], value)
+		}
+	}
+
+	// a big team's list has to fit in one discord message
+	sessions = nil
+	for i := 0; i < 200; i++ {
+		sessions = append(sessions, storage.Session{
+			StreamID:         fmt.Sprint(i),
+			BroadcasterID:    fmt.Sprint(i),
+			BroadcasterLogin: fmt.Sprintf("streamer_number_%d", i),
+			StartedAt:        now.Add(-10 * time.Hour),
+			EndedAt:          now.Add(-10*time.Hour + time.Duration(200-i)*time.Minute),
+		})
+	}
+	params = recapParams(stats.Compute(sessions, nil, now.Add(-recapPeriod), now, now))
+	lines := strings.Split(params["Streamers"], "\n")
+	if len(params["Streamers"]) > recapStreamersLength+len(lines[len(lines)-1])+1 || !strings.HasPrefix(lines[0], "- streamer\\_number\\_0:") {
+		t.Errorf("Streamers = %d characters starting with %q; want the longest streams within %d", len(params["Streamers"]), lines[0], recapStreamersLength)
+	}
+	if want := fmt.Sprintf("- and %d more", 200-len(lines)+1); lines[len(lines)-1] != want {
+		t.Errorf("Streamers ends with %q; want %q", lines[len(lines)-1], want)
+	}
+}
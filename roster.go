@@ -0,0 +1,268 @@
+package main
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	helix "github.com/nicklaw5/helix/v2"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/halkeye/twitch_go_online/internal/discordsender"
+	"github.com/halkeye/twitch_go_online/internal/poller"
+	"github.com/halkeye/twitch_go_online/internal/roster"
+)
+
+// validateRoster looks every enabled member up on twitch. A login that doesn't resolve but used to
+// (we have the user id it resolved to before) is either a rename, when the id still exists, or a banned
+// or suspended account.
+func validateRoster(client *helix.Client, members []roster.Member) ([]roster.StatusResult, error) {
+	enabled := []roster.Member{}
+	invalid := map[string]bool{}
+	for _, member := range members {
+		if !member.Enabled || len(member.TwitchLogin) == 0 {
+			continue
+		}
+		if !roster.ValidLogin(member.TwitchLogin) {
+			// whatever got typed in can't be a login, so it is not found rather than a rename
+			invalid[member.TwitchLogin] = true
+		}
+		enabled = append(enabled, member)
+	}
+
+	valid := []string{}
+	for _, login := range roster.Logins(enabled) {
+		if !invalid[login] {
+			valid = append(valid, login)
+		}
+	}
+	users, err := lookupUsers(client, valid)
+	if err != nil {
+		return nil, err
+	}
+	byLogin := map[string]helix.User{}
+	for _, user := range users {
+		byLogin[user.Login] = user
+	}
+
+	missingIDs := []string{}
+	for _, member := range enabled {
+		if _, ok := byLogin[member.TwitchLogin]; !ok && !invalid[member.TwitchLogin] && len(member.ResolvedID) != 0 {
+			missingIDs = append(missingIDs, member.ResolvedID)
+		}
+	}
+	byID, err := lookupUserIDs(client, missingIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	results := []roster.StatusResult{}
+	for _, member := range enabled {
+		result := roster.StatusResult{Member: member, Status: roster.StatusNotFound}
+		if invalid[member.TwitchLogin] {
+			results = append(results, result)
+			continue
+		}
+		if user, ok := byLogin[member.TwitchLogin]; ok {
+			result.Status = roster.StatusOK
+			result.UserID = user.ID
+		} else if user, ok := byID[member.ResolvedID]; ok {
+			result.Status = roster.StatusOK
+			result.UserID = user.ID
+			result.Login = user.Login
+		} else if len(member.ResolvedID) != 0 {
+			result.Status = roster.StatusBanned
+		}
+		results = append(results, result)
+	}
+	return results, nil
+}
+
+// lookupUserIDs returns user id => user for the ids that still exist.
+func lookupUserIDs(client *helix.Client, ids []string) (map[string]helix.User, error) {
+	users := map[string]helix.User{}
+
+	for start := 0; start < len(ids); start += poller.BatchSize {
+		end := start + poller.BatchSize
+		if end > len(ids) {
+			end = len(ids)
+		}
+
+		resp, err := client.GetUsers(&helix.UsersParams{IDs: ids[start:end]})
+		if err != nil {
+			return nil, errors.Wrap(err, "Error getting users")
+		}
+		if resp.ErrorStatus != 0 {
+			return nil, errors.Errorf("Error getting users (%d) - %s", resp.ErrorStatus, resp.ErrorMessage)
+		}
+		for _, user := range resp.Data.Users {
+			users[user.ID] = user
+		}
+	}
+
+	return users, nil
+}
+
+// checkRoster validates members, logs anything that doesn't resolve, and writes the results back to the source.
+// Members come back with their status and the user id they resolved to, renamed members with their new login,
+// and admins get told about the rename.
+func checkRoster(client *helix.Client, source roster.Source, admins *discordsender.DiscordSender, members []roster.Member) ([]roster.Member, error) {
+	results, err := validateRoster(client, members)
+	if err != nil {
+		return members, errors.Wrap(err, "unable to validate roster")
+	}
+
+	checked := map[string]roster.StatusResult{}
+	counts := map[string]int{}
+	for _, result := range results {
+		checked[result.Member.RecordID] = result
+		counts[result.Status]++
+		if result.Status != roster.StatusOK {
+			log.WithField("record", result.Member.RecordID).Warnf("roster entry %q is %s on twitch", result.Member.TwitchLogin, result.Status)
+		}
+		if len(result.Login) != 0 && result.Login != result.Member.TwitchLogin {
+			log.WithField("user_id", result.UserID).Infof("roster entry %q renamed to %q", result.Member.TwitchLogin, result.Login)
+			if admins != nil {
+				err := admins.Send(map[string]string{
+					"OldLogin": result.Member.TwitchLogin,
+					"NewLogin": result.Login,
+					"UserID":   result.UserID,
+				})
+				if err != nil {
+					log.Error(errors.Wrap(err, "unable to notify admins of rename"))
+				}
+			}
+		}
+	}
+	log.WithFields(log.Fields{
+		"ok":        counts[roster.StatusOK],
+		"not_found": counts[roster.StatusNotFound],
+		"banned":    counts[roster.StatusBanned],
+	}).Infof("validated %d roster entries", len(results))
+
+	// not every source can be written to, a roster file is left for people to edit
+	if writer, ok := source.(roster.StatusWriter); ok {
+		if err := writer.SetStatuses(results); err != nil {
+			log.Error(errors.Wrap(err, "unable to write roster status"))
+		}
+	}
+
+	updated := []roster.Member{}
+	for _, member := range members {
+		if result, ok := checked[member.RecordID]; ok {
+			member.Status = result.Status
+			if result.Status == roster.StatusOK {
+				member.ResolvedID = result.UserID
+			}
+			if len(result.Login) != 0 {
+				member.TwitchLogin = result.Login
+			}
+		}
+		updated = append(updated, member)
+	}
+	return updated, nil
+}
+
+// rosterSync keeps the announcer, subscriptions and poller on the same roster, whether it changed in the
+// source or validation found a rename. Subscriptions and polling go by user id, so a rename touches neither.
+type rosterSync struct {
+	client *helix.Client
+	source roster.Source
+	admins *discordsender.DiscordSender
+	an     *announcer
+	sm     *subscriptionManager
+	// p is optional, it is only there when polling
+	p *poller.Poller
+
+	mu sync.Mutex
+}
+
+// changed is the roster.ChangeFunc of the source. Members the source doesn't know the user id of keep the
+// one validation found last time, added members and changed logins get validated.
+func (rs *rosterSync) changed(changes []roster.Change, members []roster.Member) {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+
+	// the source may hold on to members, so fill in a copy
+	members = append([]roster.Member{}, members...)
+	known := map[string]roster.Member{}
+	for _, member := range rs.an.roster() {
+		known[member.RecordID] = member
+	}
+
+	unchecked := []roster.Member{}
+	for i, member := range members {
+		previous, ok := known[member.RecordID]
+		if ok && strings.EqualFold(previous.TwitchLogin, member.TwitchLogin) {
+			if len(member.ResolvedID) == 0 {
+				members[i].ResolvedID = previous.ResolvedID
+			}
+			if len(member.Status) == 0 {
+				members[i].Status = previous.Status
+			}
+			continue
+		}
+		if member.Enabled && len(member.TwitchLogin) != 0 {
+			unchecked = append(unchecked, member)
+		}
+	}
+
+	if len(unchecked) != 0 {
+		// a rename gets written back to the source, which lands here again with the new login
+		checked, err := checkRoster(rs.client, rs.source, rs.admins, unchecked)
+		if err != nil {
+			log.Error(err)
+		}
+		byRecord := map[string]roster.Member{}
+		for _, member := range checked {
+			byRecord[member.RecordID] = member
+		}
+		for i, member := range members {
+			if checked, ok := byRecord[member.RecordID]; ok {
+				members[i] = checked
+			}
+		}
+	}
+
+	added, removed := roster.LoginChanges(changes)
+	log.WithFields(log.Fields{"added": added, "removed": removed}).Info("roster changed")
+	rs.apply(members)
+}
+
+// recheck validates the whole roster every interval, so renames are picked up without a restart.
+func (rs *rosterSync) recheck(interval time.Duration) {
+	for range time.Tick(interval) {
+		rs.check()
+	}
+}
+
+func (rs *rosterSync) check() {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+
+	members, err := checkRoster(rs.client, rs.source, rs.admins, rs.an.roster())
+	if err != nil {
+		log.Error(err)
+		return
+	}
+	rs.apply(members)
+}
+
+// apply swaps in members and moves subscriptions and polling over to their user ids, rs.mu must be held.
+func (rs *rosterSync) apply(members []roster.Member) {
+	previous := roster.UserIDs(rs.an.roster())
+	rs.an.setMembers(members)
+
+	userIDs := roster.UserIDs(members)
+	added, removed := roster.IDChanges(previous, userIDs)
+	if len(added) == 0 && len(removed) == 0 {
+		return
+	}
+	if err := rs.sm.ApplyChanges(userIDs, added, removed); err != nil {
+		log.Error(errors.Wrap(err, "Unable to create subscriptions"))
+	}
+	if rs.p != nil {
+		rs.p.SetUserIDs(userIDs)
+	}
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	helix "github.com/nicklaw5/helix/v2"
+
+	"github.com/halkeye/twitch_go_online/internal/roster"
+)
+
+// usersClient is a twitch client whose GetUsers knows about users, and fails batches with bad logins like twitch does.
+func usersClient(t *testing.T, users []helix.User) *helix.Client {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		for _, login := range query["login"] {
+			if !roster.ValidLogin(login) {
+				w.WriteHeader(http.StatusBadRequest)
+				_, _ = w.Write([]byte(`{"error":"Bad Request","status":400,"message":"Invalid login names, emails or IDs in request"}`))
+				return
+			}
+		}
+		found := []helix.User{}
+		for _, user := range users {
+			for _, login := range query["login"] {
+				if login == user.Login {
+					found = append(found, user)
+				}
+			}
+			for _, id := range query["id"] {
+				if id == user.ID {
+					found = append(found, user)
+				}
+			}
+		}
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"data": found})
+	}))
+	t.Cleanup(srv.Close)
+
+	client, err := helix.NewClient(&helix.Options{ClientID: "id", APIBaseURL: srv.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return client
+}
+
+func TestValidateRoster(t *testing.T) {
+	client := usersClient(t, []helix.User{
+		{ID: "1", Login: "halkeye"},
+		{ID: "2", Login: "newname"},
+	})
+
+	results, err := validateRoster(client, []roster.Member{
+		{RecordID: "a", TwitchLogin: "halkeye", Enabled: true},
+		{RecordID: "b", TwitchLogin: "oldname", ResolvedID: "2", Enabled: true},
+		{RecordID: "c", TwitchLogin: "gone", ResolvedID: "3", Enabled: true},
+		{RecordID: "d", TwitchLogin: "typo", Enabled: true},
+		{RecordID: "e", TwitchLogin: "disabled"},
+		{RecordID: "f", TwitchLogin: "youtube.com", ResolvedID: "2", Enabled: true},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]roster.StatusResult{
+		"a": {Status: roster.StatusOK, UserID: "1"},
+		"b": {Status: roster.StatusOK, UserID: "2", Login: "newname"},
+		"c": {Status: roster.StatusBanned},
+		"d": {Status: roster.StatusNotFound},
+		"f": {Status: roster.StatusNotFound},
+	}
+	if len(results) != len(want) {
+		t.Fatalf("got %d results; want %d", len(results), len(want))
+	}
+	for _, result := range results {
+		w := want[result.Member.RecordID]
+		if result.Status != w.Status || result.UserID != w.UserID || result.Login != w.Login {
+			t.Errorf("%s: got %+v; want %+v", result.Member.RecordID, result, w)
+		}
+	}
+}
+
+func TestRosterSync(t *testing.T) {
+	client := usersClient(t, []helix.User{
+		{ID: "1", Login: "newname"},
+		{ID: "2", Login: "added"},
+	})
+
+	an := newAnnouncer(client, nil, nil, nil)
+	an.setMembers([]roster.Member{{RecordID: "a", TwitchLogin: "oldname", ResolvedID: "1", Enabled: true, Status: roster.StatusOK}})
+	sm := newSubscriptionManager(client, []string{"1"})
+	rs := &rosterSync{client: client, an: an, sm: sm}
+
+	// the recheck finds the rename, which changes nothing about who is subscribed
+	rs.check()
+	if member := an.member("1", ""); member.TwitchLogin != "newname" {
+		t.Errorf("member 1 = %+v; want them renamed", member)
+	}
+	if strings.Join(sm.userIDs, ",") != "1" {
+		t.Errorf("userIDs = %v; want [1]", sm.userIDs)
+	}
+
+	// the source has no user ids, the renamed member keeps theirs and the new one gets looked up
+	current := []roster.Member{
+		{RecordID: "a", TwitchLogin: "newname", Enabled: true},
+		{RecordID: "b", TwitchLogin: "added", Enabled: true},
+	}
+	rs.changed([]roster.Change{{Kind: roster.MemberAdded, Member: current[1]}}, current)
+	if strings.Join(sm.userIDs, ",") != "1,2" {
+		t.Errorf("userIDs = %v; want [1 2]", sm.userIDs)
+	}
+	if member := an.member("", "added"); member.ResolvedID != "2" || member.Status != roster.StatusOK {
+		t.Errorf("added member = %+v; want them resolved", member)
+	}
+
+	rs.changed([]roster.Change{{Kind: roster.MemberRemoved, Previous: current[0]}}, current[1:])
+	if strings.Join(sm.userIDs, ",") != "2" {
+		t.Errorf("userIDs = %v; want [2]", sm.userIDs)
+	}
+}
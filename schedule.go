@@ -0,0 +1,239 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	helix "github.com/nicklaw5/helix/v2"
+	"github.com/pkg/errors"
+	"github.com/robfig/cron/v3"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/halkeye/twitch_go_online/internal/discordsender"
+	"github.com/halkeye/twitch_go_online/internal/roster"
+	"github.com/halkeye/twitch_go_online/internal/schedule"
+	"github.com/halkeye/twitch_go_online/internal/storage"
+)
+
+// reminderMessageTmpl is the default SCHEDULE_REMINDER_MESSAGE.
+const reminderMessageTmpl = `**{{.ChannelName}}** goes live in {{.StartsIn}} ({{.StartsAt}}): {{.Title}}{{if .Game}} - {{.Game}}{{end}}
+{{.ChannelUrl}}`
+
+// agendaMessageTmpl is the default AGENDA_MESSAGE.
+const agendaMessageTmpl = `**Today on the team** ({{.Date}})
+{{.Streams}}`
+
+// agendaPeriod is how far ahead the agenda looks
+const agendaPeriod = 24 * time.Hour
+
+// reminderKey is a single day of a (possibly recurring) schedule segment.
+type reminderKey struct {
+	broadcasterID string
+	segmentID     string
+	startsAt      int64
+}
+
+// scheduler reads the roster's twitch schedules, reminds everyone shortly before a scheduled stream
+// and posts the day's agenda.
+type scheduler struct {
+	client *helix.Client
+	an     *announcer
+	store  storage.Store
+	// reminderMessage is posted lead before each scheduled stream, to the member's channel like their go live message
+	reminderMessage string
+	lead            time.Duration
+	interval        time.Duration
+	// agenda gets the daily agenda, nil skips it
+	agenda   *discordsender.DiscordSender
+	location *time.Location
+
+	mu sync.Mutex
+	// reminders waiting for their time to come
+	pending map[reminderKey]*time.Timer
+}
+
+func newScheduler(client *helix.Client, an *announcer, store storage.Store) *scheduler {
+	return &scheduler{
+		client:          client,
+		an:              an,
+		store:           store,
+		reminderMessage: reminderMessageTmpl,
+		lead:            15 * time.Minute,
+		interval:        5 * time.Minute,
+		location:        time.UTC,
+		pending:         map[reminderKey]*time.Timer{},
+	}
+}
+
+// Run checks for upcoming streams every interval until ctx is cancelled.
+func (s *scheduler) Run(ctx context.Context) {
+	log.Infof("Checking twitch schedules for streams starting within %s every %s", s.lead, s.interval)
+
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		s.check(time.Now())
+	}
+}
+
+// check queues reminders for every stream whose reminder is due before the next check, and sends the ones that
+// are already due. Queued reminders for streams that have since been canceled or moved are dropped.
+func (s *scheduler) check(now time.Time) {
+	segments, failed := s.segments(now, now.Add(s.lead+s.interval))
+
+	upcoming := map[reminderKey]bool{}
+	for _, segment := range segments {
+		key := reminderKey{broadcasterID: segment.BroadcasterID, segmentID: segment.ID, startsAt: segment.StartTime.Unix()}
+		upcoming[key] = true
+		if s.store.Reminded(segment.ID, segment.StartTime) {
+			continue
+		}
+
+		wait := segment.StartTime.Add(-s.lead).Sub(now)
+		s.mu.Lock()
+		_, queued := s.pending[key]
+		if !queued && wait > 0 {
+			s.pending[key] = time.AfterFunc(wait, func() {
+				s.mu.Lock()
+				delete(s.pending, key)
+				s.mu.Unlock()
+				s.remind(segment, time.Now())
+			})
+		}
+		s.mu.Unlock()
+
+		if !queued && wait <= 0 {
+			s.remind(segment, now)
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for key, timer := range s.pending {
+		// a schedule we couldn't read this time doesn't mean the stream was canceled
+		if !upcoming[key] && !failed[key.broadcasterID] {
+			timer.Stop()
+			delete(s.pending, key)
+		}
+	}
+}
+
+// remind posts the reminder for segment, once.
+func (s *scheduler) remind(segment schedule.Segment, now time.Time) {
+	if s.store.Reminded(segment.ID, segment.StartTime) {
+		return
+	}
+
+	member := s.an.member(segment.BroadcasterID, segment.BroadcasterLogin)
+	params := s.an.tmplParams(member, segment.BroadcasterLogin, segment.BroadcasterName, segment.Game)
+	params["Title"] = escapeMarkdown(segment.Title)
+	params["StartsAt"] = segment.StartTime.In(s.location).Format("15:04 MST")
+	params["StartsIn"] = formatDuration(segment.StartTime.Sub(now))
+
+	msg := discordsender.Message{Template: s.reminderMessage, Webhook: member.Channel}
+	if _, err := s.an.ds.SendMessage(msg, params); err != nil {
+		log.Error(errors.Wrapf(err, "unable to send the schedule reminder for %s", segment.BroadcasterLogin))
+		return
+	}
+	if err := s.store.MarkReminded(segment.ID, segment.StartTime, now); err != nil {
+		log.Error(err)
+	}
+}
+
+// segments returns every enabled member's scheduled streams starting between from and until, soonest first,
+// along with the broadcasters whose schedule couldn't be read.
+func (s *scheduler) segments(from time.Time, until time.Time) ([]schedule.Segment, map[string]bool) {
+	members := s.an.roster()
+	logins := map[string]string{}
+	for _, member := range members {
+		logins[member.ResolvedID] = member.TwitchLogin
+	}
+	userIDs := roster.UserIDs(members)
+
+	// validation fills in ResolvedID, this catches members it hasn't got to, like ones just added to a roster file
+	unresolved := []string{}
+	for _, member := range members {
+		if member.Enabled && len(member.ResolvedID) == 0 && len(member.TwitchLogin) != 0 && member.Status != roster.StatusNotFound {
+			unresolved = append(unresolved, member.TwitchLogin)
+		}
+	}
+	if len(unresolved) != 0 {
+		users, err := lookupUsers(s.client, unresolved)
+		if err != nil {
+			log.Error(errors.Wrap(err, "unable to look up roster members for their schedules"))
+		}
+		for _, user := range users {
+			logins[user.ID] = user.Login
+			userIDs = append(userIDs, user.ID)
+		}
+	}
+
+	all := []schedule.Segment{}
+	failed := map[string]bool{}
+	for _, userID := range userIDs {
+		segments, err := schedule.Fetch(s.client, userID, from, until)
+		if err != nil {
+			log.Error(errors.Wrapf(err, "unable to read %s's schedule", logins[userID]))
+			failed[userID] = true
+			continue
+		}
+		all = append(all, segments...)
+	}
+	sort.SliceStable(all, func(i, j int) bool { return all[i].StartTime.Before(all[j].StartTime) })
+	return all, failed
+}
+
+// scheduleAgenda posts the agenda every time schedule (a cron expression, CRON_TZ= prefix and all) comes around.
+func scheduleAgenda(cronSchedule string, s *scheduler) (*cron.Cron, error) {
+	c := cron.New()
+	_, err := c.AddFunc(cronSchedule, func() {
+		if err := s.postAgenda(time.Now()); err != nil {
+			log.Error(err)
+		}
+	})
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid agenda schedule %q", cronSchedule)
+	}
+	c.Start()
+	return c, nil
+}
+
+// postAgenda sends the streams scheduled over the next day, days nobody scheduled anything are skipped.
+func (s *scheduler) postAgenda(now time.Time) error {
+	segments, _ := s.segments(now, now.Add(agendaPeriod))
+	if len(segments) == 0 {
+		log.Info("Nobody has anything scheduled today, skipping the agenda")
+		return nil
+	}
+
+	streams := []string{}
+	for _, segment := range segments {
+		member := s.an.member(segment.BroadcasterID, segment.BroadcasterLogin)
+		params := s.an.tmplParams(member, segment.BroadcasterLogin, segment.BroadcasterName, segment.Game)
+		line := fmt.Sprintf("- %s **%s**", segment.StartTime.In(s.location).Format("15:04 MST"), params["ChannelName"])
+		if len(segment.Title) != 0 {
+			line += ": " + escapeMarkdown(segment.Title)
+		}
+		if len(params["Game"]) != 0 {
+			line += " - " + params["Game"]
+		}
+		streams = append(streams, line)
+	}
+
+	err := s.agenda.Send(map[string]string{
+		"Date":        now.In(s.location).Format("Monday, Jan 2"),
+		"StreamCount": fmt.Sprint(len(segments)),
+		"Streams":     strings.Join(streams, "\n"),
+	})
+	return errors.Wrap(err, "unable to post the agenda")
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	helix "github.com/nicklaw5/helix/v2"
+
+	"github.com/halkeye/twitch_go_online/internal/discordsender"
+	"github.com/halkeye/twitch_go_online/internal/roster"
+	"github.com/halkeye/twitch_go_online/internal/storage"
+)
+
+func TestScheduler(t *testing.T) {
+	var mu sync.Mutex
+	var posted []string
+	discord := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body struct {
+			Content string `json:"content"`
+		}
+		_ = json.NewDecoder(r.Body).Decode(&body)
+		mu.Lock()
+		posted = append(posted, body.Content)
+		mu.Unlock()
+		_, _ = w.Write([]byte(`{"id":"1"}`))
+	}))
+	defer discord.Close()
+
+	now := time.Date(2024, 1, 2, 18, 0, 0, 0, time.UTC)
+	canceled := map[string]bool{"canceled": true}
+	twitch := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("broadcaster_id") != "1" {
+			t.Errorf("asked for the schedule of %s", r.URL.Query().Get("broadcaster_id"))
+		}
+		segments := []map[string]interface{}{}
+		for _, segment := range []struct {
+			id    string
+			start time.Duration
+		}{{"soon", 10 * time.Minute}, {"canceled", 12 * time.Minute}, {"later", 18 * time.Minute}, {"tonight", 6 * time.Hour}} {
+			canceledUntil := ""
+			mu.Lock()
+			if canceled[segment.id] {
+				canceledUntil = now.Add(time.Hour).Format(time.RFC3339)
+			}
+			mu.Unlock()
+			segments = append(segments, map[string]interface{}{
+				"id":             segment.id,
+				"start_time":     now.Add(segment.start).Format(time.RFC3339),
+				"title":          segment.id + " stream",
+				"canceled_until": canceledUntil,
+				"category":       map[string]string{"name": "Art"},
+			})
+		}
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{
+			"data": map[string]interface{}{"broadcaster_id": "1", "broadcaster_login": "halkeye", "broadcaster_name": "Halkeye", "segments": segments},
+		})
+	}))
+	defer twitch.Close()
+
+	client, err := helix.NewClient(&helix.Options{ClientID: "id", APIBaseURL: twitch.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	store, err := storage.OpenSQLite(filepath.Join(t.TempDir(), "storage.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	an := newAnnouncer(client, discordsender.New(discord.URL, ""), nil, store)
+	an.setMembers([]roster.Member{
+		{TwitchLogin: "halkeye", ResolvedID: "1", Enabled: true},
+		{TwitchLogin: "off", ResolvedID: "2"},
+	})
+	sc := newScheduler(client, an, store)
+	sc.reminderMessage = "{{.ChannelName}} in {{.StartsIn}} at {{.StartsAt}}: {{.Title}}"
+	sc.agenda = discordsender.New(discord.URL, agendaMessageTmpl)
+
+	sc.check(now)
+	sc.check(now.Add(time.Minute))
+	mu.Lock()
+	if len(posted) != 1 || posted[0] != "Halkeye in 10m at 18:10 UTC: soon stream" {
+		t.Errorf("posted = %q; want one reminder for the stream starting soon", posted)
+	}
+	posted = nil
+	canceled["later"] = true
+	mu.Unlock()
+
+	sc.mu.Lock()
+	if len(sc.pending) != 1 {
+		t.Errorf("pending = %v; want the later stream queued", sc.pending)
+	}
+	sc.mu.Unlock()
+
+	sc.check(now.Add(2 * time.Minute))
+	sc.mu.Lock()
+	if len(sc.pending) != 0 {
+		t.Errorf("pending = %v; want the canceled stream dropped", sc.pending)
+	}
+	sc.mu.Unlock()
+
+	if err := sc.postAgenda(now); err != nil {
+		t.Fatal(err)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(posted) != 1 || !strings.Contains(posted[0], "- 18:10 UTC **Halkeye**: soon stream - Art\n- 00:00 UTC **Halkeye**: tonight stream - Art") || strings.Contains(posted[0], "later") {
+		t.Errorf("agenda = %q", posted)
+	}
+}
+
+func TestSchedulerWithoutTwitchIDs(t *testing.T) {
+	now := time.Date(2024, 1, 2, 18, 0, 0, 0, time.UTC)
+	var mu sync.Mutex
+	fetched := []string{}
+	twitch := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/users":
+			users := []helix.User{}
+			for _, login := range r.URL.Query()["login"] {
+				users = append(users, helix.User{ID: "id-" + login, Login: login})
+			}
+			_ = json.NewEncoder(w).Encode(map[string]interface{}{"data": users})
+		case "/schedule":
+			id := r.URL.Query().Get("broadcaster_id")
+			mu.Lock()
+			fetched = append(fetched, id)
+			mu.Unlock()
+			_ = json.NewEncoder(w).Encode(map[string]interface{}{
+				"data": map[string]interface{}{"broadcaster_id": id, "segments": []map[string]interface{}{
+					{"id": "segment-" + id, "start_time": now.Add(time.Hour).Format(time.RFC3339), "title": "stream"},
+				}},
+			})
+		default:
+			t.Errorf("unexpected request to %s", r.URL.Path)
+		}
+	}))
+	defer twitch.Close()
+
+	client, err := helix.NewClient(&helix.Options{ClientID: "id", APIBaseURL: twitch.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a roster file only has logins, validation is what finds the user ids
+	path := filepath.Join(t.TempDir(), "roster.yaml")
+	if err := os.WriteFile(path, []byte("members:\n  - login: halkeye\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	source := roster.NewFile(path)
+	members, err := source.Members()
+	if err != nil {
+		t.Fatal(err)
+	}
+	members, err = checkRoster(client, source, nil, members)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// and someone validation hasn't got to yet
+	members = append(members, roster.Member{RecordID: "new", TwitchLogin: "new", Enabled: true})
+
+	an := newAnnouncer(client, nil, nil, nil)
+	an.setMembers(members)
+	segments, failed := newScheduler(client, an, nil).segments(now, now.Add(agendaPeriod))
+	if len(segments) != 2 || len(failed) != 0 {
+		t.Errorf("segments() = %+v, %v; want both members' streams", segments, failed)
+	}
+	sort.Strings(fetched)
+	if strings.Join(fetched, ",") != "id-halkeye,id-new" {
+		t.Errorf("fetched the schedules of %v; want halkeye's and new's", fetched)
+	}
+}
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"sync"
+	"time"
+
+	helix "github.com/nicklaw5/helix/v2"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/halkeye/twitch_go_online/internal/conduit"
+	"github.com/halkeye/twitch_go_online/internal/storage"
+	"github.com/halkeye/twitch_go_online/internal/tokens"
+)
+
+// subscriptionManager remembers the current roster and transport, so subscriptions can be
+// rebuilt whenever either of them changes (airtable edits, new websocket sessions).
+type subscriptionManager struct {
+	client *helix.Client
+
+	mu        sync.Mutex
+	userIDs   []string
+	transport helix.EventSubTransport
+	conduits  *conduit.Client
+	conduitID string
+	// store is optional, it gets a copy of the subscriptions after every change
+	store storage.Store
+	// authorizations is optional, without it nobody gets the subscriptions that need their authorization
+	authorizations *tokens.Vault
+}
+
+func newSubscriptionManager(client *helix.Client, userIDs []string) *subscriptionManager {
+	return &subscriptionManager{
+		client:  client,
+		userIDs: userIDs,
+	}
+}
+
+func (sm *subscriptionManager) SetUserIDs(userIDs []string) error {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+
+	sm.userIDs = userIDs
+	return sm.registerAndSave()
+}
+
+func (sm *subscriptionManager) SetTransport(transport helix.EventSubTransport) error {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+
+	sm.transport = transport
+	return sm.registerAndSave()
+}
+
+// SetConduit sends every subscription through conduitID instead of a transport of our own.
+func (sm *subscriptionManager) SetConduit(conduits *conduit.Client, conduitID string) error {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+
+	sm.conduits = conduits
+	sm.conduitID = conduitID
+	sm.transport = helix.EventSubTransport{Method: conduit.Method}
+	return sm.registerAndSave()
+}
+
+// ApplyChanges subscribes the added user ids and unsubscribes the removed ones, leaving everyone else's subscriptions alone.
+func (sm *subscriptionManager) ApplyChanges(userIDs []string, added []string, removed []string) error {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+
+	sm.userIDs = userIDs
+	if len(sm.transport.Method) == 0 {
+		return nil
+	}
+
+	if len(removed) != 0 {
+		removedIds := map[string]bool{}
+		for _, userId := range removed {
+			removedIds[userId] = true
+			log.Infof("No longer monitoring: %s", userId)
+		}
+
+		subs, err := sm.list()
+		if err != nil {
+			return errors.Wrap(err, "Error getting subscriptions")
+		}
+		for _, sub := range subs {
+			if removedIds[subscriptionUser(sub)] && sm.owns(sub) {
+				if _, err := sm.client.RemoveEventSubSubscription(sub.ID); err != nil {
+					return errors.Wrap(err, "Error removing subscriptions")
+				}
+			}
+		}
+	}
+
+	if len(added) != 0 {
+		authorized := sm.authorized()
+		for _, userId := range added {
+			log.Infof("Monitoring: %s", userId)
+			if err := createSubscriptions(userId, subscriptionTypesFor(userId, authorized, sm.transport), sm.create); err != nil {
+				return err
+			}
+		}
+	}
+
+	sm.save()
+	return nil
+}
+
+// Authorize creates the subscriptions userId just authorized us to make.
+func (sm *subscriptionManager) Authorize(userId string) error {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+
+	// websocket sessions can't have them, see subscriptionTypesFor
+	if len(sm.transport.Method) == 0 || sm.transport.Method == "websocket" {
+		return nil
+	}
+	if err := createSubscriptions(userId, authorizedSubscriptionTypes, sm.create); err != nil {
+		return err
+	}
+	sm.save()
+	return nil
+}
+
+// authorized is who authorized us, as far as the vault knows.
+func (sm *subscriptionManager) authorized() map[string]bool {
+	if sm.authorizations == nil {
+		return map[string]bool{}
+	}
+	authorized, err := sm.authorizations.Authorized()
+	if err != nil {
+		log.Error(err)
+		return map[string]bool{}
+	}
+	return authorized
+}
+
+// list returns the app's subscriptions, only the ones of our own conduit when there is one.
+func (sm *subscriptionManager) list() ([]helix.EventSubSubscription, error) {
+	if sm.transport.Method == conduit.Method {
+		return sm.conduits.Subscriptions(sm.conduitID)
+	}
+	return listSubscriptions(sm.client)
+}
+
+func (sm *subscriptionManager) owns(sub helix.EventSubSubscription) bool {
+	if sm.transport.Method == conduit.Method {
+		return sub.Transport.Method == conduit.Method
+	}
+	return ownSubscription(sub, sm.transport)
+}
+
+func (sm *subscriptionManager) create(userId string, subType string) error {
+	if sm.transport.Method == conduit.Method {
+		return sm.conduits.CreateSubscription(sm.conduitID, subType, subscriptionVersion(subType), subscriptionCondition(subType, userId))
+	}
+	return createSubscription(sm.client, userId, subType, sm.transport)
+}
+
+func (sm *subscriptionManager) registerAndSave() error {
+	if err := sm.register(); err != nil {
+		return err
+	}
+	sm.save()
+	return nil
+}
+
+// save copies our subscriptions, as twitch sees them, into the store.
+func (sm *subscriptionManager) save() {
+	if sm.store == nil || len(sm.transport.Method) == 0 {
+		return
+	}
+
+	subs, err := sm.list()
+	if err != nil {
+		log.Error(errors.Wrap(err, "unable to list subscriptions to save"))
+		return
+	}
+	saved := []storage.Subscription{}
+	for _, sub := range subs {
+		if !sm.owns(sub) {
+			continue
+		}
+		saved = append(saved, storage.Subscription{
+			ID:            sub.ID,
+			Type:          sub.Type,
+			Version:       sub.Version,
+			BroadcasterID: subscriptionUser(sub),
+			Status:        sub.Status,
+			Method:        sub.Transport.Method,
+			CreatedAt:     sub.CreatedAt.Time,
+			UpdatedAt:     time.Now(),
+		})
+	}
+	if err := sm.store.ReplaceSubscriptions(saved); err != nil {
+		log.Error(err)
+	}
+}
+
+func (sm *subscriptionManager) register() error {
+	// a websocket transport has nothing to subscribe against until the session is welcomed
+	if len(sm.transport.Method) == 0 {
+		return nil
+	}
+	if sm.transport.Method == conduit.Method {
+		return registerConduitSubscription(sm.client, sm.conduits, sm.conduitID, sm.userIDs, sm.authorized())
+	}
+	return registerSubscription(sm.client, sm.userIDs, sm.authorized(), sm.transport)
+}